@@ -3,7 +3,10 @@ package args
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/v3/args"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
@@ -12,28 +15,117 @@ import (
 const (
 	// Default concurrent workers count
 	DefaultMaxConcurrentWorkers = 10
+	// Default number of connection attempts
+	DefaultConnectionRetryAttempts = 3
+	// Default number of instances collected in parallel
+	DefaultMaxConcurrentInstances = 5
 )
 
+// Supported values for the AUTH_METHOD argument. An empty value keeps the legacy
+// behaviour of detecting the method from the credentials that were supplied.
+const (
+	AuthMethodSQL                     = "sql"
+	AuthMethodAzureServicePrincipal   = "azure_service_principal"
+	AuthMethodAzureManagedIdentity    = "azure_managed_identity"
+	AuthMethodAzureWorkloadIdentity   = "azure_workload_identity"
+	AuthMethodAzureDefault            = "azure_default"
+	AuthMethodAzureAccessTokenFile    = "azure_access_token_file"
+	AuthMethodKerberos                = "kerberos"
+	AuthMethodNTLM                    = "ntlm"
+	azureFederatedTokenFileEnvVarName = "AZURE_FEDERATED_TOKEN_FILE"
+)
+
+var authMethods = map[string]bool{
+	AuthMethodSQL:                   true,
+	AuthMethodAzureServicePrincipal: true,
+	AuthMethodAzureManagedIdentity:  true,
+	AuthMethodAzureWorkloadIdentity: true,
+	AuthMethodAzureDefault:          true,
+	AuthMethodAzureAccessTokenFile:  true,
+	AuthMethodKerberos:              true,
+	AuthMethodNTLM:                  true,
+}
+
+// Extended Events targets of the system_health session accepted by the DEADLOCK_TARGET argument
+const (
+	DeadlockTargetRingBuffer = "ring_buffer"
+	DeadlockTargetEventFile  = "event_file"
+)
+
+// tlsVersions are the values accepted by the MIN_TLS_VERSION argument
+var tlsVersions = map[string]bool{
+	"1.0": true,
+	"1.1": true,
+	"1.2": true,
+	"1.3": true,
+}
+
 // ArgumentList struct that holds all MSSQL arguments
 type ArgumentList struct {
 	sdkArgs.DefaultArgumentList
 	Username                                    string `default:"" help:"The Microsoft SQL Server connection user name"`
-	Password                                    string `default:"" help:"The Microsoft SQL Server connection password"`
+	Password                                    string `default:"" help:"The Microsoft SQL Server connection password. Accepts file:/path, env:NAME or exec:/path/to/helper references"`
 	ClientID                                    string `default:"" help:"Azure AD Service Principal client ID"`
 	TenantID                                    string `default:"" help:"Azure AD Service Principal tenant ID"`
-	ClientSecret                                string `default:"" help:"Azure AD Service Principal client secret"`
+	ClientSecret                                string `default:"" help:"Azure AD Service Principal client secret. Accepts file:/path, env:NAME or exec:/path/to/helper references"`
+	AuthMethod                                  string `default:"" help:"Authentication method: sql, kerberos, ntlm, azure_service_principal, azure_managed_identity, azure_workload_identity, azure_default or azure_access_token_file. Detected from the supplied credentials when empty"`
+	ManagedIdentityClientID                     string `default:"" help:"Client ID of a user-assigned Managed Identity. Leave empty to use the system-assigned identity"`
+	FederatedTokenFile                          string `default:"" help:"Path to the federated token file used by workload identity. Defaults to AZURE_FEDERATED_TOKEN_FILE"`
+	AccessTokenFile                             string `default:"" help:"Path to a file containing a pre-fetched Azure AD access token. The file is re-read on every connection"`
+	KerberosConfigFile                          string `default:"" help:"Path to krb5.conf used for Kerberos authentication. Defaults to KRB5_CONFIG or /etc/krb5.conf"`
+	KerberosKeytabFile                          string `default:"" help:"Path to the keytab holding the key for username. When empty the password is used to obtain a ticket"`
+	KerberosRealm                               string `default:"" help:"Kerberos realm of the login, e.g. CORP.EXAMPLE.COM"`
+	ServerSPN                                   string `default:"" help:"Overrides the SQL Server service principal name, e.g. MSSQLSvc/sql01.corp.example.com:1433"`
 	Instance                                    string `default:"" help:"The Microsoft SQL Server instance to connect to"`
 	Hostname                                    string `default:"127.0.0.1" help:"The Microsoft SQL Server connection host name"`
 	Port                                        string `default:"" help:"The Microsoft SQL Server port to connect to. Only needed when instance not specified"`
 	EnableSSL                                   bool   `default:"false" help:"If true will use SSL encryption, false will not use encryption"`
 	TrustServerCertificate                      bool   `default:"false" help:"If true server certificate is not verified for SSL. If false certificate will be verified against supplied certificate"`
 	CertificateLocation                         string `default:"" help:"Certificate file to verify SSL encryption against"`
+	StrictEncryption                            bool   `default:"false" help:"If true uses TDS 8.0 strict encryption (encrypt=strict), which encrypts the whole session including the login. Requires SQL Server 2022 or Azure SQL and always verifies the server certificate"`
+	MinTLSVersion                               string `default:"" help:"Lowest TLS version accepted: 1.0, 1.1, 1.2 or 1.3. Defaults to the driver minimum"`
+	HostNameInCertificate                       string `default:"" help:"Host name expected in the server certificate when it differs from hostname, e.g. when connecting through an alias or a load balancer"`
 	EnableBufferMetrics                         bool   `default:"true" help:"Enable collection of buffer space metrics."`
 	EnableDatabaseReserveMetrics                bool   `default:"true" help:"Enable collection of database reserve space metrics."`
+	EnableLogMetrics                            bool   `default:"false" help:"Enable collection of transaction log space, virtual log file, reuse wait and flush metrics per database."`
+	EnableBackupMetrics                         bool   `default:"false" help:"Enable collection of backup age, size and duration metrics per database."`
+	LogBackupWindowMinutes                      int    `default:"60" help:"Minutes a database in the FULL recovery model may go without a log backup before backup.logBackupOverdue is set to true"`
+	EnableAgentJobMetrics                       bool   `default:"false" help:"Enable collection of SQL Server Agent job outcome, duration and schedule metrics."`
+	EnableCPUMetrics                            bool   `default:"false" help:"Enable collection of SQL Server, other processes and idle CPU utilization."`
+	EnableDeadlockMetrics                       bool   `default:"false" help:"Enable reporting of the deadlocks recorded by the system_health Extended Events session in MSSQLDeadlockEvent."`
+	DeadlockTarget                              string `default:"ring_buffer" help:"Target of the system_health session deadlocks are read from: ring_buffer, or event_file for a longer history"`
+	EnableErrorLogMetrics                       bool   `default:"false" help:"Enable reporting of high severity errors, I/O errors, stack dumps and login failures from the error log in MSSQLErrorLogEvent. Needs membership in securityadmin"`
+	ErrorLogMinSeverity                         int    `default:"17" help:"Lowest severity of the errors reported from the error log"`
+	ErrorLogIncludePattern                      string `default:"" help:"Regular expression selecting additional error log lines to report"`
+	ErrorLogExcludePattern                      string `default:"" help:"Regular expression selecting error log lines not to report, e.g. 'Error: 18456.*State: 8'"`
+	ErrorLogMaxEvents                           int    `default:"100" help:"Maximum number of MSSQLErrorLogEvent reported per run"`
+	EnableMemoryPressureMetrics                 bool   `default:"false" help:"Enable collection of memory clerk, plan cache and memory grant metrics."`
+	EnableTempdbMetrics                         bool   `default:"false" help:"Enable collection of tempdb space usage, allocation contention and top consuming sessions."`
+	EnableIndexAdvisorMetrics                   bool   `default:"false" help:"Enable reporting of missing index suggestions and large unused indexes."`
+	IndexAdvisorInterval                        int    `default:"3600" help:"Minimum time in seconds between two collections of the index advisor metrics, at most one day"`
+	IndexAdvisorMaxResults                      int    `default:"20" help:"Maximum number of missing and of unused indexes reported per instance"`
+	EnableAvailabilityGroupMetrics              bool   `default:"false" help:"Enable collection of Always On availability group and replica metrics."`
+	EnableReplicationMetrics                    bool   `default:"false" help:"Enable collection of transactional replication subscription status, latency and undistributed commands on distributors."`
+	EnableFileIOMetrics                         bool   `default:"false" help:"Enable collection of per-file I/O and latency metrics. Values are computed between runs and need a cache_ttl longer than the interval."`
 	MaxConcurrentWorkers                        int    `default:"10" help:"Maximum number of simultaneous database connections to be used while collecting metrics."`
+	InstancesFile                               string `default:"" help:"YAML file listing several SQL Server instances to monitor from this process. Overrides hostname, port and instance"`
+	MaxConcurrentInstances                      int    `default:"5" help:"Maximum number of instances from instances_file collected in parallel"`
+	CollectionTimeout                           int    `default:"0" help:"Time budget in seconds for collecting an instance, split across the inventory, database, instance and query monitoring phases. Queries still running when a phase runs out are cancelled. Set 0 for no limit"`
+	ConnectionPoolMaxOpen                       int    `default:"0" help:"Maximum number of open connections in each per-database pool. Set 0 for no limit"`
+	ConnectionPoolMaxIdle                       int    `default:"2" help:"Maximum number of idle connections kept in each per-database pool. Set 0 to keep the driver default"`
+	ConnectionPoolMaxLifetime                   int    `default:"0" help:"Maximum time in seconds a pooled connection may be reused. Set 0 to reuse connections forever"`
 	Timeout                                     string `default:"30" help:"Timeout in seconds for a single SQL Query. Set 0 for no timeout"`
+	AppName                                     string `default:"nri-mssql" help:"Application name reported by the connections of the integration, shown as program_name in sp_who2 and sys.dm_exec_sessions"`
+	EnableSelfOverheadMetrics                   bool   `default:"false" help:"Enable reporting of the CPU and elapsed time consumed by the queries of the integration itself"`
+	ConnectionRetryAttempts                     int    `default:"3" help:"Maximum number of attempts to open a connection. Authentication failures are never retried"`
+	ConnectionRetryBackoffMs                    int    `default:"500" help:"Initial delay in milliseconds between connection attempts, doubled on every retry with jitter"`
+	ConnectionRetryMaxBackoffMs                 int    `default:"10000" help:"Maximum delay in milliseconds between connection attempts"`
+	ConnectionRetryDeadline                     int    `default:"60" help:"Maximum time in seconds spent retrying a connection. Set 0 for no deadline"`
 	CustomMetricsQuery                          string `default:"" help:"A SQL query to collect custom metrics. Query results 'metric_name', 'metric_value', and 'metric_type' have special meanings"`
 	CustomMetricsConfig                         string `default:"" help:"YAML configuration with one or more SQL queries to collect custom metrics"`
+	IgnoredWaitTypes                            string `default:"" help:"Comma separated wait types left out of MssqlWaitSample, a trailing * matches a prefix. When empty, idle and background waits are left out"`
+	PerfCountersConfig                          string `default:"" help:"YAML configuration selecting the performance counters reported in MssqlPerfCounterSample"`
+	PerfCounters                                string `default:"" help:"Comma separated performance counters reported in MssqlPerfCounterSample along with those of perf_counters_config, each as object_name:counter_name or object_name:counter_name:instance_name"`
 	ShowVersion                                 bool   `default:"false" help:"Print build information and exit"`
 	ExtraConnectionURLArgs                      string `default:"" help:"Appends additional parameters to connection url. Ex. 'applicationintent=readonly&foo=bar'"`
 	EnableDiskMetricsInBytes                    bool   `default:"true" help:"Enable collection of instance.diskInBytes."`
@@ -58,8 +150,34 @@ func (al ArgumentList) Validate() error {
 		al.Port = "1433"
 	}
 
-	if al.EnableSSL && (!al.TrustServerCertificate && al.CertificateLocation == "") {
-		return errors.New("invalid configuration: must specify a certificate file when using SSL and not trusting server certificate")
+	if err := al.validateTLS(); err != nil {
+		return err
+	}
+
+	if err := al.validateAuthMethod(); err != nil {
+		return err
+	}
+
+	if al.EnableBackupMetrics && al.LogBackupWindowMinutes <= 0 {
+		return errors.New("invalid configuration: log_backup_window_minutes must be greater than 0")
+	}
+
+	if al.EnableIndexAdvisorMetrics && (al.IndexAdvisorInterval <= 0 || al.IndexAdvisorMaxResults <= 0) {
+		return errors.New("invalid configuration: index_advisor_interval and index_advisor_max_results must be greater than 0")
+	}
+
+	if al.EnableDeadlockMetrics && al.DeadlockTarget != DeadlockTargetRingBuffer && al.DeadlockTarget != DeadlockTargetEventFile {
+		return fmt.Errorf("invalid configuration: unknown deadlock_target %q", al.DeadlockTarget)
+	}
+
+	if err := al.validateErrorLog(); err != nil {
+		return err
+	}
+
+	if al.InstancesFile != "" {
+		if _, err := os.Stat(al.InstancesFile); err != nil {
+			return errors.New("instances_file argument: " + err.Error())
+		}
 	}
 
 	if len(al.CustomMetricsConfig) > 0 {
@@ -71,9 +189,152 @@ func (al ArgumentList) Validate() error {
 		}
 	}
 
+	if len(al.PerfCountersConfig) > 0 {
+		if _, err := os.Stat(al.PerfCountersConfig); err != nil {
+			return errors.New("perf_counters_config argument: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateTLS checks that the encryption arguments can be combined
+func (al ArgumentList) validateTLS() error {
+	if al.EnableSSL && !al.StrictEncryption && (!al.TrustServerCertificate && al.CertificateLocation == "") {
+		return errors.New("invalid configuration: must specify a certificate file when using SSL and not trusting server certificate")
+	}
+
+	if al.StrictEncryption && al.TrustServerCertificate {
+		return errors.New("invalid configuration: strict_encryption always verifies the server certificate, trust_server_certificate cannot be true")
+	}
+
+	if al.MinTLSVersion != "" && !tlsVersions[al.MinTLSVersion] {
+		return fmt.Errorf("invalid configuration: unknown min_tls_version %q, expected 1.0, 1.1, 1.2 or 1.3", al.MinTLSVersion)
+	}
+
+	if al.HostNameInCertificate != "" && (!al.IsEncrypted() || al.TrustServerCertificate) {
+		return errors.New("invalid configuration: host_name_in_certificate requires enable_ssl or strict_encryption without trust_server_certificate")
+	}
+
+	return nil
+}
+
+// IsEncrypted returns true when the connection to the server is encrypted
+func (al ArgumentList) IsEncrypted() bool {
+	return al.EnableSSL || al.StrictEncryption
+}
+
+// validateAuthMethod checks that the requested authentication method is known and
+// that the arguments it depends on were supplied
+func (al ArgumentList) validateAuthMethod() error {
+	if al.AuthMethod == "" {
+		return nil
+	}
+
+	if !authMethods[al.AuthMethod] {
+		return fmt.Errorf("invalid configuration: unknown auth_method %q", al.AuthMethod)
+	}
+
+	switch al.AuthMethod {
+	case AuthMethodAzureServicePrincipal:
+		if al.ClientID == "" || al.TenantID == "" || al.ClientSecret == "" {
+			return errors.New("invalid configuration: client_id, tenant_id and client_secret are required for azure_service_principal authentication")
+		}
+	case AuthMethodAzureWorkloadIdentity:
+		if al.GetFederatedTokenFile() == "" {
+			return errors.New("invalid configuration: federated_token_file or AZURE_FEDERATED_TOKEN_FILE is required for azure_workload_identity authentication")
+		}
+	case AuthMethodAzureAccessTokenFile:
+		if al.AccessTokenFile == "" {
+			return errors.New("invalid configuration: access_token_file is required for azure_access_token_file authentication")
+		}
+		if _, err := os.Stat(al.AccessTokenFile); err != nil {
+			return errors.New("access_token_file argument: " + err.Error())
+		}
+	case AuthMethodKerberos:
+		return al.validateKerberos()
+	case AuthMethodNTLM:
+		if !strings.Contains(al.Username, `\`) || al.Password == "" {
+			return errors.New(`invalid configuration: ntlm authentication requires a username in DOMAIN\user form and a password`)
+		}
+		return nil
+	}
+
+	if al.Instance != "" && al.AuthMethod != AuthMethodSQL {
+		return errors.New("invalid configuration: instance is not supported with Azure AD authentication, specify a port instead")
+	}
+
+	return nil
+}
+
+// validateErrorLog checks the error log arguments when the error log is read
+func (al ArgumentList) validateErrorLog() error {
+	if !al.EnableErrorLogMetrics {
+		return nil
+	}
+	if al.ErrorLogMaxEvents <= 0 {
+		return errors.New("invalid configuration: error_log_max_events must be greater than 0")
+	}
+	if al.ErrorLogMinSeverity < 0 || al.ErrorLogMinSeverity > 25 {
+		return errors.New("invalid configuration: error_log_min_severity must be between 0 and 25")
+	}
+	if _, err := regexp.Compile(al.ErrorLogIncludePattern); err != nil {
+		return fmt.Errorf("invalid configuration: error_log_include_pattern: %w", err)
+	}
+	if _, err := regexp.Compile(al.ErrorLogExcludePattern); err != nil {
+		return fmt.Errorf("invalid configuration: error_log_exclude_pattern: %w", err)
+	}
+	return nil
+}
+
+// validateKerberos checks the arguments required to obtain a Kerberos ticket, either from a keytab
+// or from the username and password
+func (al ArgumentList) validateKerberos() error {
+	if al.Username == "" {
+		return errors.New("invalid configuration: username is required for kerberos authentication")
+	}
+	if al.KerberosRealm == "" {
+		return errors.New("invalid configuration: kerberos_realm is required for kerberos authentication")
+	}
+	if al.KerberosKeytabFile == "" && al.Password == "" {
+		return errors.New("invalid configuration: kerberos authentication requires either kerberos_keytab_file or password")
+	}
+	if al.KerberosConfigFile != "" {
+		if _, err := os.Stat(al.KerberosConfigFile); err != nil {
+			return errors.New("kerberos_config_file argument: " + err.Error())
+		}
+	}
+	if al.KerberosKeytabFile != "" {
+		if _, err := os.Stat(al.KerberosKeytabFile); err != nil {
+			return errors.New("kerberos_keytab_file argument: " + err.Error())
+		}
+	}
 	return nil
 }
 
+// GetFederatedTokenFile returns the workload identity token file, falling back to the
+// AZURE_FEDERATED_TOKEN_FILE environment variable injected by the AKS webhook
+func (al ArgumentList) GetFederatedTokenFile() string {
+	if al.FederatedTokenFile != "" {
+		return al.FederatedTokenFile
+	}
+	return os.Getenv(azureFederatedTokenFileEnvVarName)
+}
+
+func (al ArgumentList) GetConnectionRetryAttempts() int {
+	if al.ConnectionRetryAttempts <= 0 {
+		return DefaultConnectionRetryAttempts
+	}
+	return al.ConnectionRetryAttempts
+}
+
+func (al ArgumentList) GetMaxConcurrentInstances() int {
+	if al.MaxConcurrentInstances <= 0 {
+		return DefaultMaxConcurrentInstances
+	}
+	return al.MaxConcurrentInstances
+}
+
 func (al ArgumentList) GetMaxConcurrentWorkers() int {
 	if al.MaxConcurrentWorkers <= 0 {
 		return DefaultMaxConcurrentWorkers
@@ -69,6 +69,211 @@ func TestValidate(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"Strict Encryption Without Certificate File",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "90",
+				StrictEncryption:      true,
+				MinTLSVersion:         "1.2",
+				HostNameInCertificate: "sql01.example.com",
+			},
+			false,
+		},
+		{
+			"Strict Encryption Trusting Server Certificate",
+			&ArgumentList{
+				Hostname:               "localhost",
+				Port:                   "90",
+				StrictEncryption:       true,
+				TrustServerCertificate: true,
+			},
+			true,
+		},
+		{
+			"Unknown TLS Version",
+			&ArgumentList{
+				Hostname:      "localhost",
+				Port:          "90",
+				MinTLSVersion: "TLS1.2",
+			},
+			true,
+		},
+		{
+			"Backup Metrics Without Log Backup Window",
+			&ArgumentList{
+				Hostname:            "localhost",
+				Port:                "90",
+				EnableBackupMetrics: true,
+			},
+			true,
+		},
+		{
+			"Index Advisor Without Interval",
+			&ArgumentList{
+				Hostname:                  "localhost",
+				Port:                      "90",
+				EnableIndexAdvisorMetrics: true,
+				IndexAdvisorMaxResults:    20,
+			},
+			true,
+		},
+		{
+			"Missing Perf Counters Config",
+			&ArgumentList{
+				Hostname:           "localhost",
+				Port:               "90",
+				PerfCountersConfig: "/nonexistent/perf-counters.yml",
+			},
+			true,
+		},
+		{
+			"Unknown Deadlock Target",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "90",
+				EnableDeadlockMetrics: true,
+				DeadlockTarget:        "file",
+			},
+			true,
+		},
+		{
+			"Invalid Error Log Exclude Pattern",
+			&ArgumentList{
+				Hostname:               "localhost",
+				Port:                   "90",
+				EnableErrorLogMetrics:  true,
+				ErrorLogMaxEvents:      100,
+				ErrorLogMinSeverity:    17,
+				ErrorLogExcludePattern: "Error: (18456",
+			},
+			true,
+		},
+		{
+			"Host Name In Certificate Without SSL",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "90",
+				HostNameInCertificate: "sql01.example.com",
+			},
+			true,
+		},
+		{
+			"Host Name In Certificate Trusting Server Certificate",
+			&ArgumentList{
+				Hostname:               "localhost",
+				Port:                   "90",
+				EnableSSL:              true,
+				TrustServerCertificate: true,
+				HostNameInCertificate:  "sql01.example.com",
+			},
+			true,
+		},
+		{
+			"Unknown Auth Method",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "90",
+				AuthMethod: "password",
+			},
+			true,
+		},
+		{
+			"Managed Identity Auth Method",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "1433",
+				AuthMethod: AuthMethodAzureManagedIdentity,
+			},
+			false,
+		},
+		{
+			"Service Principal Auth Method Missing Secret",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "1433",
+				AuthMethod: AuthMethodAzureServicePrincipal,
+				ClientID:   "client",
+				TenantID:   "tenant",
+			},
+			true,
+		},
+		{
+			"Access Token File Auth Method Missing File",
+			&ArgumentList{
+				Hostname:        "localhost",
+				Port:            "1433",
+				AuthMethod:      AuthMethodAzureAccessTokenFile,
+				AccessTokenFile: "/does/not/exist",
+			},
+			true,
+		},
+		{
+			"Kerberos Without Realm",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "1433",
+				AuthMethod: AuthMethodKerberos,
+				Username:   "svc-newrelic",
+				Password:   "secret",
+			},
+			true,
+		},
+		{
+			"Kerberos With Password",
+			&ArgumentList{
+				Hostname:      "localhost",
+				Instance:      "SQLExpress",
+				AuthMethod:    AuthMethodKerberos,
+				Username:      "svc-newrelic",
+				Password:      "secret",
+				KerberosRealm: "CORP.EXAMPLE.COM",
+			},
+			false,
+		},
+		{
+			"Kerberos Missing Keytab File",
+			&ArgumentList{
+				Hostname:           "localhost",
+				Port:               "1433",
+				AuthMethod:         AuthMethodKerberos,
+				Username:           "svc-newrelic",
+				KerberosRealm:      "CORP.EXAMPLE.COM",
+				KerberosKeytabFile: "/does/not/exist.keytab",
+			},
+			true,
+		},
+		{
+			"NTLM Without Domain",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "1433",
+				AuthMethod: AuthMethodNTLM,
+				Username:   "svc-newrelic",
+				Password:   "secret",
+			},
+			true,
+		},
+		{
+			"NTLM With Domain",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Port:       "1433",
+				AuthMethod: AuthMethodNTLM,
+				Username:   `CORP\svc-newrelic`,
+				Password:   "secret",
+			},
+			false,
+		},
+		{
+			"Azure AD Auth Method With Instance",
+			&ArgumentList{
+				Hostname:   "localhost",
+				Instance:   "SQLExpress",
+				AuthMethod: AuthMethodAzureDefault,
+			},
+			true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -81,6 +286,24 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateWorkloadIdentityTokenFile(t *testing.T) {
+	al := ArgumentList{
+		Hostname:   "localhost",
+		Port:       "1433",
+		AuthMethod: AuthMethodAzureWorkloadIdentity,
+	}
+
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	assert.Error(t, al.Validate())
+
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "/var/run/secrets/azure/tokens/azure-identity-token")
+	assert.NoError(t, al.Validate())
+	assert.Equal(t, "/var/run/secrets/azure/tokens/azure-identity-token", al.GetFederatedTokenFile())
+
+	al.FederatedTokenFile = "/custom/token"
+	assert.Equal(t, "/custom/token", al.GetFederatedTokenFile())
+}
+
 func TestGetMaxConcurrentWorkers(t *testing.T) {
 	testCases := []struct {
 		name                 string
@@ -116,3 +339,9 @@ func TestGetMaxConcurrentWorkers(t *testing.T) {
 		})
 	}
 }
+
+func TestGetConnectionRetryAttempts(t *testing.T) {
+	assert.Equal(t, DefaultConnectionRetryAttempts, ArgumentList{}.GetConnectionRetryAttempts())
+	assert.Equal(t, DefaultConnectionRetryAttempts, ArgumentList{ConnectionRetryAttempts: -1}.GetConnectionRetryAttempts())
+	assert.Equal(t, 1, ArgumentList{ConnectionRetryAttempts: 1}.GetConnectionRetryAttempts())
+}
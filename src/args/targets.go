@@ -0,0 +1,91 @@
+package args
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Target is a single SQL Server listed in the instances file. Empty fields inherit the
+// value configured for the integration itself.
+type Target struct {
+	Hostname              string            `yaml:"hostname"`
+	Port                  string            `yaml:"port"`
+	Instance              string            `yaml:"instance"`
+	Username              string            `yaml:"username"`
+	Password              string            `yaml:"password"`
+	AuthMethod            string            `yaml:"auth_method"`
+	ClientID              string            `yaml:"client_id"`
+	TenantID              string            `yaml:"tenant_id"`
+	ClientSecret          string            `yaml:"client_secret"`
+	Timeout               string            `yaml:"timeout"`
+	CollectionTimeout     int               `yaml:"collection_timeout"`
+	HostNameInCertificate string            `yaml:"host_name_in_certificate"`
+	Labels                map[string]string `yaml:"labels"`
+}
+
+type instancesFile struct {
+	Instances []Target `yaml:"instances"`
+}
+
+// LoadTargets reads the list of SQL Servers to monitor from the instances file at path
+func LoadTargets(path string) ([]Target, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances file: %w", err)
+	}
+
+	var f instancesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse instances file: %w", err)
+	}
+
+	if len(f.Instances) == 0 {
+		return nil, errors.New("instances file does not list any instance")
+	}
+
+	for idx, t := range f.Instances {
+		if t.Hostname == "" {
+			return nil, fmt.Errorf("instances file entry %d: hostname is required", idx)
+		}
+		if t.Port != "" && t.Instance != "" {
+			return nil, fmt.Errorf("instances file entry %d: specify either port or instance but not both", idx)
+		}
+	}
+
+	return f.Instances, nil
+}
+
+// ForTarget returns a copy of the arguments pointing at t. Connection fields of the
+// integration are only inherited when the target does not set its own.
+func (al ArgumentList) ForTarget(t Target) ArgumentList {
+	target := al
+	target.Hostname = t.Hostname
+	target.Port = t.Port
+	target.Instance = t.Instance
+	// the certificate name belongs to the host, so it is never inherited
+	target.HostNameInCertificate = t.HostNameInCertificate
+
+	if t.Username != "" {
+		target.Username = t.Username
+		target.Password = t.Password
+	}
+	if t.AuthMethod != "" {
+		target.AuthMethod = t.AuthMethod
+	}
+	if t.ClientID != "" {
+		target.ClientID = t.ClientID
+		target.TenantID = t.TenantID
+		target.ClientSecret = t.ClientSecret
+	}
+	if t.Timeout != "" {
+		target.Timeout = t.Timeout
+	}
+	if t.CollectionTimeout > 0 {
+		target.CollectionTimeout = t.CollectionTimeout
+	}
+
+	return target
+}
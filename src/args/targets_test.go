@@ -0,0 +1,102 @@
+package args
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeInstancesFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "instances.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadTargets(t *testing.T) {
+	path := writeInstancesFile(t, `
+instances:
+  - hostname: sql01
+    port: 1433
+    username: monitor
+    password: env:SQL01_PASSWORD
+    collection_timeout: 20
+    labels:
+      env: production
+  - hostname: sql02
+    instance: REPORTING
+`)
+
+	targets, err := LoadTargets(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Target{
+		{
+			Hostname:          "sql01",
+			Port:              "1433",
+			Username:          "monitor",
+			Password:          "env:SQL01_PASSWORD",
+			CollectionTimeout: 20,
+			Labels:            map[string]string{"env": "production"},
+		},
+		{
+			Hostname: "sql02",
+			Instance: "REPORTING",
+		},
+	}, targets)
+}
+
+func TestLoadTargets_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{"Empty list", "instances: []"},
+		{"Missing hostname", "instances:\n  - port: 1433"},
+		{"Port and instance", "instances:\n  - hostname: sql01\n    port: 1433\n    instance: REPORTING"},
+		{"Invalid YAML", "instances: ["},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadTargets(writeInstancesFile(t, tc.content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadTargets(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestForTarget(t *testing.T) {
+	base := ArgumentList{
+		Hostname:              "127.0.0.1",
+		Port:                  "1433",
+		Username:              "shared",
+		Password:              "shared-password",
+		Timeout:               "30",
+		CollectionTimeout:     60,
+		EnableSSL:             true,
+		HostNameInCertificate: "listener.example.com",
+	}
+
+	inherited := base.ForTarget(Target{Hostname: "sql01", Instance: "REPORTING"})
+	assert.Equal(t, "sql01", inherited.Hostname)
+	assert.Equal(t, "", inherited.Port)
+	assert.Equal(t, "REPORTING", inherited.Instance)
+	assert.Equal(t, "shared", inherited.Username)
+	assert.Equal(t, "shared-password", inherited.Password)
+	assert.Equal(t, 60, inherited.CollectionTimeout)
+	assert.True(t, inherited.EnableSSL)
+	assert.Equal(t, "", inherited.HostNameInCertificate)
+
+	overridden := base.ForTarget(Target{Hostname: "sql02", Port: "1444", Username: "own", Timeout: "5", CollectionTimeout: 10, HostNameInCertificate: "sql02.example.com"})
+	assert.Equal(t, "own", overridden.Username)
+	assert.Equal(t, "", overridden.Password)
+	assert.Equal(t, "5", overridden.Timeout)
+	assert.Equal(t, 10, overridden.CollectionTimeout)
+	assert.Equal(t, "sql02.example.com", overridden.HostNameInCertificate)
+
+	assert.Equal(t, "127.0.0.1", base.Hostname)
+}
@@ -0,0 +1,203 @@
+// Package availabilitygroup collects the state of the Always On availability groups the instance takes part in
+package availabilitygroup
+
+import (
+	"context"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/metrics"
+)
+
+// Entity namespaces of the availability groups and of their replicas
+const (
+	availabilityGroupNamespace = "ms-availability-group"
+	replicaNamespace           = "ms-availability-replica"
+)
+
+// availabilityGroupQuery lists the groups whose primary replica is the local instance. Secondaries
+// do not report the group so that it is not reported once per replica.
+const availabilityGroupQuery = `SELECT
+		ag.name AS ag_name,
+		CONVERT(NVARCHAR(36), ag.group_id) AS ag_id,
+		ags.primary_replica,
+		ags.synchronization_health_desc AS synchronization_health,
+		ags.primary_recovery_health_desc AS primary_recovery_health,
+		ag.failure_condition_level,
+		(SELECT COUNT(*) FROM sys.availability_replicas r WHERE r.group_id = ag.group_id) AS replica_count
+		FROM sys.availability_groups ag
+		JOIN sys.dm_hadr_availability_group_states ags ON ags.group_id = ag.group_id
+		JOIN sys.dm_hadr_availability_replica_states lrs ON lrs.group_id = ag.group_id AND lrs.is_local = 1
+		WHERE lrs.role_desc = 'PRIMARY'`
+
+// replicaQuery returns every replica of the groups whose primary replica is the local instance. A
+// secondary returns only its own replica so that its state is known when the primary is down or
+// not monitored. is_local tells the two views of a secondary apart.
+const replicaQuery = `SELECT
+		ag.name AS ag_name,
+		CONVERT(NVARCHAR(36), ag.group_id) AS ag_id,
+		ar.replica_server_name,
+		ars.role_desc AS role,
+		ars.operational_state_desc AS operational_state,
+		ars.connected_state_desc AS connected_state,
+		ars.synchronization_health_desc AS synchronization_health,
+		ar.availability_mode_desc AS availability_mode,
+		ar.failover_mode_desc AS failover_mode,
+		CAST(ars.is_local AS INT) AS is_local
+		FROM sys.availability_groups ag
+		JOIN sys.availability_replicas ar ON ar.group_id = ag.group_id
+		JOIN sys.dm_hadr_availability_replica_states ars ON ars.replica_id = ar.replica_id
+		JOIN sys.dm_hadr_availability_replica_states lrs ON lrs.group_id = ag.group_id AND lrs.is_local = 1
+		WHERE lrs.role_desc = 'PRIMARY' OR ars.is_local = 1`
+
+// databaseReplicaQuery returns the state of each database on each replica, read on the primary and
+// limited to the local replica on a secondary like replicaQuery. The estimated data loss compares the
+// last commit on the replica with the one on the primary, which a secondary only knows while connected.
+const databaseReplicaQuery = `SELECT
+		ag.name AS ag_name,
+		CONVERT(NVARCHAR(36), ag.group_id) AS ag_id,
+		ar.replica_server_name,
+		adc.database_name AS db_name,
+		drs.synchronization_state_desc AS synchronization_state,
+		drs.synchronization_health_desc AS synchronization_health,
+		CAST(drs.is_suspended AS INT) AS is_suspended,
+		drs.log_send_queue_size,
+		drs.log_send_rate,
+		drs.redo_queue_size,
+		drs.redo_rate,
+		DATEDIFF(SECOND, drs.last_commit_time, pdrs.last_commit_time) AS estimated_data_loss_seconds,
+		CAST(drcs.is_failover_ready AS INT) AS is_failover_ready,
+		CAST(drs.is_local AS INT) AS is_local
+		FROM sys.dm_hadr_database_replica_states drs
+		JOIN sys.availability_groups ag ON ag.group_id = drs.group_id
+		JOIN sys.availability_replicas ar ON ar.replica_id = drs.replica_id
+		JOIN sys.availability_databases_cluster adc ON adc.group_id = drs.group_id AND adc.group_database_id = drs.group_database_id
+		LEFT JOIN sys.dm_hadr_database_replica_cluster_states drcs ON drcs.replica_id = drs.replica_id AND drcs.group_database_id = drs.group_database_id
+		LEFT JOIN sys.dm_hadr_database_replica_states pdrs ON pdrs.group_database_id = drs.group_database_id AND pdrs.is_primary_replica = 1
+		JOIN sys.dm_hadr_availability_replica_states lrs ON lrs.group_id = drs.group_id AND lrs.is_local = 1
+		WHERE lrs.role_desc = 'PRIMARY' OR drs.is_local = 1`
+
+// AvailabilityGroupRow is a row result in the availabilityGroupQuery
+type AvailabilityGroupRow struct {
+	Name                  string  `db:"ag_name"`
+	GroupID               string  `db:"ag_id"`
+	PrimaryReplica        *string `db:"primary_replica" metric_name:"ag.primaryReplica" source_type:"attribute"`
+	SynchronizationHealth *string `db:"synchronization_health" metric_name:"ag.synchronizationHealth" source_type:"attribute"`
+	PrimaryRecoveryHealth *string `db:"primary_recovery_health" metric_name:"ag.primaryRecoveryHealth" source_type:"attribute"`
+	FailureConditionLevel *int64  `db:"failure_condition_level" metric_name:"ag.failureConditionLevel" source_type:"gauge"`
+	ReplicaCount          *int64  `db:"replica_count" metric_name:"ag.replicas" source_type:"gauge"`
+}
+
+// ReplicaRow is a row result in the replicaQuery
+type ReplicaRow struct {
+	AvailabilityGroup     string  `db:"ag_name"`
+	GroupID               string  `db:"ag_id"`
+	ServerName            string  `db:"replica_server_name"`
+	Role                  *string `db:"role" metric_name:"replica.role" source_type:"attribute"`
+	OperationalState      *string `db:"operational_state" metric_name:"replica.operationalState" source_type:"attribute"`
+	ConnectedState        *string `db:"connected_state" metric_name:"replica.connectedState" source_type:"attribute"`
+	SynchronizationHealth *string `db:"synchronization_health" metric_name:"replica.synchronizationHealth" source_type:"attribute"`
+	AvailabilityMode      *string `db:"availability_mode" metric_name:"replica.availabilityMode" source_type:"attribute"`
+	FailoverMode          *string `db:"failover_mode" metric_name:"replica.failoverMode" source_type:"attribute"`
+	IsLocal               *int64  `db:"is_local" metric_name:"replica.isLocal" source_type:"gauge"`
+}
+
+// DatabaseReplicaRow is a row result in the databaseReplicaQuery
+type DatabaseReplicaRow struct {
+	AvailabilityGroup        string  `db:"ag_name"`
+	GroupID                  string  `db:"ag_id"`
+	ServerName               string  `db:"replica_server_name"`
+	DBName                   string  `db:"db_name"`
+	SynchronizationState     *string `db:"synchronization_state" metric_name:"replica.synchronizationState" source_type:"attribute"`
+	SynchronizationHealth    *string `db:"synchronization_health" metric_name:"replica.synchronizationHealth" source_type:"attribute"`
+	IsSuspended              *int64  `db:"is_suspended" metric_name:"replica.isSuspended" source_type:"gauge"`
+	LogSendQueueSize         *int64  `db:"log_send_queue_size" metric_name:"replica.logSendQueueSizeInKilobytes" source_type:"gauge"`
+	LogSendRate              *int64  `db:"log_send_rate" metric_name:"replica.logSendRateInKilobytesPerSecond" source_type:"gauge"`
+	RedoQueueSize            *int64  `db:"redo_queue_size" metric_name:"replica.redoQueueSizeInKilobytes" source_type:"gauge"`
+	RedoRate                 *int64  `db:"redo_rate" metric_name:"replica.redoRateInKilobytesPerSecond" source_type:"gauge"`
+	EstimatedDataLossSeconds *int64  `db:"estimated_data_loss_seconds" metric_name:"replica.estimatedDataLossInSeconds" source_type:"gauge"`
+	IsFailoverReady          *int64  `db:"is_failover_ready" metric_name:"replica.isFailoverReady" source_type:"gauge"`
+	IsLocal                  *int64  `db:"is_local" metric_name:"replica.isLocal" source_type:"gauge"`
+}
+
+type availabilityGroupCollector func(context.Context, *integration.Integration, *connection.SQLConnection) error
+
+// Azure SQL Database does not expose the HADR views, its geo-replication is not an availability group
+var collectorSet = metrics.EngineSet[availabilityGroupCollector]{
+	Default:                 collectAvailabilityGroups,
+	AzureSQLDatabase:        skipAvailabilityGroups,
+	AzureSQLManagedInstance: collectAvailabilityGroups,
+}
+
+// PopulateAvailabilityGroupMetrics creates an entity for each availability group and each of its
+// replicas and reports their synchronization state
+func PopulateAvailabilityGroupMetrics(ctx context.Context, i *integration.Integration, con *connection.SQLConnection, engineEdition int) {
+	if err := collectorSet.Select(engineEdition)(ctx, i, con); err != nil {
+		log.Error("Could not collect availability group metrics: %s", err.Error())
+	}
+}
+
+func skipAvailabilityGroups(context.Context, *integration.Integration, *connection.SQLConnection) error {
+	log.Debug("Skipping availability group metrics, not supported by Azure SQL Database")
+	return nil
+}
+
+func collectAvailabilityGroups(ctx context.Context, i *integration.Integration, con *connection.SQLConnection) error {
+	groups := make([]*AvailabilityGroupRow, 0)
+	if err := con.QueryContext(ctx, &groups, availabilityGroupQuery); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		entity, err := i.EntityReportedVia(con.Host, group.Name, availabilityGroupNamespace, groupIDAttribute(group.GroupID))
+		if err != nil {
+			return err
+		}
+		metrics.MarshalEntitySample(entity, con.Host, "MssqlAvailabilityGroupSample", group,
+			attribute.Attribute{Key: "availabilityGroup", Value: group.Name})
+	}
+
+	replicas := make([]*ReplicaRow, 0)
+	if err := con.QueryContext(ctx, &replicas, replicaQuery); err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		entity, err := replicaEntity(i, con.Host, replica.GroupID, replica.ServerName)
+		if err != nil {
+			return err
+		}
+		metrics.MarshalEntitySample(entity, con.Host, "MssqlAvailabilityReplicaSample", replica,
+			attribute.Attribute{Key: "availabilityGroup", Value: replica.AvailabilityGroup},
+			attribute.Attribute{Key: "replica", Value: replica.ServerName})
+	}
+
+	databaseReplicas := make([]*DatabaseReplicaRow, 0)
+	if err := con.QueryContext(ctx, &databaseReplicas, databaseReplicaQuery); err != nil {
+		return err
+	}
+	for _, databaseReplica := range databaseReplicas {
+		entity, err := replicaEntity(i, con.Host, databaseReplica.GroupID, databaseReplica.ServerName)
+		if err != nil {
+			return err
+		}
+		metrics.MarshalEntitySample(entity, con.Host, "MssqlDatabaseReplicaSample", databaseReplica,
+			attribute.Attribute{Key: "availabilityGroup", Value: databaseReplica.AvailabilityGroup},
+			attribute.Attribute{Key: "replica", Value: databaseReplica.ServerName},
+			attribute.Attribute{Key: "database", Value: databaseReplica.DBName})
+	}
+
+	return nil
+}
+
+// groupIDAttribute identifies an availability group by its id, as groups of different clusters may
+// share a name
+func groupIDAttribute(groupID string) integration.IDAttribute {
+	return integration.NewIDAttribute("availabilityGroupId", groupID)
+}
+
+// replicaEntity returns the entity of the replica hosted by serverName, identified by its group
+// because an instance can host replicas of several groups
+func replicaEntity(i *integration.Integration, host, groupID, serverName string) (*integration.Entity, error) {
+	return i.EntityReportedVia(host, serverName, replicaNamespace, groupIDAttribute(groupID))
+}
@@ -0,0 +1,177 @@
+package availabilitygroup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func findEntity(t *testing.T, i *integration.Integration, namespace, name string) *integration.Entity {
+	t.Helper()
+	for _, e := range i.Entities {
+		if e.Metadata.Namespace == namespace && e.Metadata.Name == name {
+			return e
+		}
+	}
+	t.Fatalf("entity %s:%s not found", namespace, name)
+	return nil
+}
+
+func TestPopulateAvailabilityGroupMetrics(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.availability_groups ag\s+JOIN sys\.dm_hadr_availability_group_states`).WillReturnRows(
+		sqlmock.NewRows([]string{"ag_name", "primary_replica", "synchronization_health", "primary_recovery_health", "failure_condition_level", "replica_count"}).
+			AddRow("ag-sales", "SQL01", "HEALTHY", "ONLINE", 3, 2))
+	mock.ExpectQuery(`JOIN sys\.dm_hadr_availability_replica_states ars`).WillReturnRows(
+		sqlmock.NewRows([]string{"ag_name", "replica_server_name", "role", "operational_state", "connected_state", "synchronization_health", "availability_mode", "failover_mode", "is_local"}).
+			AddRow("ag-sales", "SQL01", "PRIMARY", "ONLINE", "CONNECTED", "HEALTHY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", 1).
+			AddRow("ag-sales", "SQL02", "SECONDARY", nil, "CONNECTED", "HEALTHY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", 0))
+	mock.ExpectQuery(`FROM sys\.dm_hadr_database_replica_states drs`).WillReturnRows(
+		sqlmock.NewRows([]string{"ag_name", "replica_server_name", "db_name", "synchronization_state", "synchronization_health", "is_suspended", "log_send_queue_size", "log_send_rate", "redo_queue_size", "redo_rate", "estimated_data_loss_seconds", "is_failover_ready"}).
+			AddRow("ag-sales", "SQL01", "sales", "SYNCHRONIZED", "HEALTHY", 0, nil, nil, nil, nil, 0, 1).
+			AddRow("ag-sales", "SQL02", "sales", "SYNCHRONIZING", "PARTIALLY_HEALTHY", 0, 2048, 512, 4096, 1024, 12, 0))
+
+	PopulateAvailabilityGroupMetrics(context.Background(), i, conn, 0)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	group := findEntity(t, i, availabilityGroupNamespace, "ag-sales")
+	require.Len(t, group.Metrics, 1)
+	assert.Equal(t, "MssqlAvailabilityGroupSample", group.Metrics[0].Metrics["event_type"])
+	assert.Equal(t, "SQL01", group.Metrics[0].Metrics["ag.primaryReplica"])
+	assert.Equal(t, float64(2), group.Metrics[0].Metrics["ag.replicas"])
+
+	primary := findEntity(t, i, replicaNamespace, "SQL01")
+	require.Len(t, primary.Metrics, 2)
+	assert.Equal(t, "PRIMARY", primary.Metrics[0].Metrics["replica.role"])
+	assert.NotContains(t, primary.Metrics[1].Metrics, "replica.logSendQueueSizeInKilobytes")
+
+	secondary := findEntity(t, i, replicaNamespace, "SQL02")
+	require.Len(t, secondary.Metrics, 2)
+	assert.NotContains(t, secondary.Metrics[0].Metrics, "replica.operationalState")
+	databaseReplica := secondary.Metrics[1].Metrics
+	assert.Equal(t, "MssqlDatabaseReplicaSample", databaseReplica["event_type"])
+	assert.Equal(t, "sales", databaseReplica["database"])
+	assert.Equal(t, "ag-sales", databaseReplica["availabilityGroup"])
+	assert.Equal(t, "SYNCHRONIZING", databaseReplica["replica.synchronizationState"])
+	assert.Equal(t, float64(2048), databaseReplica["replica.logSendQueueSizeInKilobytes"])
+	assert.Equal(t, float64(4096), databaseReplica["replica.redoQueueSizeInKilobytes"])
+	assert.Equal(t, float64(12), databaseReplica["replica.estimatedDataLossInSeconds"])
+	assert.Equal(t, float64(0), databaseReplica["replica.isFailoverReady"])
+}
+
+func TestPopulateAvailabilityGroupMetrics_TwoNodes(t *testing.T) {
+	// the primary returns every replica, a secondary only its own
+	primaryOnly := `lrs\.is_local = 1\s+WHERE lrs\.role_desc = 'PRIMARY'`
+	primaryOrLocal := `WHERE lrs\.role_desc = 'PRIMARY' OR \w+\.is_local = 1`
+	replicaColumns := []string{"ag_name", "replica_server_name", "role", "operational_state", "connected_state", "synchronization_health", "availability_mode", "failover_mode", "is_local"}
+	databaseReplicaColumns := []string{"ag_name", "replica_server_name", "db_name", "synchronization_state", "synchronization_health", "is_suspended", "log_send_queue_size", "log_send_rate", "redo_queue_size", "redo_rate", "estimated_data_loss_seconds", "is_failover_ready", "is_local"}
+
+	primary, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	primaryConn, primaryMock := connection.CreateMockSQL(t)
+	primaryMock.ExpectQuery(`sys\.dm_hadr_availability_group_states[\s\S]*` + primaryOnly + ` /\*`).WillReturnRows(
+		sqlmock.NewRows([]string{"ag_name", "primary_replica", "synchronization_health", "primary_recovery_health", "failure_condition_level", "replica_count"}).
+			AddRow("ag-sales", "SQL01", "HEALTHY", "ONLINE", 3, 2))
+	primaryMock.ExpectQuery(`ars\.replica_id = ar\.replica_id[\s\S]*` + primaryOrLocal).WillReturnRows(
+		sqlmock.NewRows(replicaColumns).
+			AddRow("ag-sales", "SQL01", "PRIMARY", "ONLINE", "CONNECTED", "HEALTHY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", 1).
+			AddRow("ag-sales", "SQL02", "SECONDARY", nil, "CONNECTED", "HEALTHY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", 0))
+	primaryMock.ExpectQuery(`FROM sys\.dm_hadr_database_replica_states drs[\s\S]*` + primaryOrLocal).WillReturnRows(
+		sqlmock.NewRows(databaseReplicaColumns).
+			AddRow("ag-sales", "SQL01", "sales", "SYNCHRONIZED", "HEALTHY", 0, nil, nil, nil, nil, 0, 1, 1).
+			AddRow("ag-sales", "SQL02", "sales", "SYNCHRONIZING", "HEALTHY", 0, 0, 0, 0, 0, 0, 1, 0))
+
+	secondary, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	secondaryConn, secondaryMock := connection.CreateMockSQL(t)
+	secondaryMock.ExpectQuery(`sys\.dm_hadr_availability_group_states[\s\S]*` + primaryOnly + ` /\*`).WillReturnRows(
+		sqlmock.NewRows([]string{"ag_name", "primary_replica", "synchronization_health", "primary_recovery_health", "failure_condition_level", "replica_count"}))
+	secondaryMock.ExpectQuery(`ars\.replica_id = ar\.replica_id[\s\S]*` + primaryOrLocal).WillReturnRows(
+		sqlmock.NewRows(replicaColumns).
+			AddRow("ag-sales", "SQL02", "SECONDARY", "ONLINE", "DISCONNECTED", "NOT_HEALTHY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", 1))
+	secondaryMock.ExpectQuery(`FROM sys\.dm_hadr_database_replica_states drs[\s\S]*` + primaryOrLocal).WillReturnRows(
+		sqlmock.NewRows(databaseReplicaColumns).
+			AddRow("ag-sales", "SQL02", "sales", "NOT SYNCHRONIZING", "NOT_HEALTHY", 0, nil, nil, 0, 0, nil, nil, 1))
+
+	PopulateAvailabilityGroupMetrics(context.Background(), primary, primaryConn, 0)
+	PopulateAvailabilityGroupMetrics(context.Background(), secondary, secondaryConn, 0)
+	require.NoError(t, primaryMock.ExpectationsWereMet())
+	require.NoError(t, secondaryMock.ExpectationsWereMet())
+
+	for _, name := range []string{"SQL01", "SQL02"} {
+		replica := findEntity(t, primary, replicaNamespace, name)
+		assert.Len(t, replica.Metrics, 2, "one replica and one database replica sample for %s", name)
+	}
+
+	require.Len(t, secondary.Entities, 1, "the secondary reports its own replica only")
+	local := findEntity(t, secondary, replicaNamespace, "SQL02")
+	require.Len(t, local.Metrics, 2)
+	assert.Equal(t, "DISCONNECTED", local.Metrics[0].Metrics["replica.connectedState"])
+	assert.Equal(t, float64(1), local.Metrics[0].Metrics["replica.isLocal"])
+	assert.Equal(t, "NOT SYNCHRONIZING", local.Metrics[1].Metrics["replica.synchronizationState"])
+	assert.Equal(t, float64(1), local.Metrics[1].Metrics["replica.isLocal"])
+}
+
+func TestPopulateAvailabilityGroupMetrics_SameNameInTwoClusters(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+
+	for _, groupID := range []string{"1c9f4ad2-62b0-4b8e-9d59-2a5f0d6c1e01", "7e2b8c4f-0d3a-4f6e-8b1c-5a9d3e7f2b02"} {
+		conn, mock := connection.CreateMockSQL(t)
+		mock.ExpectQuery(`sys\.dm_hadr_availability_group_states`).WillReturnRows(
+			sqlmock.NewRows([]string{"ag_name", "ag_id", "primary_replica", "synchronization_health", "primary_recovery_health", "failure_condition_level", "replica_count"}).
+				AddRow("AG1", groupID, "SQL01", "HEALTHY", "ONLINE", 3, 2))
+		mock.ExpectQuery(`ars\.replica_id = ar\.replica_id`).WillReturnRows(
+			sqlmock.NewRows([]string{"ag_name", "ag_id", "replica_server_name", "role", "is_local"}).
+				AddRow("AG1", groupID, "SQL01", "PRIMARY", 1))
+		mock.ExpectQuery(`FROM sys\.dm_hadr_database_replica_states drs`).WillReturnRows(
+			sqlmock.NewRows([]string{"ag_name", "ag_id", "replica_server_name", "db_name"}))
+
+		PopulateAvailabilityGroupMetrics(context.Background(), i, conn, 0)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	groups, replicas := 0, 0
+	for _, e := range i.Entities {
+		switch e.Metadata.Namespace {
+		case availabilityGroupNamespace:
+			groups++
+		case replicaNamespace:
+			replicas++
+		}
+	}
+	assert.Equal(t, 2, groups, "groups of different clusters sharing a name are different entities")
+	assert.Equal(t, 2, replicas)
+}
+
+func TestPopulateAvailabilityGroupMetrics_QueryError(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.availability_groups`).WillReturnError(errors.New("VIEW SERVER STATE permission denied"))
+
+	PopulateAvailabilityGroupMetrics(context.Background(), i, conn, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, i.Entities)
+}
+
+func TestPopulateAvailabilityGroupMetrics_AzureSQLDatabase(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	PopulateAvailabilityGroupMetrics(context.Background(), i, conn, database.AzureSQLDatabaseEngineEditionNumber)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, i.Entities)
+}
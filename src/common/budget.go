@@ -0,0 +1,80 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Phase is a step of a collection run that receives a share of the time budget
+// proportional to its weight
+type Phase struct {
+	Name   string
+	Weight int
+}
+
+// Phases of a collection run, in the order they are executed
+var (
+	PhaseInventory       = Phase{Name: "inventory", Weight: 1}
+	PhaseDatabaseMetrics = Phase{Name: "database metrics", Weight: 3}
+	PhaseInstanceMetrics = Phase{Name: "instance metrics", Weight: 2}
+	PhaseQueryMonitoring = Phase{Name: "query monitoring", Weight: 4}
+)
+
+// package-level variable so the clock can be replaced in unit tests
+var now = time.Now
+
+// Budget splits the time allowed for collecting an instance across the phases of the run.
+// Each phase gets a share of the total proportional to its weight, plus whatever earlier
+// phases finished without using.
+type Budget struct {
+	mu          sync.Mutex
+	total       time.Duration
+	totalWeight int
+	pending     map[string]int
+	carry       time.Duration
+}
+
+// NewBudget creates a budget of timeout shared by phases. A non-positive timeout creates
+// a budget that never cancels.
+func NewBudget(timeout time.Duration, phases ...Phase) *Budget {
+	b := &Budget{total: timeout, pending: make(map[string]int, len(phases))}
+	for _, phase := range phases {
+		b.pending[phase.Name] = phase.Weight
+		b.totalWeight += phase.Weight
+	}
+	return b
+}
+
+// Start returns the context bounding phase. Outstanding queries are cancelled when the
+// share of the phase runs out. Calling the returned cancel function ends the phase and
+// hands the time it did not use to the next one.
+func (b *Budget) Start(ctx context.Context, phase Phase) (context.Context, context.CancelFunc) {
+	if b == nil || b.total <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	b.mu.Lock()
+	share := b.carry
+	if weight, ok := b.pending[phase.Name]; ok && b.totalWeight > 0 {
+		share += b.total * time.Duration(weight) / time.Duration(b.totalWeight)
+		delete(b.pending, phase.Name)
+	}
+	b.carry = 0
+	b.mu.Unlock()
+
+	started := now()
+	phaseCtx, cancel := context.WithTimeout(ctx, share)
+
+	var once sync.Once
+	return phaseCtx, func() {
+		once.Do(func() {
+			cancel()
+			if unused := share - now().Sub(started); unused > 0 {
+				b.mu.Lock()
+				b.carry += unused
+				b.mu.Unlock()
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertShare checks the deadline of ctx, which is always taken from the real clock
+func assertShare(t *testing.T, ctx context.Context, expected time.Duration) {
+	t.Helper()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok, "expected the phase context to have a deadline")
+	assert.InDelta(t, float64(expected), float64(time.Until(deadline)), float64(time.Second))
+}
+
+func TestBudget_SplitsTimeoutByWeight(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	original := now
+	now = func() time.Time { return clock }
+	defer func() { now = original }()
+
+	budget := NewBudget(60*time.Second, PhaseInventory, PhaseDatabaseMetrics, PhaseInstanceMetrics, PhaseQueryMonitoring)
+
+	ctx, cancel := budget.Start(context.Background(), PhaseInventory)
+	assertShare(t, ctx, 6*time.Second)
+	// inventory finishes after 1s, the remaining 5s carry over to the next phase
+	clock = clock.Add(time.Second)
+	cancel()
+
+	ctx, cancel = budget.Start(context.Background(), PhaseDatabaseMetrics)
+	assertShare(t, ctx, 23*time.Second)
+	// database metrics use their whole share so nothing carries over
+	clock = clock.Add(23 * time.Second)
+	cancel()
+	cancel()
+
+	ctx, cancel = budget.Start(context.Background(), PhaseInstanceMetrics)
+	assertShare(t, ctx, 12*time.Second)
+	cancel()
+
+	ctx, cancel = budget.Start(context.Background(), PhaseQueryMonitoring)
+	assertShare(t, ctx, 36*time.Second)
+	cancel()
+}
+
+func TestBudget_NoTimeout(t *testing.T) {
+	for _, budget := range []*Budget{nil, NewBudget(0, PhaseInventory)} {
+		ctx, cancel := budget.Start(context.Background(), PhaseInventory)
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+		assert.NoError(t, ctx.Err())
+		cancel()
+		assert.Error(t, ctx.Err())
+	}
+}
+
+func TestBudget_ExhaustedPhaseIsCancelled(t *testing.T) {
+	budget := NewBudget(time.Millisecond, PhaseInstanceMetrics)
+
+	ctx, cancel := budget.Start(context.Background(), PhaseInstanceMetrics)
+	defer cancel()
+
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,483 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+var (
+	// ErrInvalidConnectionParameter is returned when a connection parameter has an unusable name or value
+	ErrInvalidConnectionParameter = errors.New("invalid connection parameter")
+	// ErrConflictingConnectionParameters is returned when parameters that cannot be combined are set together
+	ErrConflictingConnectionParameters = errors.New("conflicting connection parameters")
+)
+
+// Connection string parameter names as understood by go-mssqldb. The driver matches them case-insensitively.
+const (
+	paramServer                 = "server"
+	paramPort                   = "port"
+	paramUserID                 = "user id"
+	paramPassword               = "password"
+	paramDatabase               = "database"
+	paramDialTimeout            = "dial timeout"
+	paramConnectionTimeout      = "connection timeout"
+	paramEncrypt                = "encrypt"
+	paramTrustServerCertificate = "TrustServerCertificate"
+	paramCertificate            = "certificate"
+	paramHostNameInCertificate  = "hostnameincertificate"
+	paramTLSMin                 = "tlsmin"
+	paramServerSPN              = "ServerSPN"
+	paramAppName                = "app name"
+	paramWorkstationID          = "workstation id"
+	paramApplicationIntent      = "applicationintent"
+	paramFailoverPartner        = "failoverpartner"
+	paramFailoverPort           = "failoverport"
+	paramPacketSize             = "packet size"
+	paramKeepAlive              = "keepalive"
+	paramLog                    = "log"
+	paramProtocol               = "protocol"
+	paramMultiSubnetFailover    = "multisubnetfailover"
+	paramDisableRetry           = "disableretry"
+	paramFedAuth                = "fedauth"
+	paramTokenFilePath          = "tokenfilepath"
+	paramAuthenticator          = "authenticator"
+	paramKrb5Realm              = "krb5-realm"
+	paramKrb5ConfigFile         = "krb5-configfile"
+	paramKrb5KeytabFile         = "krb5-keytabfile"
+)
+
+// reservedParams can only be set through the host, port, instance and credential options
+// because the URL form carries them outside of the query string
+var reservedParams = map[string]bool{
+	paramServer:   true,
+	paramPort:     true,
+	paramUserID:   true,
+	paramPassword: true,
+}
+
+// encryptModes are the values accepted by the encrypt parameter
+var encryptModes = map[string]bool{
+	"true":      true,
+	"false":     true,
+	"disable":   true,
+	"mandatory": true,
+	"optional":  true,
+	"strict":    true,
+}
+
+// ConnectionString is a go-mssqldb connection string assembled from typed options. Values are
+// escaped when it is rendered, so names and credentials may contain any character.
+type ConnectionString struct {
+	host     string
+	port     string
+	instance string
+	user     string
+	password string
+	// params holds the parameters in the order they were set, keyed by their lower-cased name
+	params map[string]connectionParam
+	keys   []string
+}
+
+type connectionParam struct {
+	name  string
+	value string
+}
+
+// ConnectionOption sets one or more parameters of a ConnectionString
+type ConnectionOption func(*ConnectionString) error
+
+// NewConnectionString builds the connection string for host from options, checking that
+// no parameter is set twice with different values and that the parameters can be combined
+func NewConnectionString(host string, options ...ConnectionOption) (*ConnectionString, error) {
+	// a comma would make the driver read what follows as the port
+	if host == "" || strings.ContainsAny(host, " \t;,/\\?#@") {
+		return nil, fmt.Errorf("%w: %q is not a valid server host", ErrInvalidConnectionParameter, host)
+	}
+
+	cs := &ConnectionString{host: host, params: map[string]connectionParam{}}
+	for _, option := range options {
+		if err := option(cs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cs.validate(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// set adds a parameter, failing if it was already set to a different value
+func (cs *ConnectionString) set(name, value string) error {
+	name = strings.TrimSpace(name)
+	key := strings.ToLower(name)
+	if key == "" || strings.ContainsAny(key, "=;") {
+		return fmt.Errorf("%w: %q is not a valid parameter name", ErrInvalidConnectionParameter, name)
+	}
+
+	if existing, ok := cs.params[key]; ok {
+		if existing.value != value {
+			return fmt.Errorf("%w: %q is set to both %q and %q", ErrConflictingConnectionParameters, key, existing.value, value)
+		}
+		return nil
+	}
+
+	cs.params[key] = connectionParam{name: name, value: value}
+	cs.keys = append(cs.keys, key)
+	return nil
+}
+
+// override sets a parameter given in the extra connection arguments. Earlier versions appended
+// them to the URL, where they took precedence, so a value replacing one set by the integration is
+// still accepted with a deprecation warning.
+func (cs *ConnectionString) override(name, value string) error {
+	key := strings.ToLower(strings.TrimSpace(name))
+	existing, ok := cs.params[key]
+	if !ok || existing.value == value {
+		return WithParam(name, value)(cs)
+	}
+
+	log.Warn("Extra connection argument %q overrides the value %q set by the integration with %q. This is deprecated, use the matching integration argument instead", name, existing.value, value)
+	cs.params[key] = connectionParam{name: existing.name, value: value}
+	return nil
+}
+
+func (cs *ConnectionString) get(name string) (string, bool) {
+	p, ok := cs.params[strings.ToLower(name)]
+	return p.value, ok
+}
+
+// validate rejects combinations of parameters the driver would either refuse or silently ignore
+func (cs *ConnectionString) validate() error {
+	if cs.port != "" && cs.instance != "" {
+		return fmt.Errorf("%w: port and instance cannot both be set", ErrConflictingConnectionParameters)
+	}
+
+	_, hasFedAuth := cs.get(paramFedAuth)
+	_, hasAuthenticator := cs.get(paramAuthenticator)
+	if hasFedAuth && hasAuthenticator {
+		return fmt.Errorf("%w: fedauth and authenticator cannot both be set", ErrConflictingConnectionParameters)
+	}
+
+	encrypt, _ := cs.get(paramEncrypt)
+	trust, _ := cs.get(paramTrustServerCertificate)
+	_, hasCertificate := cs.get(paramCertificate)
+	_, hasHostNameInCertificate := cs.get(paramHostNameInCertificate)
+
+	if (hasCertificate || hasHostNameInCertificate) && (encrypt == "disable" || trust == "true") {
+		return fmt.Errorf("%w: certificate validation options require encryption without TrustServerCertificate", ErrConflictingConnectionParameters)
+	}
+	if encrypt == "strict" && trust == "true" {
+		return fmt.Errorf("%w: encrypt=strict always validates the server certificate, TrustServerCertificate cannot be true", ErrConflictingConnectionParameters)
+	}
+
+	return nil
+}
+
+// URL renders the connection string as a sqlserver:// URL
+func (cs *ConnectionString) URL() string {
+	connectionURL := &url.URL{
+		Scheme: "sqlserver",
+		Host:   cs.host,
+		Path:   cs.instance,
+	}
+	if cs.port != "" {
+		connectionURL.Host = cs.host + ":" + cs.port
+	}
+	if cs.user != "" || cs.password != "" {
+		if cs.password != "" {
+			connectionURL.User = url.UserPassword(cs.user, cs.password)
+		} else {
+			connectionURL.User = url.User(cs.user)
+		}
+	}
+
+	query := url.Values{}
+	for _, key := range cs.keys {
+		p := cs.params[key]
+		query.Add(p.name, p.value)
+	}
+	connectionURL.RawQuery = query.Encode()
+
+	return connectionURL.String()
+}
+
+// ADO renders the connection string in the key=value;... form used by the azuresql driver
+func (cs *ConnectionString) ADO() string {
+	server := cs.host
+	if cs.instance != "" {
+		server += `\` + cs.instance
+	}
+
+	parts := []string{adoPair(paramServer, server)}
+	if cs.port != "" {
+		parts = append(parts, adoPair(paramPort, cs.port))
+	}
+	if cs.user != "" {
+		parts = append(parts, adoPair(paramUserID, cs.user))
+	}
+	if cs.password != "" {
+		parts = append(parts, adoPair(paramPassword, cs.password))
+	}
+	for _, key := range cs.keys {
+		p := cs.params[key]
+		parts = append(parts, adoPair(p.name, p.value))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// adoPair quotes values the ADO parser would otherwise split or trim. Inside quotes a
+// double quote is escaped by doubling it.
+func adoPair(name, value string) string {
+	if strings.ContainsAny(value, `;"`) || strings.TrimSpace(value) != value {
+		value = `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+	}
+	return name + "=" + value
+}
+
+// WithPort connects to a TCP port instead of resolving a named instance
+func WithPort(port string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if port == "" {
+			return nil
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return fmt.Errorf("%w: port %q is not a valid TCP port", ErrInvalidConnectionParameter, port)
+		}
+		cs.port = port
+		return nil
+	}
+}
+
+// WithInstance connects to a named instance resolved through the SQL Browser service
+func WithInstance(instance string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		cs.instance = instance
+		return nil
+	}
+}
+
+// WithCredentials sets the login and password. Either may be empty.
+func WithCredentials(user, password string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		cs.user = user
+		cs.password = password
+		return nil
+	}
+}
+
+// WithDatabase sets the initial database of the session. An empty name keeps the login default.
+func WithDatabase(database string) ConnectionOption {
+	return optionalParam(paramDatabase, database)
+}
+
+// WithTimeout sets both the dial and the login timeout in seconds
+func WithTimeout(seconds string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if seconds == "" {
+			return nil
+		}
+		if _, err := strconv.ParseUint(seconds, 10, 32); err != nil {
+			return fmt.Errorf("%w: timeout %q is not a number of seconds", ErrInvalidConnectionParameter, seconds)
+		}
+		if err := cs.set(paramDialTimeout, seconds); err != nil {
+			return err
+		}
+		return cs.set(paramConnectionTimeout, seconds)
+	}
+}
+
+// WithEncrypt sets the encryption mode: true, false, disable, mandatory, optional or strict
+func WithEncrypt(mode string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if !encryptModes[strings.ToLower(mode)] {
+			return fmt.Errorf("%w: unknown encrypt mode %q", ErrInvalidConnectionParameter, mode)
+		}
+		return cs.set(paramEncrypt, strings.ToLower(mode))
+	}
+}
+
+// WithTrustServerCertificate skips the validation of the server certificate when true
+func WithTrustServerCertificate(trust bool) ConnectionOption {
+	return boolParam(paramTrustServerCertificate, trust)
+}
+
+// WithCertificate validates the server certificate against the CA in path
+func WithCertificate(path string) ConnectionOption {
+	return optionalParam(paramCertificate, path)
+}
+
+// WithHostNameInCertificate sets the name expected in the server certificate when it differs from the host
+func WithHostNameInCertificate(hostname string) ConnectionOption {
+	return optionalParam(paramHostNameInCertificate, hostname)
+}
+
+// WithTLSMin sets the lowest TLS version accepted, e.g. 1.2
+func WithTLSMin(version string) ConnectionOption {
+	return optionalParam(paramTLSMin, version)
+}
+
+// WithServerSPN overrides the service principal name used by integrated authentication
+func WithServerSPN(spn string) ConnectionOption {
+	return optionalParam(paramServerSPN, spn)
+}
+
+// WithAppName sets the program name reported to the server
+func WithAppName(name string) ConnectionOption {
+	return optionalParam(paramAppName, name)
+}
+
+// WithWorkstationID sets the host name reported to the server
+func WithWorkstationID(id string) ConnectionOption {
+	return optionalParam(paramWorkstationID, id)
+}
+
+// WithApplicationIntent declares the workload type, ReadOnly routes to readable secondaries
+func WithApplicationIntent(intent string) ConnectionOption {
+	return optionalParam(paramApplicationIntent, intent)
+}
+
+// WithFailoverPartner sets the mirroring partner used when the principal cannot be reached
+func WithFailoverPartner(host string, port uint16) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if err := cs.set(paramFailoverPartner, host); err != nil {
+			return err
+		}
+		if port == 0 {
+			return nil
+		}
+		return cs.set(paramFailoverPort, strconv.FormatUint(uint64(port), 10))
+	}
+}
+
+// WithPacketSize sets the TDS packet size in bytes
+func WithPacketSize(size uint16) ConnectionOption {
+	return uintParam(paramPacketSize, uint64(size))
+}
+
+// WithKeepAlive sets the TCP keep-alive interval in seconds, 0 disables it
+func WithKeepAlive(seconds uint32) ConnectionOption {
+	return uintParam(paramKeepAlive, uint64(seconds))
+}
+
+// WithLog sets the driver logging flags
+func WithLog(flags uint64) ConnectionOption {
+	return uintParam(paramLog, flags)
+}
+
+// WithProtocol forces the network protocol, e.g. tcp or np
+func WithProtocol(protocol string) ConnectionOption {
+	return optionalParam(paramProtocol, protocol)
+}
+
+// WithMultiSubnetFailover dials every address of the listener in parallel
+func WithMultiSubnetFailover(enabled bool) ConnectionOption {
+	return boolParam(paramMultiSubnetFailover, enabled)
+}
+
+// WithDisableRetry stops the driver from retrying queries on connection errors
+func WithDisableRetry(disabled bool) ConnectionOption {
+	return boolParam(paramDisableRetry, disabled)
+}
+
+// WithFedAuth selects the Azure AD workflow used by the azuresql driver
+func WithFedAuth(method string) ConnectionOption {
+	return optionalParam(paramFedAuth, method)
+}
+
+// WithTokenFilePath sets the federated token file used by workload identity
+func WithTokenFilePath(path string) ConnectionOption {
+	return optionalParam(paramTokenFilePath, path)
+}
+
+// WithAuthenticator selects the integrated authentication provider, e.g. krb5 or ntlm
+func WithAuthenticator(name string) ConnectionOption {
+	return optionalParam(paramAuthenticator, name)
+}
+
+// WithKerberos sets the realm and, when not empty, the krb5.conf and keytab used to obtain a ticket
+func WithKerberos(realm, configFile, keytabFile string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		for _, p := range []connectionParam{
+			{paramKrb5Realm, realm},
+			{paramKrb5ConfigFile, configFile},
+			{paramKrb5KeytabFile, keytabFile},
+		} {
+			if err := optionalParam(p.name, p.value)(cs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithParam sets a driver parameter that has no typed option
+func WithParam(name, value string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if reservedParams[strings.ToLower(strings.TrimSpace(name))] {
+			return fmt.Errorf("%w: %q cannot be set as an extra parameter", ErrInvalidConnectionParameter, name)
+		}
+		return cs.set(name, value)
+	}
+}
+
+// WithExtraArgs adds the parameters of a URL query string such as applicationintent=readonly&foo=bar.
+// They override parameters set by earlier options. A query string that cannot be parsed is ignored
+// with a warning, as earlier versions did.
+func WithExtraArgs(query string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if query == "" {
+			return nil
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			log.Warn("Could not successfully parse ExtraConnectionURLArgs: %s", err.Error())
+			return nil
+		}
+
+		// url.Values is a map, sort the names so the rendered string is stable
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if len(values[name]) > 1 {
+				return fmt.Errorf("%w: extra connection argument %q is given more than once", ErrConflictingConnectionParameters, name)
+			}
+			if err := cs.override(name, values[name][0]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func optionalParam(name, value string) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		if value == "" {
+			return nil
+		}
+		return cs.set(name, value)
+	}
+}
+
+func boolParam(name string, value bool) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		return cs.set(name, strconv.FormatBool(value))
+	}
+}
+
+func uintParam(name string, value uint64) ConnectionOption {
+	return func(cs *ConnectionString) error {
+		return cs.set(name, strconv.FormatUint(value, 10))
+	}
+}
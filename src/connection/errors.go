@@ -0,0 +1,175 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+// Connection error kinds. A *ConnectionError matches its kind with errors.Is so callers
+// can branch on the failure without inspecting driver specific errors.
+var (
+	ErrAuthFailure         = errors.New("authentication failure")
+	ErrNetworkUnreachable  = errors.New("network unreachable")
+	ErrTLSFailure          = errors.New("TLS failure")
+	ErrLoginTimeout        = errors.New("login timeout")
+	ErrDatabaseUnavailable = errors.New("database unavailable")
+	ErrUnclassifiedFailure = errors.New("connection failure")
+)
+
+// authFailureErrorNumbers are SQL Server login errors that will not go away by retrying
+var authFailureErrorNumbers = map[int32]bool{
+	18452: true, // login from an untrusted domain
+	18456: true, // login failed
+	18470: true, // account disabled
+	18486: true, // account locked out
+	18487: true, // password expired
+	18488: true, // password must be changed
+	33155: true, // Azure AD login failed
+}
+
+// transientErrorNumbers are the Azure SQL and SQL Server errors documented as transient,
+// meaning the same login is expected to succeed shortly after
+var transientErrorNumbers = map[int32]bool{
+	926:   true, // database marked suspect / being recovered
+	4060:  true, // cannot open database requested by the login
+	4221:  true, // login to read-secondary failed due to long wait on HADR_DATABASE_WAIT_FOR_TRANSITION_TO_VERSIONING
+	10928: true, // resource limit reached
+	10929: true, // resource minimum guarantee not available
+	40143: true, // the service has encountered an error processing your request
+	40197: true, // the service has encountered an error processing your request
+	40501: true, // the service is currently busy
+	40540: true, // the service has encountered an error processing your request
+	40613: true, // database is not currently available
+	42108: true, // can not connect to the SQL pool since it is paused
+	42109: true, // the SQL pool is warming up
+	49918: true, // not enough resources to process the request
+	49919: true, // too many create or update operations in progress
+	49920: true, // too many operations in progress
+}
+
+// ConnectionError is returned when a connection can't be established
+type ConnectionError struct {
+	Kind      error
+	Retryable bool
+	Err       error
+}
+
+func (e *ConnectionError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap exposes both the kind and the underlying error to errors.Is and errors.As
+func (e *ConnectionError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// IsRetryable reports whether err is a connection error that is worth retrying
+func IsRetryable(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr) && connErr.Retryable
+}
+
+// ClassifyError wraps err in a *ConnectionError describing the kind of failure.
+// Errors that are already classified are returned unchanged.
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) {
+		return err
+	}
+
+	kind, retryable := classify(err)
+	return &ConnectionError{Kind: kind, Retryable: retryable, Err: err}
+}
+
+// classify is ordered from the most to the least specific check since TLS and
+// login errors usually wrap network errors.
+func classify(err error) (kind error, retryable bool) {
+	if isTLSError(err) {
+		return ErrTLSFailure, false
+	}
+
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		switch {
+		case authFailureErrorNumbers[sqlErr.Number]:
+			return ErrAuthFailure, false
+		case transientErrorNumbers[sqlErr.Number]:
+			return ErrDatabaseUnavailable, true
+		}
+		return ErrUnclassifiedFailure, false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrLoginTimeout, true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrLoginTimeout, true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrNetworkUnreachable, !dnsErr.IsNotFound
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return ErrNetworkUnreachable, true
+	}
+
+	// Azure AD token acquisition errors are not typed by the driver
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "login error") || strings.Contains(message, "aadsts") {
+		return ErrAuthFailure, false
+	}
+	if strings.Contains(message, "unable to open tcp connection") {
+		return ErrNetworkUnreachable, true
+	}
+
+	return ErrUnclassifiedFailure, false
+}
+
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalidCert      x509.CertificateInvalidError
+		verificationErr  *tls.CertificateVerificationError
+		recordHeaderErr  tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &invalidCert) ||
+		errors.As(err, &verificationErr) || errors.As(err, &recordHeaderErr) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "TLS Handshake failed")
+}
+
+// ErrQueryCancelled is returned by the context-aware query methods when the context ended
+// before the query completed
+var ErrQueryCancelled = errors.New("query cancelled")
+
+// cancelledQueryError marks err as a cancellation when ctx ended while the query was running
+// and logs the query that was cut short
+func cancelledQueryError(ctx context.Context, query string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	log.Warn("Query cancelled after its time budget was exhausted: %s", query)
+	return fmt.Errorf("%w: %w", ErrQueryCancelled, err)
+}
@@ -0,0 +1,60 @@
+package connection
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ClassifyError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		err           error
+		wantKind      error
+		wantRetryable bool
+	}{
+		{"Login failed", mssql.Error{Number: 18456, Message: "login error: Login failed for user 'sa'."}, ErrAuthFailure, false},
+		{"Wrapped login failed", fmt.Errorf("connect: %w", mssql.Error{Number: 18456}), ErrAuthFailure, false},
+		{"Azure database not available", mssql.Error{Number: 40613}, ErrDatabaseUnavailable, true},
+		{"Azure service busy", mssql.Error{Number: 40501}, ErrDatabaseUnavailable, true},
+		{"Other server error", mssql.Error{Number: 50000}, ErrUnclassifiedFailure, false},
+		{"Connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ErrNetworkUnreachable, true},
+		{"Unknown host", &net.DNSError{Err: "no such host", Name: "nohost", IsNotFound: true}, ErrNetworkUnreachable, false},
+		{"Dial timeout", &net.OpError{Op: "dial", Err: &timeoutError{}}, ErrLoginTimeout, true},
+		{"Context deadline", fmt.Errorf("login: %w", context.DeadlineExceeded), ErrLoginTimeout, true},
+		{"Unknown certificate authority", fmt.Errorf("TLS Handshake failed: %w", x509.UnknownAuthorityError{}), ErrTLSFailure, false},
+		{"TLS handshake message only", errors.New("TLS Handshake failed: remote error: tls: protocol version not supported"), ErrTLSFailure, false},
+		{"Azure AD token failure", errors.New("AADSTS7000215: Invalid client secret provided"), ErrAuthFailure, false},
+		{"Unclassified", errors.New("something else"), ErrUnclassifiedFailure, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ClassifyError(tc.err)
+			assert.ErrorIs(t, err, tc.wantKind)
+			var connErr *ConnectionError
+			require.ErrorAs(t, err, &connErr)
+			assert.Equal(t, tc.err, connErr.Err)
+			assert.Equal(t, tc.wantRetryable, IsRetryable(err))
+		})
+	}
+
+	assert.NoError(t, ClassifyError(nil))
+
+	classified := ClassifyError(mssql.Error{Number: 18456})
+	assert.Same(t, classified, ClassifyError(classified), "already classified errors are returned unchanged")
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,65 @@
+package connection
+
+import (
+	"github.com/jmoiron/sqlx"
+	// importing the krb5 package registers it as an integrated authentication provider
+	_ "github.com/microsoft/go-mssqldb/integratedauth/krb5"
+	"github.com/newrelic/nri-mssql/src/args"
+)
+
+// Integrated authentication provider names as understood by go-mssqldb
+const (
+	krb5Authenticator = "krb5"
+	ntlmAuthenticator = "ntlm"
+)
+
+// KerberosAuthConnector logs in with a Kerberos ticket obtained from a keytab or from the
+// username and password, allowing domain-only SQL Servers to be monitored from Linux hosts.
+type KerberosAuthConnector struct{}
+
+func (k KerberosAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := CreateKerberosConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connect(connectionURL)
+}
+
+// NTLMAuthConnector logs in with Windows credentials given as DOMAIN\user and password.
+type NTLMAuthConnector struct{}
+
+func (n NTLMAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := CreateNTLMConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connect(connectionURL)
+}
+
+// CreateKerberosConnectionURL creates the connection string for Kerberos authentication.
+// No KDC is contacted while building it.
+func CreateKerberosConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	cs, err := newConnectionString(args, dbName,
+		WithCredentials(args.Username, args.Password),
+		WithAuthenticator(krb5Authenticator),
+		WithKerberos(args.KerberosRealm, args.KerberosConfigFile, args.KerberosKeytabFile),
+		WithServerSPN(args.ServerSPN))
+	if err != nil {
+		return "", err
+	}
+	return cs.URL(), nil
+}
+
+// CreateNTLMConnectionURL creates the connection string for NTLM authentication. The
+// authenticator is set explicitly so a malformed login fails instead of silently
+// falling back to SQL authentication.
+func CreateNTLMConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	cs, err := newConnectionString(args, dbName,
+		WithCredentials(args.Username, args.Password),
+		WithAuthenticator(ntlmAuthenticator),
+		WithServerSPN(args.ServerSPN))
+	if err != nil {
+		return "", err
+	}
+	return cs.URL(), nil
+}
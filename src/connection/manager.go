@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+)
+
+// Manager hands out pooled connections keyed by database name so that metrics, inventory
+// and query analysis share logins instead of each opening their own. The empty database
+// name identifies the instance connection.
+type Manager struct {
+	args        *args.ArgumentList
+	mu          sync.Mutex
+	connections map[string]*managedConnection
+	stats       QueryStats
+}
+
+// managedConnection guards the creation of a single pooled connection so that concurrent
+// callers asking for the same database wait for one login instead of racing
+type managedConnection struct {
+	mu  sync.Mutex
+	con *SQLConnection
+}
+
+// NewManager creates a connection manager for the target described by args
+func NewManager(args *args.ArgumentList) *Manager {
+	return &Manager{
+		args:        args,
+		connections: make(map[string]*managedConnection),
+	}
+}
+
+// Instance returns the pooled connection to the instance, without a database in the login
+func (m *Manager) Instance() (*SQLConnection, error) {
+	return m.Get("")
+}
+
+// Get returns the pooled connection for dbName, opening it on first use. Failed attempts
+// are not cached so a later caller tries again.
+func (m *Manager) Get(dbName string) (*SQLConnection, error) {
+	m.mu.Lock()
+	entry, ok := m.connections[dbName]
+	if !ok {
+		entry = &managedConnection{}
+		m.connections[dbName] = entry
+	}
+	m.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.con != nil {
+		return entry.con, nil
+	}
+
+	con, err := CreateDatabaseConnection(m.args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	m.configurePool(con)
+	con.stats = &m.stats
+	entry.con = con
+
+	return con, nil
+}
+
+// Stats returns the statistics of the queries run through the connections of the manager
+func (m *Manager) Stats() *QueryStats {
+	return &m.stats
+}
+
+// configurePool applies the pool limits configured in args to con. A non-positive idle
+// limit keeps the database/sql default
+func (m *Manager) configurePool(con *SQLConnection) {
+	if con == nil || con.Connection == nil {
+		return
+	}
+	con.Connection.SetMaxOpenConns(m.args.ConnectionPoolMaxOpen)
+	if m.args.ConnectionPoolMaxIdle > 0 {
+		con.Connection.SetMaxIdleConns(m.args.ConnectionPoolMaxIdle)
+	}
+	con.Connection.SetConnMaxLifetime(time.Duration(m.args.ConnectionPoolMaxLifetime) * time.Second)
+}
+
+// Close closes every connection handed out by the manager
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dbName, entry := range m.connections {
+		entry.mu.Lock()
+		if entry.con != nil && entry.con.Connection != nil {
+			log.Debug("Closing pooled connection for database '%s'", dbName)
+			entry.con.Close()
+		}
+		entry.mu.Unlock()
+		delete(m.connections, dbName)
+	}
+}
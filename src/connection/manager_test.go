@@ -0,0 +1,95 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func overrideCreateDatabaseConnection(t *testing.T, create func(*args.ArgumentList, string) (*SQLConnection, error)) {
+	original := CreateDatabaseConnection
+	t.Cleanup(func() { CreateDatabaseConnection = original })
+	CreateDatabaseConnection = create
+}
+
+func TestManager_GetReusesConnectionPerDatabase(t *testing.T) {
+	created := map[string]int{}
+	mocks := []sqlmock.Sqlmock{}
+	overrideCreateDatabaseConnection(t, func(_ *args.ArgumentList, dbName string) (*SQLConnection, error) {
+		created[dbName]++
+		con, mock := CreateMockSQL(t)
+		mock.ExpectClose()
+		mocks = append(mocks, mock)
+		return con, nil
+	})
+
+	manager := NewManager(&args.ArgumentList{ConnectionPoolMaxOpen: 4, ConnectionPoolMaxIdle: 1})
+
+	instance, err := manager.Instance()
+	require.NoError(t, err)
+	first, err := manager.Get("db-1")
+	require.NoError(t, err)
+	second, err := manager.Get("db-1")
+	require.NoError(t, err)
+	again, err := manager.Get("")
+	require.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Same(t, instance, again)
+	assert.NotSame(t, instance, first)
+	assert.Equal(t, map[string]int{"": 1, "db-1": 1}, created)
+	assert.Equal(t, 4, first.Connection.Stats().MaxOpenConnections)
+
+	manager.Close()
+	for _, mock := range mocks {
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestManager_GetDoesNotCacheFailures(t *testing.T) {
+	attempts := 0
+	overrideCreateDatabaseConnection(t, func(_ *args.ArgumentList, _ string) (*SQLConnection, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("login timeout")
+		}
+		con, _ := CreateMockSQL(t)
+		return con, nil
+	})
+
+	manager := NewManager(&args.ArgumentList{})
+	defer manager.Close()
+
+	_, err := manager.Get("db-1")
+	assert.Error(t, err)
+
+	con, err := manager.Get("db-1")
+	assert.NoError(t, err)
+	assert.NotNil(t, con)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestManager_StatsCountQueriesOfEveryConnection(t *testing.T) {
+	overrideCreateDatabaseConnection(t, func(_ *args.ArgumentList, _ string) (*SQLConnection, error) {
+		con, mock := CreateMockSQL(t)
+		mock.ExpectQuery(`SELECT 1\s+/\* nri-mssql \*/`).WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+		return con, nil
+	})
+
+	manager := NewManager(&args.ArgumentList{})
+	defer manager.Close()
+
+	for _, dbName := range []string{"", "db-1"} {
+		con, err := manager.Get(dbName)
+		require.NoError(t, err)
+		var result []int
+		require.NoError(t, con.Query(&result, "SELECT 1"))
+	}
+
+	assert.Equal(t, int64(2), manager.Stats().Queries())
+	assert.Positive(t, manager.Stats().Elapsed())
+}
@@ -0,0 +1,32 @@
+package connection
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// QueryStats counts the queries run through the connections of a Manager and the time
+// spent waiting for them. It is safe for concurrent use and a nil QueryStats ignores records.
+type QueryStats struct {
+	queries atomic.Int64
+	elapsed atomic.Int64
+}
+
+// record adds a query started at start
+func (s *QueryStats) record(start time.Time) {
+	if s == nil {
+		return
+	}
+	s.queries.Add(1)
+	s.elapsed.Add(int64(time.Since(start)))
+}
+
+// Queries returns the number of queries run
+func (s *QueryStats) Queries() int64 {
+	return s.queries.Load()
+}
+
+// Elapsed returns the time spent waiting for the queries to return their results
+func (s *QueryStats) Elapsed() time.Duration {
+	return time.Duration(s.elapsed.Load())
+}
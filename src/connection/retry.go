@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+)
+
+// RetryPolicy controls how often a failed connection attempt is retried
+type RetryPolicy struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Deadline bounds the total time spent on all attempts, zero means no deadline
+	Deadline time.Duration
+}
+
+// package-level variables so the clock can be replaced in unit tests
+var (
+	sleep = time.Sleep
+	now   = time.Now
+)
+
+// NewRetryPolicy builds the retry policy configured in args. Retrying never outlasts the
+// collection timeout of the instance.
+func NewRetryPolicy(args *args.ArgumentList) RetryPolicy {
+	deadline := args.ConnectionRetryDeadline
+	if args.CollectionTimeout > 0 && (deadline <= 0 || deadline > args.CollectionTimeout) {
+		deadline = args.CollectionTimeout
+	}
+
+	return RetryPolicy{
+		Attempts:       args.GetConnectionRetryAttempts(),
+		InitialBackoff: time.Duration(args.ConnectionRetryBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(args.ConnectionRetryMaxBackoffMs) * time.Millisecond,
+		Deadline:       time.Duration(deadline) * time.Second,
+	}
+}
+
+// backoff returns the exponential delay before the given retry (starting at 1) with
+// jitter applied so many integrations don't reconnect in lockstep
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+
+	delay := p.InitialBackoff << (retry - 1)
+	if delay <= 0 || (p.MaxBackoff > 0 && delay > p.MaxBackoff) {
+		delay = p.MaxBackoff
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1)) //nolint:gosec // jitter does not need a secure source
+}
+
+// connectWithRetry calls connect until it succeeds, fails with a non retryable error,
+// runs out of attempts or would exceed the policy deadline. The returned error is classified.
+func connectWithRetry(policy RetryPolicy, connect func() (*sqlx.DB, error)) (*sqlx.DB, error) {
+	start := now()
+	attempts := max(policy.Attempts, 1)
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var db *sqlx.DB
+		db, err = connect()
+		if err == nil {
+			return db, nil
+		}
+
+		err = ClassifyError(err)
+		if !IsRetryable(err) || attempt == attempts {
+			return nil, err
+		}
+
+		delay := policy.backoff(attempt)
+		if policy.Deadline > 0 && now().Sub(start)+delay > policy.Deadline {
+			log.Debug("Connection retry deadline of %s reached after %d attempts", policy.Deadline, attempt)
+			return nil, err
+		}
+
+		log.Warn("Connection attempt %d of %d failed, retrying in %s: %s", attempt, attempts, delay, err.Error())
+		sleep(delay)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock replaces sleep and now so retries don't slow down the tests
+func fakeClock(t *testing.T) *[]time.Duration {
+	t.Helper()
+	originalSleep, originalNow := sleep, now
+	t.Cleanup(func() { sleep, now = originalSleep, originalNow })
+
+	current := time.Now()
+	sleeps := make([]time.Duration, 0)
+	now = func() time.Time { return current }
+	sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		current = current.Add(d)
+	}
+	return &sleeps
+}
+
+func Test_connectWithRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	testCases := []struct {
+		name         string
+		policy       RetryPolicy
+		errs         []error
+		wantCalls    int
+		wantKind     error
+		wantSuccess  bool
+		wantNumSleep int
+	}{
+		{"Transient error then success", policy, []error{mssql.Error{Number: 40613}, mssql.Error{Number: 40501}}, 3, nil, true, 2},
+		{"Login failure fails fast", policy, []error{mssql.Error{Number: 18456}}, 1, ErrAuthFailure, false, 0},
+		{"Attempts exhausted", policy, []error{mssql.Error{Number: 40613}, mssql.Error{Number: 40613}, mssql.Error{Number: 40613}, mssql.Error{Number: 40613}}, 4, ErrDatabaseUnavailable, false, 3},
+		{
+			"Deadline reached",
+			// jitter keeps each delay within [500ms, 1s], so the second delay always crosses the deadline
+			RetryPolicy{Attempts: 10, InitialBackoff: time.Second, MaxBackoff: time.Second, Deadline: time.Second},
+			[]error{mssql.Error{Number: 40613}, mssql.Error{Number: 40613}, mssql.Error{Number: 40613}, mssql.Error{Number: 40613}},
+			2, ErrDatabaseUnavailable, false, 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sleeps := fakeClock(t)
+
+			calls := 0
+			db, err := connectWithRetry(tc.policy, func() (*sqlx.DB, error) {
+				calls++
+				if calls <= len(tc.errs) {
+					return nil, tc.errs[calls-1]
+				}
+				return &sqlx.DB{}, nil
+			})
+
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Len(t, *sleeps, tc.wantNumSleep)
+			if tc.wantSuccess {
+				require.NoError(t, err)
+				assert.NotNil(t, db)
+				return
+			}
+			assert.ErrorIs(t, err, tc.wantKind)
+		})
+	}
+}
+
+func Test_RetryPolicy_backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 200 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, maxDelay := range map[int]time.Duration{1: 200 * time.Millisecond, 2: 400 * time.Millisecond, 3: 800 * time.Millisecond, 4: time.Second, 10: time.Second} {
+		delay := policy.backoff(retry)
+		assert.GreaterOrEqual(t, delay, maxDelay/2, "retry %d", retry)
+		assert.LessOrEqual(t, delay, maxDelay, "retry %d", retry)
+	}
+
+	assert.Zero(t, RetryPolicy{}.backoff(1))
+}
+
+func Test_NewRetryPolicy(t *testing.T) {
+	policy := NewRetryPolicy(&args.ArgumentList{
+		ConnectionRetryBackoffMs:    250,
+		ConnectionRetryMaxBackoffMs: 5000,
+		ConnectionRetryDeadline:     30,
+	})
+
+	assert.Equal(t, RetryPolicy{
+		Attempts:       args.DefaultConnectionRetryAttempts,
+		InitialBackoff: 250 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Deadline:       30 * time.Second,
+	}, policy)
+}
+
+func Test_NewRetryPolicy_CappedByCollectionTimeout(t *testing.T) {
+	testCases := []struct {
+		name              string
+		retryDeadline     int
+		collectionTimeout int
+		expected          time.Duration
+	}{
+		{"no collection timeout", 60, 0, 60 * time.Second},
+		{"retry deadline shorter", 10, 20, 10 * time.Second},
+		{"collection timeout shorter", 60, 20, 20 * time.Second},
+		{"no retry deadline", 0, 20, 20 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := NewRetryPolicy(&args.ArgumentList{
+				ConnectionRetryDeadline: tc.retryDeadline,
+				CollectionTimeout:       tc.collectionTimeout,
+			})
+			assert.Equal(t, tc.expected, policy.Deadline)
+		})
+	}
+}
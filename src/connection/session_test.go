@@ -0,0 +1,62 @@
+package connection
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_SessionMarkerHex(t *testing.T) {
+	assert.Equal(t, "0x"+strings.ToUpper(hex.EncodeToString([]byte(SessionMarker))), SessionMarkerHex)
+	assert.Contains(t, StatementMarker, SessionMarker)
+}
+
+func Test_markStatement(t *testing.T) {
+	assert.Equal(t, "SELECT 1 -- trailing comment\n/* nri-mssql */", markStatement("SELECT 1 -- trailing comment"))
+}
+
+func Test_markSession(t *testing.T) {
+	testCases := []struct {
+		name    string
+		execErr error
+	}{
+		{"Marked", nil},
+		{"Exec error", errors.New("permission denied")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			exec := mock.ExpectPrepare(`SET CONTEXT_INFO 0x6E72692D6D7373716C`).ExpectExec()
+			if tc.execErr != nil {
+				exec.WillReturnError(tc.execErr)
+			} else {
+				exec.WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			conn, err := db.Conn(context.Background())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			err = conn.Raw(func(driverConn any) error {
+				return markSession(context.Background(), driverConn.(driver.Conn))
+			})
+			if tc.execErr != nil {
+				assert.ErrorIs(t, err, tc.execErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
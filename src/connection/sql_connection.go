@@ -2,22 +2,27 @@
 package connection
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
-	"strconv"
+	"os"
+	"strings"
+	"time"
 
-	// go-mssqldb is required for mssql driver but isn't used in code
 	"github.com/jmoiron/sqlx"
-	_ "github.com/microsoft/go-mssqldb"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/microsoft/go-mssqldb/azuread"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/secrets"
 )
 
 // SQLConnection represents a wrapper around a SQL Server connection
 type SQLConnection struct {
 	Connection *sqlx.DB
 	Host       string
+	stats      *QueryStats
 }
 
 type AuthConnector interface {
@@ -27,24 +32,152 @@ type AuthConnector interface {
 type SQLAuthConnector struct{}
 
 func (s SQLAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
-	connectionURL := CreateConnectionURL(args, dbName)
-	return sqlx.Connect("mssql", connectionURL)
+	connectionURL, err := CreateConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connect(connectionURL)
 }
 
 type AzureADAuthConnector struct{}
 
 func (a AzureADAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
-	connectionURL := CreateAzureADConnectionURL(args, dbName)
-	return sqlx.Connect(azuread.DriverName, connectionURL)
+	connectionURL, err := CreateAzureADConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connectAzureAD(connectionURL)
+}
+
+// AzureADManagedIdentityAuthConnector authenticates with the system-assigned Managed Identity
+// of the host or, when ManagedIdentityClientID is set, with a user-assigned one.
+type AzureADManagedIdentityAuthConnector struct{}
+
+func (a AzureADManagedIdentityAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := CreateAzureADManagedIdentityConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connectAzureAD(connectionURL)
+}
+
+// AzureADWorkloadIdentityAuthConnector exchanges the federated token projected into the pod
+// (AKS workload identity) for an Entra ID access token.
+type AzureADWorkloadIdentityAuthConnector struct{}
+
+func (a AzureADWorkloadIdentityAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := CreateAzureADWorkloadIdentityConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connectAzureAD(connectionURL)
+}
+
+// AzureADDefaultAuthConnector walks the azidentity default credential chain
+// (environment, workload identity, managed identity, Azure CLI...).
+type AzureADDefaultAuthConnector struct{}
+
+func (a AzureADDefaultAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := CreateAzureADDefaultConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return connectAzureAD(connectionURL)
+}
+
+// AzureADAccessTokenFileAuthConnector uses an access token fetched by an external process and
+// written to AccessTokenFile. The file is read every time the pool opens a new connection so
+// the token can be refreshed without restarting the integration.
+type AzureADAccessTokenFileAuthConnector struct{}
+
+func (a AzureADAccessTokenFileAuthConnector) Connect(args *args.ArgumentList, dbName string) (*sqlx.DB, error) {
+	connectionURL, err := createFedAuthConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenProvider := accessTokenFileProvider(args.AccessTokenFile)
+	connector, err := mssql.NewConnectorWithAccessTokenProvider(connectionURL, func(context.Context) (string, error) {
+		return tokenProvider()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openDB(connector)
+}
+
+// connect opens a pool authenticated as described by connectionURL
+func connect(connectionURL string) (*sqlx.DB, error) {
+	connector, err := mssql.NewConnector(connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	return openDB(connector)
+}
+
+// connectAzureAD opens a pool authenticated with the Azure AD workflow in connectionURL
+func connectAzureAD(connectionURL string) (*sqlx.DB, error) {
+	connector, err := azuread.NewConnector(connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	return openDB(connector)
+}
+
+var errEmptyAccessToken = errors.New("access token file is empty")
+
+// accessTokenFileProvider returns a token provider that reads the token from path on each call
+func accessTokenFileProvider(path string) func() (string, error) {
+	return func() (string, error) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read access token file: %w", err)
+		}
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("%w: %s", errEmptyAccessToken, path)
+		}
+		return token, nil
+	}
 }
 
 func isAzureADServicePrincipalAuth(args *args.ArgumentList) bool {
 	return args.ClientID != "" && args.TenantID != "" && args.ClientSecret != ""
 }
 
-func determineAuthMethod(args *args.ArgumentList) (AuthConnector, error) {
+func determineAuthMethod(arguments *args.ArgumentList) (AuthConnector, error) {
+	switch arguments.AuthMethod {
+	case args.AuthMethodSQL:
+		log.Debug("Using SQL Server authentication")
+		return SQLAuthConnector{}, nil
+	case args.AuthMethodKerberos:
+		log.Debug("Using Kerberos authentication")
+		return KerberosAuthConnector{}, nil
+	case args.AuthMethodNTLM:
+		log.Debug("Using NTLM authentication")
+		return NTLMAuthConnector{}, nil
+	case args.AuthMethodAzureServicePrincipal:
+		log.Debug("Using Azure AD Service Principal authentication")
+		return AzureADAuthConnector{}, nil
+	case args.AuthMethodAzureManagedIdentity:
+		log.Debug("Using Azure AD Managed Identity authentication")
+		return AzureADManagedIdentityAuthConnector{}, nil
+	case args.AuthMethodAzureWorkloadIdentity:
+		log.Debug("Using Azure AD workload identity authentication")
+		return AzureADWorkloadIdentityAuthConnector{}, nil
+	case args.AuthMethodAzureDefault:
+		log.Debug("Using Azure AD default credential chain authentication")
+		return AzureADDefaultAuthConnector{}, nil
+	case args.AuthMethodAzureAccessTokenFile:
+		log.Debug("Using Azure AD access token file authentication")
+		return AzureADAccessTokenFileAuthConnector{}, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported authentication method: %s", arguments.AuthMethod)
+	}
+
 	switch {
-	case isAzureADServicePrincipalAuth(args):
+	case isAzureADServicePrincipalAuth(arguments):
 		log.Debug("Detected Azure AD Service Principal authentication - using ClientID, TenantID, and ClientSecret")
 		return AzureADAuthConnector{}, nil
 	default:
@@ -60,7 +193,16 @@ func createConnectionWithAuth(args *args.ArgumentList, dbName string) (*SQLConne
 		return nil, fmt.Errorf("failed to determine authentication method: %w", err)
 	}
 
-	db, err := connector.Connect(args, dbName)
+	db, err := connectWithRetry(NewRetryPolicy(args), func() (*sqlx.DB, error) {
+		// Secrets are resolved on every attempt so rotated values are picked up without a restart
+		resolvedArgs, err := resolveSecrets(args)
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := connector.Connect(resolvedArgs, dbName)
+		return db, secrets.Redact(err, resolvedArgs.Password, resolvedArgs.ClientSecret)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +212,26 @@ func createConnectionWithAuth(args *args.ArgumentList, dbName string) (*SQLConne
 	}, nil
 }
 
+// resolveSecrets returns a copy of args where Password and ClientSecret references
+// (file:, env:, exec:) are replaced by their current values.
+func resolveSecrets(arguments *args.ArgumentList) (*args.ArgumentList, error) {
+	resolved := *arguments
+
+	password, err := secrets.Resolve(arguments.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve password: %w", err)
+	}
+	resolved.Password = password
+
+	clientSecret, err := secrets.Resolve(arguments.ClientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve client secret: %w", err)
+	}
+	resolved.ClientSecret = clientSecret
+
+	return &resolved, nil
+}
+
 func NewConnection(args *args.ArgumentList) (*SQLConnection, error) {
 	return createConnectionWithAuth(args, "")
 }
@@ -91,107 +253,128 @@ func (sc SQLConnection) Close() {
 
 // Query runs a query and loads results into v
 func (sc SQLConnection) Query(v interface{}, query string) error {
+	return sc.QueryContext(context.Background(), v, query)
+}
+
+// QueryContext runs a query and loads results into v, cancelling it when ctx is done
+func (sc SQLConnection) QueryContext(ctx context.Context, v interface{}, query string) error {
 	log.Debug("Running query: %s", query)
-	return sc.Connection.Select(v, query)
+	defer sc.stats.record(time.Now())
+	return cancelledQueryError(ctx, query, sc.Connection.SelectContext(ctx, v, markStatement(query)))
 }
 
 // Queryx runs a query and returns a set of rows
 func (sc SQLConnection) Queryx(query string) (*sqlx.Rows, error) {
-	return sc.Connection.Queryx(query)
+	return sc.QueryxContext(context.Background(), query)
+}
+
+// QueryxContext runs a query and returns a set of rows, cancelling it when ctx is done. Only the
+// time until the first rows arrive is recorded in the query statistics.
+func (sc SQLConnection) QueryxContext(ctx context.Context, query string) (*sqlx.Rows, error) {
+	defer sc.stats.record(time.Now())
+	rows, err := sc.Connection.QueryxContext(ctx, markStatement(query))
+	return rows, cancelledQueryError(ctx, query, err)
 }
 
 // CreateConnectionURL tags in args and creates the connection string.
 // All args should be validated before calling this.
-func CreateConnectionURL(args *args.ArgumentList, dbName string) string {
-	connectionString := ""
-
-	connectionURL := &url.URL{
-		Scheme: "sqlserver",
-		User:   url.UserPassword(args.Username, args.Password),
-		Host:   args.Hostname,
-	}
-
-	// If port is present use port if not user instance
-	if args.Port != "" {
-		connectionURL.Host = fmt.Sprintf("%s:%s", connectionURL.Host, args.Port)
-	} else {
-		connectionURL.Path = args.Instance
-	}
-
-	// Format query parameters
-	query := url.Values{}
-	query.Add("dial timeout", args.Timeout)
-	query.Add("connection timeout", args.Timeout)
-	if dbName != "" {
-		query.Add("database", dbName)
-		log.Debug("using database name : %s as a query parameter in the connection url", dbName)
-	}
-
-	if args.ExtraConnectionURLArgs != "" {
-		extraArgsMap, err := url.ParseQuery(args.ExtraConnectionURLArgs)
-		if err == nil {
-			for k, v := range extraArgsMap {
-				query.Add(k, v[0])
-			}
-		} else {
-			log.Warn("Could not successfully parse ExtraConnectionURLArgs.", err.Error())
-		}
-
+func CreateConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	cs, err := newConnectionString(args, dbName, WithCredentials(args.Username, args.Password))
+	if err != nil {
+		return "", err
 	}
+	return cs.URL(), nil
+}
 
-	if args.EnableSSL {
-		query.Add("encrypt", "true")
-
-		query.Add("TrustServerCertificate", strconv.FormatBool(args.TrustServerCertificate))
-
+// newConnectionString builds the connection string shared by every authentication method
+// from the target, timeout, TLS and extra arguments in args, followed by options.
+func newConnectionString(args *args.ArgumentList, dbName string, options ...ConnectionOption) (*ConnectionString, error) {
+	appName := args.AppName
+	if extraArgsSet(args.ExtraConnectionURLArgs, paramAppName) {
+		// configurations that named the application through the extra arguments keep working
+		appName = ""
+	}
+	common := []ConnectionOption{
+		WithPort(args.Port),
+		WithInstance(args.Instance),
+		WithDatabase(dbName),
+		WithTimeout(args.Timeout),
+		WithAppName(appName),
+	}
+	switch {
+	case args.StrictEncryption:
+		// strict encryption always verifies the server certificate
+		common = append(common, WithEncrypt("strict"),
+			WithCertificate(args.CertificateLocation),
+			WithHostNameInCertificate(args.HostNameInCertificate))
+	case args.EnableSSL:
+		common = append(common, WithEncrypt("true"), WithTrustServerCertificate(args.TrustServerCertificate))
 		if !args.TrustServerCertificate {
-			query.Add("certificate", args.CertificateLocation)
+			common = append(common, WithCertificate(args.CertificateLocation),
+				WithHostNameInCertificate(args.HostNameInCertificate))
 		}
 	}
+	common = append(common, WithTLSMin(args.MinTLSVersion))
+	// extra arguments go last and override the integration settings, as they did when they were
+	// appended to the URL
+	options = append(common, options...)
+	options = append(options, WithExtraArgs(args.ExtraConnectionURLArgs))
 
-	connectionURL.RawQuery = query.Encode()
-
-	connectionString = connectionURL.String()
-
-	return connectionString
+	return NewConnectionString(args.Hostname, options...)
 }
 
-// CreateAzureADConnectionURL creates a connection string specifically for Azure AD authentication.
-func CreateAzureADConnectionURL(args *args.ArgumentList, dbName string) string {
-	connectionString := fmt.Sprintf(
-		"server=%s;port=%s;database=%s;user id=%s@%s;password=%s;fedauth=ActiveDirectoryServicePrincipal;dial timeout=%s;connection timeout=%s",
-		args.Hostname,
-		args.Port,
-		dbName,
-		args.ClientID,     // Client ID
-		args.TenantID,     // Tenant ID
-		args.ClientSecret, // Client Secret
-		args.Timeout,
-		args.Timeout,
-	)
-
-	if args.ExtraConnectionURLArgs != "" {
-		extraArgsMap, err := url.ParseQuery(args.ExtraConnectionURLArgs)
-		if err == nil {
-			for k, v := range extraArgsMap {
-				connectionString += fmt.Sprintf(";%s=%s", k, v[0])
-			}
-		} else {
-			log.Warn("Could not successfully parse ExtraConnectionURLArgs.", err.Error())
+// extraArgsSet returns true when the extra connection arguments in query set the parameter name
+func extraArgsSet(query, name string) bool {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	for key := range values {
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return true
 		}
 	}
+	return false
+}
 
-	if args.EnableSSL {
-		connectionString += ";encrypt=true"
-		if args.TrustServerCertificate {
-			connectionString += ";TrustServerCertificate=true"
-		} else {
-			connectionString += ";TrustServerCertificate=false"
-			if args.CertificateLocation != "" {
-				connectionString += fmt.Sprintf(";certificate=%s", args.CertificateLocation)
-			}
-		}
+// CreateAzureADConnectionURL creates a connection string specifically for Azure AD authentication.
+func CreateAzureADConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	return createFedAuthConnectionURL(args, dbName,
+		WithFedAuth(azuread.ActiveDirectoryServicePrincipal),
+		WithCredentials(args.ClientID+"@"+args.TenantID, args.ClientSecret))
+}
+
+// CreateAzureADManagedIdentityConnectionURL creates a connection string for Managed Identity authentication.
+// The user id is only set for user-assigned identities.
+func CreateAzureADManagedIdentityConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	return createFedAuthConnectionURL(args, dbName,
+		WithFedAuth(azuread.ActiveDirectoryMSI),
+		WithCredentials(args.ManagedIdentityClientID, ""))
+}
+
+// CreateAzureADWorkloadIdentityConnectionURL creates a connection string for workload identity authentication.
+// ClientID and TenantID are optional, the driver falls back to AZURE_CLIENT_ID and AZURE_TENANT_ID.
+func CreateAzureADWorkloadIdentityConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	userID := args.ClientID
+	if userID != "" && args.TenantID != "" {
+		userID = args.ClientID + "@" + args.TenantID
 	}
+	return createFedAuthConnectionURL(args, dbName,
+		WithFedAuth(azuread.ActiveDirectoryWorkloadIdentity),
+		WithCredentials(userID, ""),
+		WithTokenFilePath(args.GetFederatedTokenFile()))
+}
+
+// CreateAzureADDefaultConnectionURL creates a connection string for the default credential chain.
+func CreateAzureADDefaultConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	return createFedAuthConnectionURL(args, dbName, WithFedAuth(azuread.ActiveDirectoryDefault))
+}
 
-	return connectionString
+// createFedAuthConnectionURL renders the ADO style connection string shared by every Azure AD workflow
+func createFedAuthConnectionURL(args *args.ArgumentList, dbName string, options ...ConnectionOption) (string, error) {
+	cs, err := newConnectionString(args, dbName, options...)
+	if err != nil {
+		return "", err
+	}
+	return cs.ADO(), nil
 }
@@ -13,16 +13,66 @@ import (
 )
 
 const (
-	// databaseNameQuery gets all database names
-	databaseNameQuery                          = "select name as db_name from sys.databases where name not in ('master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')"
+	// ExcludedDatabases lists the system databases that are not reported as entities, for use in a NOT IN clause
+	ExcludedDatabases = "'master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster'"
+	// databaseNameQuery gets all database names and their state
+	databaseNameQuery = "select name as db_name, state_desc as state, user_access_desc as user_access, CAST(is_read_only AS INT) as read_only, " +
+		"recovery_model_desc as recovery_model, CAST(is_in_standby AS INT) as standby from sys.databases where name not in (" + ExcludedDatabases + ")"
 	engineEditionQuery                         = "SELECT SERVERPROPERTY('EngineEdition') AS EngineEdition;"
+	ExpressEngineEditionNumber                 = 4
 	AzureSQLDatabaseEngineEditionNumber        = 5
 	AzureSQLManagedInstanceEngineEditionNumber = 8
 )
 
-// NameRow is a row result in the databaseNameQuery
+// unqueryableStates are the database states in which no query can run in the database
+var unqueryableStates = map[string]bool{
+	"RESTORING":         true,
+	"RECOVERING":        true,
+	"RECOVERY_PENDING":  true,
+	"SUSPECT":           true,
+	"EMERGENCY":         true,
+	"OFFLINE":           true,
+	"COPYING":           true,
+	"OFFLINE_SECONDARY": true,
+}
+
+// NameRow is a row result in the databaseNameQuery. It is reported on the MssqlDatabaseSample
+// of every database, whether or not it can be queried.
 type NameRow struct {
-	DBName string `db:"db_name"`
+	DataModel
+	State         *string `db:"state" metric_name:"db.state" source_type:"attribute"`
+	UserAccess    *string `db:"user_access" metric_name:"db.userAccess" source_type:"attribute"`
+	ReadOnly      *int64  `db:"read_only" metric_name:"db.readOnly" source_type:"gauge"`
+	RecoveryModel *string `db:"recovery_model" metric_name:"db.recoveryModel" source_type:"attribute"`
+	Standby       *int64  `db:"standby" metric_name:"db.standby" source_type:"gauge"`
+}
+
+// Queryable reports whether queries can run in the database. A database whose state is
+// unknown is assumed to be queryable.
+func (r NameRow) Queryable() bool {
+	return r.State == nil || !unqueryableStates[*r.State]
+}
+
+// GetDatabases retrieves every database we're collecting along with its state
+func GetDatabases(con *connection.SQLConnection) ([]*NameRow, error) {
+	databaseRows := make([]*NameRow, 0)
+	if err := con.Query(&databaseRows, databaseNameQuery); err != nil {
+		return nil, err
+	}
+	return databaseRows, nil
+}
+
+// QueryableDBNames returns the names of the databases in rows that can be queried
+func QueryableDBNames(rows []*NameRow) []string {
+	dbNames := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !row.Queryable() {
+			log.Debug("Database %s is %s, skipping its database specific queries", row.DBName, *row.State)
+			continue
+		}
+		dbNames = append(dbNames, row.DBName)
+	}
+	return dbNames
 }
 
 // DataModeler represents a data model for a database query
@@ -40,18 +90,11 @@ func (dm DataModel) GetDBName() string {
 	return dm.DBName
 }
 
-// CreateDatabaseEntities instantiates an entity for each database we're collecting
-func CreateDatabaseEntities(i *integration.Integration, con *connection.SQLConnection, instanceName string) ([]*integration.Entity, error) {
-	databaseRows := make([]*NameRow, 0)
-	if err := con.Query(&databaseRows, databaseNameQuery); err != nil {
-		return nil, err
-	}
-
-	instanceIDAttr := integration.NewIDAttribute("instance", instanceName)
+// CreateDatabaseEntities instantiates an entity for each database in databaseRows
+func CreateDatabaseEntities(i *integration.Integration, host, instanceName string, databaseRows []*NameRow) ([]*integration.Entity, error) {
 	dbEntities := make([]*integration.Entity, 0, len(databaseRows))
 	for _, row := range databaseRows {
-		databaseIDAttr := integration.NewIDAttribute("database", row.DBName)
-		dbEntity, err := i.EntityReportedVia(con.Host, row.DBName, "ms-database", instanceIDAttr, databaseIDAttr)
+		dbEntity, err := CreateDatabaseEntity(i, host, instanceName, row.DBName)
 		if err != nil {
 			return nil, err
 		}
@@ -62,6 +105,13 @@ func CreateDatabaseEntities(i *integration.Integration, con *connection.SQLConne
 	return dbEntities, nil
 }
 
+// CreateDatabaseEntity returns the entity of database dbName, creating it if it does not exist yet
+func CreateDatabaseEntity(i *integration.Integration, host, instanceName, dbName string) (*integration.Entity, error) {
+	instanceIDAttr := integration.NewIDAttribute("instance", instanceName)
+	databaseIDAttr := integration.NewIDAttribute("database", dbName)
+	return i.EntityReportedVia(host, dbName, "ms-database", instanceIDAttr, databaseIDAttr)
+}
+
 // DBMetricSetLookup represents a cache of Database entitiy names
 // to their corresponding metric set
 type DBMetricSetLookup map[string]*metric.Set
@@ -13,23 +13,34 @@ import (
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
-func Test_createDatabaseEntities_QueryError(t *testing.T) {
-	i, err := integration.New("test", "1.0.0")
-	if err != nil {
-		t.Errorf("Unexpected error %s", err.Error())
-		t.FailNow()
-	}
-
+func Test_getDatabases_QueryError(t *testing.T) {
 	conn, mock := connection.CreateMockSQL(t)
 
-	mock.ExpectQuery(`select name as db_name from sys.databases where`).WillReturnError(errors.New("error"))
+	mock.ExpectQuery(`select name as db_name, .* from sys.databases where`).WillReturnError(errors.New("error"))
 
-	instanceName := "testInstanceName"
-	if _, err := CreateDatabaseEntities(i, conn, instanceName); err == nil {
+	if _, err := GetDatabases(conn); err == nil {
 		t.Error("Did not return expected error")
 	}
 }
 
+func Test_getDatabases_State(t *testing.T) {
+	conn, mock := connection.CreateMockSQL(t)
+
+	rows := sqlmock.NewRows([]string{"db_name", "state", "user_access", "read_only", "recovery_model", "standby"}).
+		AddRow("sales", "ONLINE", "MULTI_USER", 0, "FULL", 0).
+		AddRow("archive", "OFFLINE", "MULTI_USER", 1, "SIMPLE", 0).
+		AddRow("reporting", "ONLINE", "MULTI_USER", 1, "FULL", 1).
+		AddRow("staging", "RESTORING", "MULTI_USER", 0, "FULL", 0)
+	mock.ExpectQuery(`select name as db_name, .* from sys.databases where`).WillReturnRows(rows)
+
+	databases, err := GetDatabases(conn)
+	assert.NoError(t, err)
+	assert.Len(t, databases, 4)
+	assert.Equal(t, "OFFLINE", *databases[1].State)
+	assert.Equal(t, int64(1), *databases[2].Standby)
+	assert.Equal(t, []string{"sales", "reporting"}, QueryableDBNames(databases))
+}
+
 func Test_createDatabaseEntities(t *testing.T) {
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
@@ -37,15 +48,13 @@ func Test_createDatabaseEntities(t *testing.T) {
 		t.FailNow()
 	}
 
-	conn, mock := connection.CreateMockSQL(t)
-
-	rows := sqlmock.NewRows([]string{"db_name"}).
-		AddRow("master").
-		AddRow("tempdb")
-	mock.ExpectQuery(`select name as db_name from sys.databases where`).WillReturnRows(rows)
+	databaseRows := []*NameRow{
+		{DataModel: DataModel{DBName: "master"}},
+		{DataModel: DataModel{DBName: "tempdb"}},
+	}
 
 	instanceName := "testInstanceName"
-	dbEntities, err := CreateDatabaseEntities(i, conn, instanceName)
+	dbEntities, err := CreateDatabaseEntities(i, "testHost", instanceName, databaseRows)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 		t.FailNow()
@@ -2,6 +2,8 @@
 package inventory
 
 import (
+	"context"
+
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-mssql/src/connection"
@@ -34,32 +36,32 @@ type ConfigQueryRow struct {
 	Value int    `db:"value"`
 }
 
-type spConfigItemsProcessor func(instanceEntity *integration.Entity, connection *connection.SQLConnection) error
+type spConfigItemsProcessor func(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) error
 
 // PopulateInventory gathers inventory data for the SQL Server instance and populates it into entity
-func PopulateInventory(instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) {
-	if err := populateSPConfigItems(instanceEntity, connection, engineEdition); err != nil {
+func PopulateInventory(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) {
+	if err := populateSPConfigItems(ctx, instanceEntity, connection, engineEdition); err != nil {
 		log.Error("Error collecting inventory items from sp_config: %s", err.Error())
 	}
 
-	if err := populateSysConfigItems(instanceEntity, connection); err != nil {
+	if err := populateSysConfigItems(ctx, instanceEntity, connection); err != nil {
 		log.Error("Error collecting inventory items from sys.configurations: %s", err.Error())
 	}
 }
 
 // populateSPConfigItems collects inventory items for sp_configure procedure
-func populateSPConfigItems(instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) error {
+func populateSPConfigItems(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) error {
 	processor := spConfigProcessorFunctionSet.Select(engineEdition)
-	if err := processor(instanceEntity, connection); err != nil {
+	if err := processor(ctx, instanceEntity, connection); err != nil {
 		return err
 	}
 	return nil
 }
 
 // populateSysConfigItems collect inventory items from sys.configurations
-func populateSysConfigItems(instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
+func populateSysConfigItems(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
 	configRows := make([]*ConfigQueryRow, 0)
-	if err := connection.Query(&configRows, sysConfigQuery); err != nil {
+	if err := connection.QueryContext(ctx, &configRows, sysConfigQuery); err != nil {
 		return err
 	}
 
@@ -86,9 +88,9 @@ var spConfigProcessorFunctionSet = metrics.EngineSet[spConfigItemsProcessor]{
 	AzureSQLManagedInstance: processSPConfigItems,
 }
 
-func processSPConfigItems(instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
+func processSPConfigItems(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
 	configRows := make([]*SPConfigRow, 0)
-	if err := connection.Query(&configRows, spConfigQuery); err != nil {
+	if err := connection.QueryContext(ctx, &configRows, spConfigQuery); err != nil {
 		return err
 	}
 
@@ -99,9 +101,9 @@ func processSPConfigItems(instanceEntity *integration.Entity, connection *connec
 	return nil
 }
 
-func processAzureSQLDatabaseSPConfigItems(instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
+func processAzureSQLDatabaseSPConfigItems(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) error {
 	configRows := make([]*SPConfigRowForAzureSQLDatabase, 0)
-	if err := connection.Query(&configRows, spConfigQueryForAzureSQLDatabase); err != nil {
+	if err := connection.QueryContext(ctx, &configRows, spConfigQueryForAzureSQLDatabase); err != nil {
 		return err
 	}
 
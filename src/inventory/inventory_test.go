@@ -1,6 +1,7 @@
 package inventory
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"testing"
@@ -56,7 +57,7 @@ func runTestPopulateInventory(t *testing.T, tt InventoryTestCase) {
 		tt.spConfigSetup(mock)
 		tt.sysConfigSetup(mock)
 
-		PopulateInventory(e, conn, tt.engineEditionValue)
+		PopulateInventory(context.Background(), e, conn, tt.engineEditionValue)
 
 		// Validate inventory
 		equal := true
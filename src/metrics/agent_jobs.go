@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+)
+
+// agentJobQuery summarises the history, activity and schedules of every SQL Server Agent job. The
+// outcome of a run is the history row with step_id 0, its duration is stored as an HHMMSS integer.
+// A running job is compared with the 95th percentile of its successful runs once it has at least
+// five of them.
+const agentJobQuery = `WITH outcomes AS (
+		SELECT h.job_id, h.instance_id, h.run_status,
+			(h.run_duration / 10000) * 3600 + (h.run_duration / 100 % 100) * 60 + h.run_duration % 100 AS duration_seconds
+		FROM msdb.dbo.sysjobhistory h
+		WHERE h.step_id = 0
+	),
+	last_outcome AS (
+		SELECT job_id, run_status, duration_seconds,
+			ROW_NUMBER() OVER (PARTITION BY job_id ORDER BY instance_id DESC) AS rn
+		FROM outcomes
+	),
+	last_success AS (
+		SELECT job_id, MAX(instance_id) AS instance_id FROM outcomes WHERE run_status = 1 GROUP BY job_id
+	),
+	failures AS (
+		SELECT o.job_id, COUNT(*) AS consecutive_failures
+		FROM outcomes o
+		LEFT JOIN last_success s ON s.job_id = o.job_id
+		WHERE o.run_status = 0 AND o.instance_id > ISNULL(s.instance_id, 0)
+		GROUP BY o.job_id
+	),
+	durations AS (
+		SELECT DISTINCT job_id,
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_seconds) OVER (PARTITION BY job_id) AS p95_duration,
+			COUNT(*) OVER (PARTITION BY job_id) AS successful_runs
+		FROM outcomes
+		WHERE run_status = 1
+	),
+	activity AS (
+		SELECT a.job_id, a.start_execution_date
+		FROM msdb.dbo.sysjobactivity a
+		WHERE a.session_id = (SELECT MAX(session_id) FROM msdb.dbo.syssessions)
+			AND a.start_execution_date IS NOT NULL
+			AND a.stop_execution_date IS NULL
+	),
+	next_runs AS (
+		SELECT job_id, MIN(msdb.dbo.agent_datetime(next_run_date, next_run_time)) AS next_run
+		FROM msdb.dbo.sysjobschedules
+		WHERE next_run_date > 0
+		GROUP BY job_id
+	)
+	SELECT
+		j.name AS job_name,
+		c.name AS category,
+		CAST(j.enabled AS INT) AS enabled,
+		CASE lo.run_status WHEN 0 THEN 'Failed' WHEN 1 THEN 'Succeeded' WHEN 2 THEN 'Retry' WHEN 3 THEN 'Canceled' WHEN 4 THEN 'In Progress' END AS last_run_outcome,
+		lo.duration_seconds AS last_run_duration,
+		ISNULL(f.consecutive_failures, 0) AS consecutive_failures,
+		CASE WHEN a.job_id IS NULL THEN 0 ELSE 1 END AS running,
+		DATEDIFF(SECOND, a.start_execution_date, GETDATE()) AS running_seconds,
+		d.p95_duration,
+		CASE WHEN a.job_id IS NOT NULL AND d.successful_runs >= 5 AND DATEDIFF(SECOND, a.start_execution_date, GETDATE()) > d.p95_duration
+			THEN 'true' ELSE 'false' END AS running_longer_than_p95,
+		CONVERT(VARCHAR(19), nr.next_run, 126) AS next_run_time,
+		DATEDIFF(SECOND, GETDATE(), nr.next_run) AS seconds_until_next_run
+	FROM msdb.dbo.sysjobs j
+	LEFT JOIN msdb.dbo.syscategories c ON c.category_id = j.category_id
+	LEFT JOIN last_outcome lo ON lo.job_id = j.job_id AND lo.rn = 1
+	LEFT JOIN failures f ON f.job_id = j.job_id
+	LEFT JOIN durations d ON d.job_id = j.job_id
+	LEFT JOIN activity a ON a.job_id = j.job_id
+	LEFT JOIN next_runs nr ON nr.job_id = j.job_id`
+
+// agentJobModel is a row result of agentJobQuery
+type agentJobModel struct {
+	JobName              string   `db:"job_name"`
+	Category             *string  `db:"category" metric_name:"job.category" source_type:"attribute"`
+	Enabled              *int64   `db:"enabled" metric_name:"job.enabled" source_type:"gauge"`
+	LastRunOutcome       *string  `db:"last_run_outcome" metric_name:"job.lastRunOutcome" source_type:"attribute"`
+	LastRunDuration      *int64   `db:"last_run_duration" metric_name:"job.lastRunDurationInSeconds" source_type:"gauge"`
+	ConsecutiveFailures  *int64   `db:"consecutive_failures" metric_name:"job.consecutiveFailures" source_type:"gauge"`
+	Running              *int64   `db:"running" metric_name:"job.running" source_type:"gauge"`
+	RunningSeconds       *int64   `db:"running_seconds" metric_name:"job.runningTimeInSeconds" source_type:"gauge"`
+	P95Duration          *float64 `db:"p95_duration" metric_name:"job.p95DurationInSeconds" source_type:"gauge"`
+	RunningLongerThanP95 *string  `db:"running_longer_than_p95" metric_name:"job.runningLongerThanP95" source_type:"attribute"`
+	NextRunTime          *string  `db:"next_run_time" metric_name:"job.nextRunTime" source_type:"attribute"`
+	SecondsUntilNextRun  *int64   `db:"seconds_until_next_run" metric_name:"job.secondsUntilNextRun" source_type:"gauge"`
+}
+
+type agentJobCollector func(context.Context, *integration.Entity, *connection.SQLConnection)
+
+// Azure SQL Database has no SQL Server Agent, elastic jobs are not stored in msdb
+var agentJobCollectorSet = EngineSet[agentJobCollector]{
+	Default:                 collectAgentJobs,
+	AzureSQLDatabase:        skipAgentJobs,
+	AzureSQLManagedInstance: collectAgentJobs,
+}
+
+// PopulateAgentJobMetrics reports the state of each SQL Server Agent job in a MssqlAgentJobSample
+func PopulateAgentJobMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) {
+	// Express ships without SQL Server Agent
+	if engineEdition == database.ExpressEngineEditionNumber {
+		skipAgentJobs(ctx, instanceEntity, connection)
+		return
+	}
+	agentJobCollectorSet.Select(engineEdition)(ctx, instanceEntity, connection)
+}
+
+func skipAgentJobs(context.Context, *integration.Entity, *connection.SQLConnection) {
+	log.Debug("Skipping agent job metrics, SQL Server Agent is not available in this edition")
+}
+
+func collectAgentJobs(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) {
+	models := make([]agentJobModel, 0)
+	if err := connection.QueryContext(ctx, &models, agentJobQuery); err != nil {
+		log.Error("Could not execute agent job query: %s", err.Error())
+		return
+	}
+
+	for _, model := range models {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlAgentJobSample", model,
+			attribute.Attribute{Key: "jobName", Value: model.JobName})
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_PopulateAgentJobMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM msdb\.dbo\.sysjobs j`).WillReturnRows(
+		sqlmock.NewRows([]string{"job_name", "category", "enabled", "last_run_outcome", "last_run_duration", "consecutive_failures",
+			"running", "running_seconds", "p95_duration", "running_longer_than_p95", "next_run_time", "seconds_until_next_run"}).
+			AddRow("nightly etl", "Data Collector", 1, "Failed", 3725, 2, 1, 5400, 4100.5, "true", "2026-10-17T02:00:00", 36000).
+			AddRow("never run", nil, 0, nil, nil, 0, 0, nil, nil, "false", nil, nil))
+
+	PopulateAgentJobMetrics(context.Background(), e, conn, 3)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, e.Metrics, 2)
+
+	etl := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlAgentJobSample", etl["event_type"])
+	assert.Equal(t, "nightly etl", etl["jobName"])
+	assert.Equal(t, "Failed", etl["job.lastRunOutcome"])
+	assert.Equal(t, float64(3725), etl["job.lastRunDurationInSeconds"])
+	assert.Equal(t, float64(2), etl["job.consecutiveFailures"])
+	assert.Equal(t, float64(1), etl["job.running"])
+	assert.Equal(t, float64(5400), etl["job.runningTimeInSeconds"])
+	assert.Equal(t, "true", etl["job.runningLongerThanP95"])
+	assert.Equal(t, "2026-10-17T02:00:00", etl["job.nextRunTime"])
+
+	neverRun := e.Metrics[1].Metrics
+	assert.Equal(t, float64(0), neverRun["job.enabled"])
+	assert.NotContains(t, neverRun, "job.lastRunOutcome")
+	assert.NotContains(t, neverRun, "job.nextRunTime")
+}
+
+func Test_PopulateAgentJobMetrics_NoAgent(t *testing.T) {
+	for _, engineEdition := range []int{database.ExpressEngineEditionNumber, database.AzureSQLDatabaseEngineEditionNumber} {
+		_, e := createTestEntity(t)
+		conn, mock := connection.CreateMockSQL(t)
+
+		PopulateAgentJobMetrics(context.Background(), e, conn, engineEdition)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Empty(t, e.Metrics)
+	}
+}
+
+func Test_PopulateAgentJobMetrics_QueryError(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM msdb\.dbo\.sysjobs j`).WillReturnError(errors.New("The SELECT permission was denied on the object 'sysjobs'"))
+
+	PopulateAgentJobMetrics(context.Background(), e, conn, database.AzureSQLManagedInstanceEngineEditionNumber)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, e.Metrics)
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/nri-mssql/src/database"
+)
+
+// logBackupWindowPlaceholder placeholder for the minutes a FULL recovery database may go without a log backup
+const logBackupWindowPlaceholder = "%LOG_BACKUP_WINDOW%"
+
+// backupModel is a row result of the backup queries. Ages are nil for databases that were never backed up.
+type backupModel struct {
+	database.DataModel
+	RecoveryModel          *string  `db:"recovery_model" metric_name:"db.recoveryModel" source_type:"attribute"`
+	HoursSinceFull         *float64 `db:"hours_since_full" metric_name:"backup.hoursSinceLastFull" source_type:"gauge"`
+	HoursSinceDifferential *float64 `db:"hours_since_differential" metric_name:"backup.hoursSinceLastDifferential" source_type:"gauge"`
+	HoursSinceLog          *float64 `db:"hours_since_log" metric_name:"backup.hoursSinceLastLog" source_type:"gauge"`
+	LastBackupSize         *int64   `db:"last_backup_size" metric_name:"backup.lastSizeInBytes" source_type:"gauge"`
+	LastBackupDuration     *int64   `db:"last_backup_duration" metric_name:"backup.lastDurationInSeconds" source_type:"gauge"`
+	LastBackupDestination  *string  `db:"last_backup_destination" metric_name:"backup.lastDestination" source_type:"attribute"`
+	LogBackupOverdue       *string  `db:"log_backup_overdue" metric_name:"backup.logBackupOverdue" source_type:"attribute"`
+}
+
+// logBackupWindowReplace inserts the log backup window, in minutes, into a query anywhere
+// logBackupWindowPlaceholder is present
+func logBackupWindowReplace(minutes int) QueryModifier {
+	return func(query string) string {
+		return strings.ReplaceAll(query, logBackupWindowPlaceholder, strconv.Itoa(minutes))
+	}
+}
+
+// backupDefinitions read the backup history kept in msdb. Copy-only log backups do not
+// truncate the log, so they do not count as log backups.
+var backupDefinitions = []*QueryDefinition{
+	{
+		query: `SELECT
+		d.name AS db_name,
+		d.recovery_model_desc AS recovery_model,
+		DATEDIFF(SECOND, f.last_backup, GETDATE()) / 3600.0 AS hours_since_full,
+		DATEDIFF(SECOND, i.last_backup, GETDATE()) / 3600.0 AS hours_since_differential,
+		DATEDIFF(SECOND, l.last_backup, GETDATE()) / 3600.0 AS hours_since_log,
+		lb.backup_size AS last_backup_size,
+		DATEDIFF(SECOND, lb.backup_start_date, lb.backup_finish_date) AS last_backup_duration,
+		lb.physical_device_name AS last_backup_destination,
+		CASE WHEN d.recovery_model_desc = 'FULL' AND (l.last_backup IS NULL OR l.last_backup < DATEADD(MINUTE, -%LOG_BACKUP_WINDOW%, GETDATE()))
+			THEN 'true' ELSE 'false' END AS log_backup_overdue
+		FROM sys.databases d
+		OUTER APPLY (SELECT MAX(b.backup_finish_date) AS last_backup FROM msdb.dbo.backupset b WHERE b.database_name = d.name AND b.type = 'D') f
+		OUTER APPLY (SELECT MAX(b.backup_finish_date) AS last_backup FROM msdb.dbo.backupset b WHERE b.database_name = d.name AND b.type = 'I') i
+		OUTER APPLY (SELECT MAX(b.backup_finish_date) AS last_backup FROM msdb.dbo.backupset b WHERE b.database_name = d.name AND b.type = 'L' AND b.is_copy_only = 0) l
+		OUTER APPLY (
+			SELECT TOP 1 b.backup_size, b.backup_start_date, b.backup_finish_date, mf.physical_device_name
+			FROM msdb.dbo.backupset b
+			JOIN msdb.dbo.backupmediafamily mf ON mf.media_set_id = b.media_set_id AND mf.family_sequence_number = 1
+			WHERE b.database_name = d.name
+			ORDER BY b.backup_finish_date DESC
+		) lb
+		WHERE d.name NOT IN (` + database.ExcludedDatabases + `)`,
+		dataModels: &[]backupModel{},
+	},
+}
+
+// backupDefinitionsForAzureSQLDatabase read the automated backups of the current database. The
+// service does not report their size or destination and every database uses the FULL recovery model.
+var backupDefinitionsForAzureSQLDatabase = []*QueryDefinition{
+	{
+		query: `SELECT
+			DB_NAME() AS db_name,
+			d.recovery_model_desc AS recovery_model,
+			DATEDIFF(SECOND, MAX(CASE WHEN b.backup_type = 'D' THEN b.backup_finish_date END), GETUTCDATE()) / 3600.0 AS hours_since_full,
+			DATEDIFF(SECOND, MAX(CASE WHEN b.backup_type = 'I' THEN b.backup_finish_date END), GETUTCDATE()) / 3600.0 AS hours_since_differential,
+			DATEDIFF(SECOND, MAX(CASE WHEN b.backup_type = 'L' THEN b.backup_finish_date END), GETUTCDATE()) / 3600.0 AS hours_since_log,
+			CASE WHEN d.recovery_model_desc = 'FULL' AND ISNULL(MAX(CASE WHEN b.backup_type = 'L' THEN b.backup_finish_date END), '19000101') < DATEADD(MINUTE, -%LOG_BACKUP_WINDOW%, GETUTCDATE())
+				THEN 'true' ELSE 'false' END AS log_backup_overdue
+			FROM sys.databases d
+			LEFT JOIN sys.dm_database_backups b ON b.logical_database_name = d.name
+			WHERE d.database_id = DB_ID()
+			GROUP BY d.recovery_model_desc
+		`,
+		dataModels: &[]backupModel{},
+	},
+}
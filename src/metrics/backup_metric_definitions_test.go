@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_logBackupWindowReplace(t *testing.T) {
+	query := "DATEADD(MINUTE, -" + logBackupWindowPlaceholder + ", GETDATE())"
+	assert.Equal(t, "DATEADD(MINUTE, -90, GETDATE())", logBackupWindowReplace(90)(query))
+}
+
+func Test_processBackupDefinitions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		engineEdition int
+		queryPattern  string
+	}{
+		{"Default", 0, `FROM msdb\.dbo\.backupset b\s+JOIN msdb\.dbo\.backupmediafamily mf`},
+		{"Azure SQL Managed Instance", database.AzureSQLManagedInstanceEngineEditionNumber, `FROM msdb\.dbo\.backupset`},
+		{"Azure SQL Database", database.AzureSQLDatabaseEngineEditionNumber, `LEFT JOIN sys\.dm_database_backups b`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, mock := connection.CreateMockSQL(t)
+			mock.ExpectQuery(tc.queryPattern + `.*`).WillReturnRows(
+				sqlmock.NewRows([]string{"db_name", "recovery_model", "hours_since_full", "hours_since_differential", "hours_since_log", "log_backup_overdue"}).
+					AddRow("sales", "FULL", 26.5, nil, 3.25, "true"))
+
+			modelChan := make(chan interface{}, 10)
+			processBackupDefinitions(context.Background(), conn, args.ArgumentList{LogBackupWindowMinutes: 120}, tc.engineEdition, modelChan)
+			close(modelChan)
+			require.NoError(t, mock.ExpectationsWereMet())
+
+			models := make([]backupModel, 0)
+			for model := range modelChan {
+				models = append(models, model.(backupModel))
+			}
+			require.Len(t, models, 1)
+			assert.Equal(t, "sales", models[0].GetDBName())
+			assert.Equal(t, 26.5, *models[0].HoursSinceFull)
+			assert.Nil(t, models[0].HoursSinceDifferential)
+			assert.Equal(t, "true", *models[0].LogBackupOverdue)
+		})
+	}
+}
+
+func Test_processBackupDefinitions_Window(t *testing.T) {
+	conn, mock := connection.CreateMockSQL(t)
+	mock.ExpectQuery(`DATEADD\(MINUTE, -45, GETDATE\(\)\)`).WillReturnRows(sqlmock.NewRows([]string{"db_name"}))
+
+	processBackupDefinitions(context.Background(), conn, args.ArgumentList{LogBackupWindowMinutes: 45}, 0, make(chan interface{}, 1))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// cpuQuery reads the CPU utilization that the scheduler monitor records every minute in its ring
+// buffer. The record timestamp is in milliseconds since the machine started, as ms_ticks.
+const cpuQuery = `SELECT TOP 30
+		rb.record_id,
+		rb.sql_process,
+		100 - rb.system_idle - rb.sql_process AS other_process,
+		rb.system_idle,
+		CONVERT(VARCHAR(19), DATEADD(MILLISECOND, -1 * (si.ms_ticks - rb.[timestamp]), GETUTCDATE()), 126) AS record_time
+		FROM (
+			SELECT
+				[timestamp],
+				record.value('(./Record/@id)[1]', 'bigint') AS record_id,
+				record.value('(./Record/SchedulerMonitorEvent/SystemHealth/ProcessUtilization)[1]', 'int') AS sql_process,
+				record.value('(./Record/SchedulerMonitorEvent/SystemHealth/SystemIdle)[1]', 'int') AS system_idle
+			FROM (
+				SELECT [timestamp], CONVERT(XML, record) AS record
+				FROM sys.dm_os_ring_buffers
+				WHERE ring_buffer_type = N'RING_BUFFER_SCHEDULER_MONITOR'
+				AND record LIKE N'%<SystemHealth>%'
+			) AS buffers
+		) AS rb
+		CROSS JOIN sys.dm_os_sys_info si
+		ORDER BY rb.record_id DESC`
+
+// cpuQueryForAzureSQLDatabase reads the CPU used by the database every 15 seconds, relative to
+// the limit of its service tier. The end of each interval identifies it.
+const cpuQueryForAzureSQLDatabase = `SELECT TOP 30
+		DATEDIFF_BIG(SECOND, '19700101', end_time) AS record_id,
+		avg_cpu_percent AS sql_process,
+		CONVERT(VARCHAR(19), end_time, 126) AS record_time
+		FROM sys.dm_db_resource_stats
+		ORDER BY end_time DESC`
+
+// cpuQueryForAzureSQLManagedInstance reads the CPU used by the managed instance, aggregated over
+// intervals of a few minutes. The end of each interval identifies it.
+const cpuQueryForAzureSQLManagedInstance = `SELECT TOP 30
+		DATEDIFF_BIG(SECOND, '19700101', end_time) AS record_id,
+		avg_cpu_percent AS sql_process,
+		CONVERT(VARCHAR(19), end_time, 126) AS record_time
+		FROM master.sys.server_resource_stats
+		ORDER BY end_time DESC`
+
+var cpuQuerySet = EngineSet[string]{
+	Default:                 cpuQuery,
+	AzureSQLDatabase:        cpuQueryForAzureSQLDatabase,
+	AzureSQLManagedInstance: cpuQueryForAzureSQLManagedInstance,
+}
+
+// cpuModel is a row result of the CPU queries, newest first. Azure only reports the CPU used by SQL Server.
+type cpuModel struct {
+	RecordID     int64    `db:"record_id"`
+	SQLProcess   *float64 `db:"sql_process" metric_name:"cpu.sqlProcessPercent" source_type:"gauge"`
+	OtherProcess *float64 `db:"other_process" metric_name:"cpu.otherProcessPercent" source_type:"gauge"`
+	SystemIdle   *float64 `db:"system_idle" metric_name:"cpu.systemIdlePercent" source_type:"gauge"`
+	RecordTime   *string  `db:"record_time" metric_name:"cpu.recordTime" source_type:"attribute"`
+}
+
+// PopulateCPUMetrics reports a MssqlCpuSample for each CPU utilization record written since the
+// previous run. The first run, and the first one after the records restarted with SQL Server,
+// only reports the latest record.
+func PopulateCPUMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, store *state.Store, engineEdition int) {
+	models := make([]cpuModel, 0)
+	if err := connection.QueryContext(ctx, &models, cpuQuerySet.Select(engineEdition)); err != nil {
+		log.Error("Could not execute CPU query: %s", err.Error())
+		return
+	}
+	if len(models) == 0 {
+		return
+	}
+
+	key := fmt.Sprintf("cpu-%s-%s", connection.Host, instanceEntity.Metadata.Name)
+	mark, ok := store.HighWaterMark(key)
+	store.SetHighWaterMark(key, models[0].RecordID)
+
+	if !ok || models[0].RecordID < mark {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlCpuSample", models[0])
+		return
+	}
+	for idx := len(models) - 1; idx >= 0; idx-- {
+		if models[idx].RecordID > mark {
+			MarshalEntitySample(instanceEntity, connection.Host, "MssqlCpuSample", models[idx])
+		}
+	}
+}
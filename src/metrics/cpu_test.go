@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var cpuColumns = []string{"record_id", "sql_process", "other_process", "system_idle", "record_time"}
+
+func Test_PopulateCPUMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	query := `FROM sys\.dm_os_ring_buffers\s+WHERE ring_buffer_type = N'RING_BUFFER_SCHEDULER_MONITOR'`
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cpuColumns).
+		AddRow(1002, 35, 5, 60, "2026-10-16T10:02:00").
+		AddRow(1001, 30, 10, 60, "2026-10-16T10:01:00"))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cpuColumns).
+		AddRow(1004, 80, 0, 20, "2026-10-16T10:04:00").
+		AddRow(1003, 50, 5, 45, "2026-10-16T10:03:00").
+		AddRow(1002, 35, 5, 60, "2026-10-16T10:02:00"))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cpuColumns).
+		AddRow(1004, 80, 0, 20, "2026-10-16T10:04:00"))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cpuColumns).
+		AddRow(3, 12, 3, 85, "2026-10-16T10:10:00").
+		AddRow(2, 10, 5, 85, "2026-10-16T10:09:00"))
+
+	PopulateCPUMetrics(context.Background(), e, conn, store, 3)
+	samples := samplesOfType(i, "MssqlCpuSample")
+	require.Len(t, samples, 1, "the first run only reports the latest record")
+	assert.Equal(t, float64(35), samples[0]["cpu.sqlProcessPercent"])
+
+	PopulateCPUMetrics(context.Background(), e, conn, store, 3)
+	samples = samplesOfType(i, "MssqlCpuSample")
+	require.Len(t, samples, 3)
+	assert.Equal(t, "2026-10-16T10:03:00", samples[1]["cpu.recordTime"])
+	assert.Equal(t, float64(80), samples[2]["cpu.sqlProcessPercent"])
+	assert.Equal(t, float64(20), samples[2]["cpu.systemIdlePercent"])
+
+	PopulateCPUMetrics(context.Background(), e, conn, store, 3)
+	assert.Len(t, samplesOfType(i, "MssqlCpuSample"), 3, "records already reported are skipped")
+
+	PopulateCPUMetrics(context.Background(), e, conn, store, 3)
+	samples = samplesOfType(i, "MssqlCpuSample")
+	require.Len(t, samples, 4, "after a restart only the latest record is reported")
+	assert.Equal(t, float64(12), samples[3]["cpu.sqlProcessPercent"])
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_PopulateCPUMetrics_Azure(t *testing.T) {
+	testCases := []struct {
+		name          string
+		engineEdition int
+		query         string
+	}{
+		{"Azure SQL Database", database.AzureSQLDatabaseEngineEditionNumber, `FROM sys\.dm_db_resource_stats`},
+		{"Azure SQL Managed Instance", database.AzureSQLManagedInstanceEngineEditionNumber, `FROM master\.sys\.server_resource_stats`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			i, e := createTestEntity(t)
+			conn, mock := connection.CreateMockSQL(t)
+
+			mock.ExpectQuery(tc.query).WillReturnRows(sqlmock.NewRows([]string{"record_id", "sql_process", "record_time"}).
+				AddRow(1791540000, 42.5, "2026-10-16T10:00:00"))
+
+			PopulateCPUMetrics(context.Background(), e, conn, state.NewInMemoryStore(), tc.engineEdition)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			samples := samplesOfType(i, "MssqlCpuSample")
+			require.Len(t, samples, 1)
+			assert.Equal(t, 42.5, samples[0]["cpu.sqlProcessPercent"])
+			assert.NotContains(t, samples[0], "cpu.systemIdlePercent")
+		})
+	}
+}
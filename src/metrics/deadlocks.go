@@ -0,0 +1,377 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// deadlockRingBufferQuery reads the deadlock reports kept in memory by the system_health session.
+// The ring buffer is cheap to read but only holds the latest events.
+const deadlockRingBufferQuery = `SELECT
+		CONVERT(VARCHAR(23), xed.value('@timestamp', 'datetime2(3)'), 126) AS event_time,
+		CAST(xed.query('(data[@name="xml_report"]/value/deadlock)[1]') AS NVARCHAR(MAX)) AS deadlock_graph
+		FROM (
+			SELECT CAST(st.target_data AS XML) AS target_data
+			FROM sys.dm_xe_session_targets st
+			INNER JOIN sys.dm_xe_sessions s ON s.address = st.event_session_address
+			WHERE s.name = N'system_health'
+			AND st.target_name = N'ring_buffer'
+		) AS rb
+		CROSS APPLY rb.target_data.nodes('RingBufferTarget/event[@name="xml_deadlock_report"]') AS events(xed)`
+
+// deadlockEventFileQuery reads the deadlock reports written by the system_health session to its
+// event files, which keep a longer history than the ring buffer. It takes the files to read and
+// the file and offset where the previous run stopped, NULL for both to read the files whole.
+const deadlockEventFileQuery = `SELECT
+		CONVERT(VARCHAR(23), xed.value('(event/@timestamp)[1]', 'datetime2(3)'), 126) AS event_time,
+		CAST(xed.query('(event/data[@name="xml_report"]/value/deadlock)[1]') AS NVARCHAR(MAX)) AS deadlock_graph,
+		ef.file_name,
+		ef.file_offset
+		FROM (
+			SELECT CAST(event_data AS XML) AS xed, file_name, file_offset
+			FROM sys.fn_xe_file_target_read_file(%s, NULL, %s, %s)
+			WHERE object_name = N'xml_deadlock_report'
+		) AS ef`
+
+// deadlockCurrentFileDeclaration declares @current_file, the event file system_health is writing
+// to, so that a run with no position to resume from does not read the whole history
+const deadlockCurrentFileDeclaration = `DECLARE @current_file NVARCHAR(260) = (
+		SELECT CAST(st.target_data AS XML).value('(EventFileTarget/File/@name)[1]', 'NVARCHAR(260)')
+		FROM sys.dm_xe_session_targets st
+		INNER JOIN sys.dm_xe_sessions s ON s.address = st.event_session_address
+		WHERE s.name = N'system_health'
+		AND st.target_name = N'event_file');
+`
+
+var deadlockQueries = map[string]string{
+	args.DeadlockTargetRingBuffer: deadlockRingBufferQuery,
+	args.DeadlockTargetEventFile:  deadlockEventFileQuery,
+}
+
+// deadlockRowModel is a row result of the deadlock queries
+type deadlockRowModel struct {
+	EventTime     string  `db:"event_time"`
+	DeadlockGraph string  `db:"deadlock_graph"`
+	FileName      *string `db:"file_name"`
+	FileOffset    *int64  `db:"file_offset"`
+}
+
+// deadlockGraph is the xml_report of an xml_deadlock_report event
+type deadlockGraph struct {
+	Victims   []deadlockVictim  `xml:"victim-list>victimProcess"`
+	Processes []deadlockProcess `xml:"process-list>process"`
+	Resources struct {
+		Resources []deadlockResource `xml:",any"`
+	} `xml:"resource-list"`
+}
+
+type deadlockVictim struct {
+	ID string `xml:"id,attr"`
+}
+
+type deadlockProcess struct {
+	ID             string          `xml:"id,attr"`
+	SPID           string          `xml:"spid,attr"`
+	Database       string          `xml:"currentdbname,attr"`
+	Login          string          `xml:"loginname,attr"`
+	HostName       string          `xml:"hostname,attr"`
+	Application    string          `xml:"clientapp,attr"`
+	LockMode       string          `xml:"lockMode,attr"`
+	WaitResource   string          `xml:"waitresource,attr"`
+	WaitTime       int64           `xml:"waittime,attr"`
+	IsolationLevel string          `xml:"isolationlevel,attr"`
+	Transaction    string          `xml:"transactionname,attr"`
+	Frames         []deadlockFrame `xml:"executionStack>frame"`
+	InputBuffer    string          `xml:"inputbuf"`
+}
+
+type deadlockFrame struct {
+	Text string `xml:",chardata"`
+}
+
+// deadlockResource is any element of the resource list, such as keylock, pagelock or exchangeEvent
+type deadlockResource struct {
+	XMLName    xml.Name
+	Database   string              `xml:"dbid,attr"`
+	ObjectName string              `xml:"objectname,attr"`
+	IndexName  string              `xml:"indexname,attr"`
+	Mode       string              `xml:"mode,attr"`
+	Owners     []deadlockLockOwner `xml:"owner-list>owner"`
+	Waiters    []deadlockLockOwner `xml:"waiter-list>waiter"`
+}
+
+type deadlockLockOwner struct {
+	Process string `xml:"id,attr" json:"process"`
+	Mode    string `xml:"mode,attr" json:"mode,omitempty"`
+}
+
+// deadlockLiteral matches the quoted strings, hexadecimal and decimal numbers of a statement, and
+// the identifiers and bracketed names that are kept, so that digits in a name such as Table1 are
+// not taken for a number
+var deadlockLiteral = regexp.MustCompile(`'[^']*'|".*?"|\[[^\]]*\]|[\pL_@#][\pL\d_@#$]*|0[xX][0-9A-Fa-f]*|\d*\.?\d+(?:[eE][+-]?\d+)?`)
+
+// anonymizeStatement replaces the literal values of a statement with ?
+func anonymizeStatement(text string) string {
+	return deadlockLiteral.ReplaceAllStringFunc(text, func(match string) string {
+		switch r, _ := utf8.DecodeRuneInString(match); {
+		case r == '\'' || r == '"' || unicode.IsDigit(r) || r == '.':
+			return "?"
+		default:
+			return match
+		}
+	})
+}
+
+// statement returns the anonymized text of the statement the process was running when the
+// deadlock happened, or of its input buffer when the execution stack has no text
+func (p deadlockProcess) statement() string {
+	text := p.InputBuffer
+	for _, frame := range p.Frames {
+		if strings.TrimSpace(frame.Text) != "" {
+			text = frame.Text
+			break
+		}
+	}
+	return anonymizeStatement(strings.Join(strings.Fields(text), " "))
+}
+
+// deadlockProcessAttribute and deadlockResourceAttribute are the JSON documents describing the
+// processes and resources of a deadlock in MSSQLDeadlockEvent
+type deadlockProcessAttribute struct {
+	ID             string `json:"id"`
+	SPID           string `json:"spid,omitempty"`
+	Victim         bool   `json:"victim"`
+	Database       string `json:"database,omitempty"`
+	Login          string `json:"login,omitempty"`
+	HostName       string `json:"hostName,omitempty"`
+	Application    string `json:"application,omitempty"`
+	LockMode       string `json:"lockMode,omitempty"`
+	WaitResource   string `json:"waitResource,omitempty"`
+	WaitTime       int64  `json:"waitTimeInMilliseconds"`
+	IsolationLevel string `json:"isolationLevel,omitempty"`
+	Transaction    string `json:"transactionName,omitempty"`
+	Statement      string `json:"statement,omitempty"`
+}
+
+type deadlockResourceAttribute struct {
+	Type       string              `json:"type"`
+	Database   string              `json:"databaseId,omitempty"`
+	ObjectName string              `json:"objectName,omitempty"`
+	IndexName  string              `json:"indexName,omitempty"`
+	Mode       string              `json:"mode,omitempty"`
+	Owners     []deadlockLockOwner `json:"owners,omitempty"`
+	Waiters    []deadlockLockOwner `json:"waiters,omitempty"`
+}
+
+// deadlockModel holds the MSSQLDeadlockEvent of a deadlock. The victim attributes describe the
+// first victim, the processes and resources attributes describe the whole graph.
+type deadlockModel struct {
+	EventTime       string `metric_name:"deadlock.eventTime" source_type:"attribute"`
+	VictimCount     int    `metric_name:"deadlock.victimCount" source_type:"gauge"`
+	ProcessCount    int    `metric_name:"deadlock.processCount" source_type:"gauge"`
+	ResourceCount   int    `metric_name:"deadlock.resourceCount" source_type:"gauge"`
+	VictimSPID      string `metric_name:"deadlock.victimSpid" source_type:"attribute"`
+	VictimDatabase  string `metric_name:"deadlock.victimDatabase" source_type:"attribute"`
+	VictimLockMode  string `metric_name:"deadlock.victimLockMode" source_type:"attribute"`
+	VictimStatement string `metric_name:"deadlock.victimStatement" source_type:"attribute"`
+	Processes       string `metric_name:"deadlock.processes" source_type:"attribute"`
+	Resources       string `metric_name:"deadlock.resources" source_type:"attribute"`
+}
+
+// parseDeadlockGraph builds the MSSQLDeadlockEvent of a deadlock graph
+func parseDeadlockGraph(eventTime time.Time, graph string) (deadlockModel, error) {
+	var g deadlockGraph
+	if err := xml.Unmarshal([]byte(graph), &g); err != nil {
+		return deadlockModel{}, fmt.Errorf("failed to parse deadlock graph: %w", err)
+	}
+
+	victims := make(map[string]bool, len(g.Victims))
+	for _, victim := range g.Victims {
+		victims[victim.ID] = true
+	}
+
+	model := deadlockModel{
+		EventTime:     eventTime.Format(time.RFC3339Nano),
+		VictimCount:   len(g.Victims),
+		ProcessCount:  len(g.Processes),
+		ResourceCount: len(g.Resources.Resources),
+	}
+
+	processes := make([]deadlockProcessAttribute, 0, len(g.Processes))
+	for _, p := range g.Processes {
+		process := deadlockProcessAttribute{
+			ID:             p.ID,
+			SPID:           p.SPID,
+			Victim:         victims[p.ID],
+			Database:       p.Database,
+			Login:          p.Login,
+			HostName:       p.HostName,
+			Application:    p.Application,
+			LockMode:       p.LockMode,
+			WaitResource:   p.WaitResource,
+			WaitTime:       p.WaitTime,
+			IsolationLevel: p.IsolationLevel,
+			Transaction:    p.Transaction,
+			Statement:      p.statement(),
+		}
+		if process.Victim && model.VictimSPID == "" {
+			model.VictimSPID = process.SPID
+			model.VictimDatabase = process.Database
+			model.VictimLockMode = process.LockMode
+			model.VictimStatement = process.Statement
+		}
+		processes = append(processes, process)
+	}
+
+	resources := make([]deadlockResourceAttribute, 0, len(g.Resources.Resources))
+	for _, r := range g.Resources.Resources {
+		resources = append(resources, deadlockResourceAttribute{
+			Type:       r.XMLName.Local,
+			Database:   r.Database,
+			ObjectName: r.ObjectName,
+			IndexName:  r.IndexName,
+			Mode:       r.Mode,
+			Owners:     r.Owners,
+			Waiters:    r.Waiters,
+		})
+	}
+
+	b, err := json.Marshal(processes)
+	if err != nil {
+		return deadlockModel{}, err
+	}
+	model.Processes = string(b)
+	if b, err = json.Marshal(resources); err != nil {
+		return deadlockModel{}, err
+	}
+	model.Resources = string(b)
+	return model, nil
+}
+
+// PopulateDeadlockMetrics reports a MSSQLDeadlockEvent for each deadlock recorded by the
+// system_health session since the previous run. The first run only reports the latest deadlock.
+// Azure SQL Database has no system_health session and is skipped.
+func PopulateDeadlockMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, target string, store *state.Store, engineEdition int) {
+	if engineEdition == database.AzureSQLDatabaseEngineEditionNumber {
+		log.Debug("Skipping deadlocks, Azure SQL Database has no system_health session")
+		return
+	}
+	if _, ok := deadlockQueries[target]; !ok {
+		log.Error("Unknown deadlock target '%s'", target)
+		return
+	}
+
+	key := fmt.Sprintf("deadlocks-%s-%s", connection.Host, instanceEntity.Metadata.Name)
+	rows, err := queryDeadlocks(ctx, connection, target, store, key+"-file")
+	if err != nil {
+		log.Error("Could not execute deadlock query: %s", err.Error())
+		return
+	}
+
+	type deadlock struct {
+		time  time.Time
+		graph string
+	}
+	deadlocks := make([]deadlock, 0, len(rows))
+	for _, row := range rows {
+		eventTime, err := time.Parse(sqlTimeLayout, row.EventTime)
+		if err != nil {
+			log.Debug("Skipping deadlock with invalid time '%s': %s", row.EventTime, err)
+			continue
+		}
+		deadlocks = append(deadlocks, deadlock{eventTime, row.DeadlockGraph})
+	}
+	// Events are read in the order they were recorded, but their times only have millisecond precision
+	sort.SliceStable(deadlocks, func(a, b int) bool { return deadlocks[a].time.Before(deadlocks[b].time) })
+
+	// Several deadlocks can share a millisecond, so the mark also counts those seen at it, like the error log does
+	mark, hasMark := store.HighWaterMark(key)
+	seenAtMark, _ := store.HighWaterMark(key + "-count")
+
+	var newest, atNewest int64
+	reported := make([]deadlock, 0, len(deadlocks))
+	for _, d := range deadlocks {
+		t := d.time.UnixMilli()
+		if t == newest {
+			atNewest++
+		} else {
+			newest, atNewest = t, 1
+		}
+		if hasMark && (t < mark || (t == mark && atNewest <= seenAtMark)) {
+			continue
+		}
+		reported = append(reported, d)
+	}
+	if len(reported) == 0 {
+		return
+	}
+	store.SetHighWaterMark(key, newest)
+	store.SetHighWaterMark(key+"-count", atNewest)
+
+	for _, d := range reported {
+		if !hasMark && d.time.UnixMilli() != newest {
+			continue
+		}
+		model, err := parseDeadlockGraph(d.time, d.graph)
+		if err != nil {
+			log.Error("Could not report deadlock of %s: %s", d.time.Format(time.RFC3339), err)
+			continue
+		}
+		MarshalEntitySample(instanceEntity, connection.Host, "MSSQLDeadlockEvent", model)
+	}
+}
+
+// queryDeadlocks runs the query of target. The event files are read from the position stored
+// under positionKey, which is then moved past the events returned. Without a position, or when
+// the file it points to has been rolled over and removed, only the current file is read.
+func queryDeadlocks(ctx context.Context, connection *connection.SQLConnection, target string, store *state.Store, positionKey string) ([]deadlockRowModel, error) {
+	rows := make([]deadlockRowModel, 0)
+	if target != args.DeadlockTargetEventFile {
+		return rows, connection.QueryContext(ctx, &rows, deadlockQueries[target])
+	}
+
+	file, offset, ok := store.FilePosition(positionKey)
+	if ok {
+		resumed := fmt.Sprintf(deadlockEventFileQuery, "'system_health*.xel'", "N'"+strings.ReplaceAll(file, "'", "''")+"'", strconv.FormatInt(offset, 10))
+		err := connection.QueryContext(ctx, &rows, resumed)
+		if err == nil {
+			storeLastFilePosition(store, positionKey, rows)
+			return rows, nil
+		}
+		log.Debug("Could not resume reading deadlocks at %s offset %d, reading the current file: %s", file, offset, err)
+		rows = rows[:0]
+	}
+
+	current := deadlockCurrentFileDeclaration + fmt.Sprintf(deadlockEventFileQuery, "@current_file", "NULL", "NULL")
+	if err := connection.QueryContext(ctx, &rows, current); err != nil {
+		return nil, err
+	}
+	storeLastFilePosition(store, positionKey, rows)
+	return rows, nil
+}
+
+// storeLastFilePosition records the file and offset of the last event in rows, if any
+func storeLastFilePosition(store *state.Store, positionKey string, rows []deadlockRowModel) {
+	for i := len(rows) - 1; i >= 0; i-- {
+		if rows[i].FileName != nil && rows[i].FileOffset != nil {
+			store.SetFilePosition(positionKey, *rows[i].FileName, *rows[i].FileOffset)
+			return
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const testDeadlockGraph = `<deadlock>
+ <victim-list>
+  <victimProcess id="process2"/>
+ </victim-list>
+ <process-list>
+  <process id="process1" waitresource="KEY: 5:72057594043236352 (8194443284a0)" waittime="3012" transactionname="user_transaction" lockMode="U" spid="55" clientapp="app" hostname="web01" loginname="shop" isolationlevel="read committed (2)" currentdbname="shop">
+   <executionStack>
+    <frame procname="adhoc" line="1">
+UPDATE orders SET status = 'shipped' WHERE id = 42    </frame>
+   </executionStack>
+   <inputbuf>
+BEGIN TRAN UPDATE orders SET status = 'shipped' WHERE id = 42   </inputbuf>
+  </process>
+  <process id="process2" waitresource="KEY: 5:72057594043301888 (61a06abd401c)" waittime="2950" transactionname="user_transaction" lockMode="X" spid="61" clientapp="app" hostname="web02" loginname="shop" isolationlevel="read committed (2)" currentdbname="shop">
+   <executionStack>
+    <frame procname="unknown" line="1"></frame>
+   </executionStack>
+   <inputbuf>
+UPDATE customers SET credit = 10.5 WHERE name = 'Ann'   </inputbuf>
+  </process>
+ </process-list>
+ <resource-list>
+  <keylock hobtid="72057594043236352" dbid="5" objectname="shop.dbo.orders" indexname="PK_orders" mode="X">
+   <owner-list>
+    <owner id="process2" mode="X"/>
+   </owner-list>
+   <waiter-list>
+    <waiter id="process1" mode="U" requestType="wait"/>
+   </waiter-list>
+  </keylock>
+  <keylock hobtid="72057594043301888" dbid="5" objectname="shop.dbo.customers" indexname="PK_customers" mode="X">
+   <owner-list>
+    <owner id="process1" mode="X"/>
+   </owner-list>
+   <waiter-list>
+    <waiter id="process2" mode="X" requestType="wait"/>
+   </waiter-list>
+  </keylock>
+ </resource-list>
+</deadlock>`
+
+func Test_parseDeadlockGraph(t *testing.T) {
+	model, err := parseDeadlockGraph(time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC), testDeadlockGraph)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2026-10-16T10:00:00Z", model.EventTime)
+	assert.Equal(t, 1, model.VictimCount)
+	assert.Equal(t, 2, model.ProcessCount)
+	assert.Equal(t, 2, model.ResourceCount)
+	assert.Equal(t, "61", model.VictimSPID)
+	assert.Equal(t, "shop", model.VictimDatabase)
+	assert.Equal(t, "X", model.VictimLockMode)
+	assert.Equal(t, "UPDATE customers SET credit = ? WHERE name = ?", model.VictimStatement, "the input buffer is used when the frame has no text")
+
+	var processes []deadlockProcessAttribute
+	require.NoError(t, json.Unmarshal([]byte(model.Processes), &processes))
+	require.Len(t, processes, 2)
+	assert.False(t, processes[0].Victim)
+	assert.Equal(t, "UPDATE orders SET status = ? WHERE id = ?", processes[0].Statement)
+	assert.Equal(t, int64(3012), processes[0].WaitTime)
+	assert.Equal(t, "web01", processes[0].HostName)
+
+	var resources []deadlockResourceAttribute
+	require.NoError(t, json.Unmarshal([]byte(model.Resources), &resources))
+	require.Len(t, resources, 2)
+	assert.Equal(t, "keylock", resources[0].Type)
+	assert.Equal(t, "shop.dbo.orders", resources[0].ObjectName)
+	assert.Equal(t, []deadlockLockOwner{{Process: "process2", Mode: "X"}}, resources[0].Owners)
+	assert.Equal(t, []deadlockLockOwner{{Process: "process1", Mode: "U"}}, resources[0].Waiters)
+
+	_, err = parseDeadlockGraph(time.Now(), "<deadlock>")
+	assert.Error(t, err)
+}
+
+func Test_anonymizeStatement(t *testing.T) {
+	testCases := map[string]string{
+		"UPDATE Table1 SET qty = 15 WHERE id = 42.5":           "UPDATE Table1 SET qty = ? WHERE id = ?",
+		`SELECT * FROM t2 WHERE name = N'Smith' AND c = "x"`:   `SELECT * FROM t2 WHERE name = N? AND c = ?`,
+		"EXEC usp_Order_2 @id2=7, @rate=.5":                    "EXEC usp_Order_2 @id2=?, @rate=?",
+		"SELECT * FROM [Order Lines 2024] WHERE hash = 0x1F2A": "SELECT * FROM [Order Lines 2024] WHERE hash = ?",
+		"SELECT 1e10, 2.5E-3 FROM #tmp1":                       "SELECT ?, ? FROM #tmp1",
+		"":                                                     "",
+	}
+
+	for statement, expected := range testCases {
+		assert.Equal(t, expected, anonymizeStatement(statement), statement)
+	}
+}
+
+func Test_PopulateDeadlockMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+	columns := []string{"event_time", "deadlock_graph"}
+
+	query := `FROM sys\.dm_xe_session_targets st`
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("2026-10-16T10:00:00", testDeadlockGraph).
+		AddRow("2026-10-16T10:05:00.250", testDeadlockGraph))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("2026-10-16T10:00:00", testDeadlockGraph).
+		AddRow("2026-10-16T10:05:00.250", testDeadlockGraph).
+		AddRow("2026-10-16T10:07:00.5", testDeadlockGraph).
+		AddRow("2026-10-16T10:09:00.001", testDeadlockGraph))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("2026-10-16T10:09:00.001", testDeadlockGraph))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow("2026-10-16T10:09:00.001", testDeadlockGraph).
+		AddRow("2026-10-16T10:09:00.001", testDeadlockGraph))
+
+	PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetRingBuffer, store, 3)
+	samples := samplesOfType(i, "MSSQLDeadlockEvent")
+	require.Len(t, samples, 1, "the first run only reports the latest deadlock")
+	assert.Equal(t, "2026-10-16T10:05:00.25Z", samples[0]["deadlock.eventTime"])
+	assert.Equal(t, "61", samples[0]["deadlock.victimSpid"])
+	assert.Equal(t, float64(2), samples[0]["deadlock.processCount"])
+
+	PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetRingBuffer, store, 3)
+	samples = samplesOfType(i, "MSSQLDeadlockEvent")
+	require.Len(t, samples, 3)
+	assert.Equal(t, "2026-10-16T10:07:00.5Z", samples[1]["deadlock.eventTime"])
+	assert.Equal(t, "2026-10-16T10:09:00.001Z", samples[2]["deadlock.eventTime"])
+
+	PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetRingBuffer, store, 3)
+	assert.Len(t, samplesOfType(i, "MSSQLDeadlockEvent"), 3, "deadlocks already reported are skipped")
+
+	PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetRingBuffer, store, 3)
+	samples = samplesOfType(i, "MSSQLDeadlockEvent")
+	require.Len(t, samples, 4, "a deadlock in the same millisecond as the last one reported is new")
+	assert.Equal(t, "2026-10-16T10:09:00.001Z", samples[3]["deadlock.eventTime"])
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_PopulateDeadlockMetrics_Targets(t *testing.T) {
+	t.Run("event file", func(t *testing.T) {
+		i, e := createTestEntity(t)
+		conn, mock := connection.CreateMockSQL(t)
+		store := state.NewInMemoryStore()
+		columns := []string{"event_time", "deadlock_graph", "file_name", "file_offset"}
+
+		mock.ExpectQuery(`(?s)DECLARE @current_file .*FROM sys\.fn_xe_file_target_read_file\(@current_file, NULL, NULL, NULL\)`).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("2026-10-16T10:00:00", testDeadlockGraph, `C:\Log\system_health_0_1.xel`, 512))
+		mock.ExpectQuery(`FROM sys\.fn_xe_file_target_read_file\('system_health\*\.xel', NULL, N'C:\\Log\\system_health_0_1\.xel', 512\)`).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("2026-10-16T10:01:00", testDeadlockGraph, `C:\Log\system_health_0_2.xel`, 1024))
+		mock.ExpectQuery(`NULL, N'C:\\Log\\system_health_0_2\.xel', 1024\)`).
+			WillReturnError(errors.New("the file was removed"))
+		mock.ExpectQuery(`read_file\(@current_file, NULL, NULL, NULL\)`).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("2026-10-16T10:01:00", testDeadlockGraph, `C:\Log\system_health_0_3.xel`, 512))
+
+		for range 3 {
+			PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetEventFile, store, 3)
+		}
+
+		require.NoError(t, mock.ExpectationsWereMet())
+		assert.Len(t, samplesOfType(i, "MSSQLDeadlockEvent"), 2, "reading the current file again does not report deadlocks twice")
+		file, offset, ok := store.FilePosition("deadlocks-testhost-" + e.Metadata.Name + "-file")
+		require.True(t, ok)
+		assert.Equal(t, `C:\Log\system_health_0_3.xel`, file)
+		assert.Equal(t, int64(512), offset)
+	})
+
+	t.Run("Azure SQL Database", func(t *testing.T) {
+		i, e := createTestEntity(t)
+		conn, mock := connection.CreateMockSQL(t)
+
+		PopulateDeadlockMetrics(context.Background(), e, conn, args.DeadlockTargetRingBuffer, state.NewInMemoryStore(), database.AzureSQLDatabaseEngineEditionNumber)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+		assert.Empty(t, samplesOfType(i, "MSSQLDeadlockEvent"))
+	})
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// errorLogQuery reads the lines of the current error log written since @start. Amazon RDS does not
+// grant xp_readerrorlog and provides rds_read_error_log instead, which cannot filter by time.
+// @read_from is a second earlier than @start in case xp_readerrorlog leaves @start out.
+const errorLogQuery = `SET NOCOUNT ON;
+		DECLARE @start DATETIME = %s;
+		DECLARE @read_from DATETIME = DATEADD(SECOND, -1, @start);
+		DECLARE @errorlog TABLE (id INT IDENTITY PRIMARY KEY, log_date DATETIME, process_info NVARCHAR(100), text NVARCHAR(MAX));
+		IF DB_ID('rdsadmin') IS NOT NULL
+			INSERT INTO @errorlog (log_date, process_info, text) EXEC rdsadmin.dbo.rds_read_error_log @index = 0, @type = 1;
+		ELSE
+			INSERT INTO @errorlog (log_date, process_info, text) EXEC master.dbo.xp_readerrorlog 0, 1, NULL, NULL, @read_from, NULL, N'asc';
+		SELECT CONVERT(VARCHAR(23), log_date, 126) AS log_date, process_info, text
+			FROM @errorlog
+			WHERE log_date >= @start
+			ORDER BY id`
+
+// errorLogFirstStart is where the first run starts reading the error log
+const errorLogFirstStart = "DATEADD(HOUR, -1, GETDATE())"
+
+var (
+	// errorLogHeader matches the line logged before the message of an error
+	errorLogHeader = regexp.MustCompile(`^Error: (\d+), Severity: (\d+), State: (\d+)\.`)
+	// errorLogStackDump matches the lines logged when SQL Server writes a memory dump
+	errorLogStackDump = regexp.MustCompile(`(?i)stack dump|SqlDumpExceptionHandler|BugCheck Dump`)
+	// errorLogLoginFailure matches login failures when they are logged without their error header
+	errorLogLoginFailure = regexp.MustCompile(`^Login failed for user`)
+)
+
+// Errors reported whatever their severity
+const (
+	errorLogLoginFailed = 18456
+	errorLogIOError     = 823
+	errorLogChecksum    = 824
+	errorLogReadRetry   = 825
+)
+
+// errorLogLineModel is a row result of errorLogQuery. log_date is in the time zone of the server.
+type errorLogLineModel struct {
+	LogDate     string `db:"log_date"`
+	ProcessInfo string `db:"process_info"`
+	Text        string `db:"text"`
+}
+
+// errorLogModel is a MSSQLErrorLogEvent. Errors carry their number, severity and state, other
+// lines such as stack dumps only their text.
+type errorLogModel struct {
+	LogDate     string `metric_name:"errorLog.logDate" source_type:"attribute"`
+	ProcessInfo string `metric_name:"errorLog.processInfo" source_type:"attribute"`
+	Category    string `metric_name:"errorLog.category" source_type:"attribute"`
+	ErrorNumber *int64 `metric_name:"errorLog.errorNumber" source_type:"gauge"`
+	Severity    *int64 `metric_name:"errorLog.severity" source_type:"gauge"`
+	State       *int64 `metric_name:"errorLog.state" source_type:"gauge"`
+	Message     string `metric_name:"errorLog.message" source_type:"attribute"`
+	header      string
+}
+
+// text is the header and message of the entry, which include and exclude patterns are matched against
+func (m errorLogModel) text() string {
+	if m.header == "" {
+		return m.Message
+	}
+	return m.header + " " + m.Message
+}
+
+// errorLogFilter selects the entries reported in MSSQLErrorLogEvent
+type errorLogFilter struct {
+	minSeverity int64
+	include     *regexp.Regexp
+	exclude     *regexp.Regexp
+}
+
+func newErrorLogFilter(arguments args.ArgumentList) (errorLogFilter, error) {
+	f := errorLogFilter{minSeverity: int64(arguments.ErrorLogMinSeverity)}
+	var err error
+	if arguments.ErrorLogIncludePattern != "" {
+		if f.include, err = regexp.Compile(arguments.ErrorLogIncludePattern); err != nil {
+			return f, fmt.Errorf("invalid error_log_include_pattern: %w", err)
+		}
+	}
+	if arguments.ErrorLogExcludePattern != "" {
+		if f.exclude, err = regexp.Compile(arguments.ErrorLogExcludePattern); err != nil {
+			return f, fmt.Errorf("invalid error_log_exclude_pattern: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// category returns why entry is reported, or an empty string when it is not
+func (f errorLogFilter) category(entry errorLogModel) string {
+	if f.exclude != nil && f.exclude.MatchString(entry.text()) {
+		return ""
+	}
+	if entry.ErrorNumber != nil {
+		switch *entry.ErrorNumber {
+		case errorLogIOError, errorLogChecksum, errorLogReadRetry:
+			return "io_error"
+		case errorLogLoginFailed:
+			return "login_failure"
+		}
+	}
+	switch {
+	case errorLogStackDump.MatchString(entry.Message):
+		return "stack_dump"
+	case entry.ErrorNumber == nil && errorLogLoginFailure.MatchString(entry.Message):
+		return "login_failure"
+	case entry.Severity != nil && *entry.Severity >= f.minSeverity:
+		return "high_severity"
+	case f.include != nil && f.include.MatchString(entry.text()):
+		return "included"
+	}
+	return ""
+}
+
+// parseErrorLog joins each error header with the message logged after it by the same process
+func parseErrorLog(lines []errorLogLineModel) []errorLogModel {
+	entries := make([]errorLogModel, 0, len(lines))
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		entry := errorLogModel{LogDate: line.LogDate, ProcessInfo: line.ProcessInfo, Message: line.Text}
+
+		if m := errorLogHeader.FindStringSubmatch(line.Text); m != nil {
+			entry.header = line.Text
+			entry.Message = ""
+			entry.ErrorNumber, entry.Severity, entry.State = parseErrorLogInt(m[1]), parseErrorLogInt(m[2]), parseErrorLogInt(m[3])
+			if idx+1 < len(lines) && lines[idx+1].ProcessInfo == line.ProcessInfo && !errorLogHeader.MatchString(lines[idx+1].Text) {
+				idx++
+				entry.Message = lines[idx].Text
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func parseErrorLogInt(s string) *int64 {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// PopulateErrorLogMetrics reports in a MSSQLErrorLogEvent the high severity errors, I/O errors,
+// stack dumps and login failures written to the error log since the previous run, along with the
+// lines matching error_log_include_pattern. The first run reads the last hour of the error log.
+// Azure SQL Database has no error log and is skipped.
+func PopulateErrorLogMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList, store *state.Store, engineEdition int) {
+	if engineEdition == database.AzureSQLDatabaseEngineEditionNumber {
+		log.Debug("Skipping error log, Azure SQL Database has no error log")
+		return
+	}
+	filter, err := newErrorLogFilter(arguments)
+	if err != nil {
+		log.Error("Could not read error log: %s", err)
+		return
+	}
+
+	// The mark is the time of the last line read, kept along with the number of lines read at that
+	// time since more lines can be written at the same time after the run
+	key := fmt.Sprintf("errorlog-%s-%s", connection.Host, instanceEntity.Metadata.Name)
+	mark, hasMark := store.HighWaterMark(key)
+	seenAtMark, _ := store.HighWaterMark(key + "-lines")
+
+	start := errorLogFirstStart
+	if hasMark {
+		start = "'" + time.UnixMilli(mark).UTC().Format(sqlTimeLayout) + "'"
+	}
+	lines := make([]errorLogLineModel, 0)
+	if err := connection.QueryContext(ctx, &lines, fmt.Sprintf(errorLogQuery, start)); err != nil {
+		log.Error("Could not execute error log query: %s", err.Error())
+		return
+	}
+
+	// position is the mark to store once a line has been read
+	type position struct{ time, lines int64 }
+	newLines := make([]errorLogLineModel, 0, len(lines))
+	positions := make([]position, 0, len(lines))
+	var last, atLast int64
+	for _, line := range lines {
+		logDate, err := time.Parse(sqlTimeLayout, line.LogDate)
+		if err != nil {
+			log.Debug("Skipping error log line with invalid time '%s': %s", line.LogDate, err)
+			continue
+		}
+		t := logDate.UnixMilli()
+		if t != last {
+			last, atLast = t, 0
+		}
+		atLast++
+		if hasMark && (t < mark || (t == mark && atLast <= seenAtMark)) {
+			continue
+		}
+		newLines = append(newLines, line)
+		positions = append(positions, position{t, atLast})
+	}
+	// An error header is written just before its message. When it is the last line, it is left for
+	// the next run so that it is not reported without the message.
+	if n := len(newLines); n > 0 && errorLogHeader.MatchString(newLines[n-1].Text) {
+		newLines, positions = newLines[:n-1], positions[:n-1]
+	}
+	if len(newLines) == 0 {
+		return
+	}
+	store.SetHighWaterMark(key, positions[len(positions)-1].time)
+	store.SetHighWaterMark(key+"-lines", positions[len(positions)-1].lines)
+
+	reported, dropped := 0, 0
+	for _, entry := range parseErrorLog(newLines) {
+		entry.Category = filter.category(entry)
+		if entry.Category == "" {
+			continue
+		}
+		if reported >= arguments.ErrorLogMaxEvents {
+			dropped++
+			continue
+		}
+		MarshalEntitySample(instanceEntity, connection.Host, "MSSQLErrorLogEvent", entry)
+		reported++
+	}
+	if dropped > 0 {
+		log.Warn("Dropped %d error log events over the limit of %d per run set by error_log_max_events", dropped, arguments.ErrorLogMaxEvents)
+	}
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var errorLogColumns = []string{"log_date", "process_info", "text"}
+
+func errorLogArguments() args.ArgumentList {
+	return args.ArgumentList{EnableErrorLogMetrics: true, ErrorLogMinSeverity: 17, ErrorLogMaxEvents: 100}
+}
+
+func Test_parseErrorLog(t *testing.T) {
+	entries := parseErrorLog([]errorLogLineModel{
+		{"2026-10-16T10:00:00", "Logon", "Error: 18456, Severity: 14, State: 8."},
+		{"2026-10-16T10:00:00", "Logon", "Login failed for user 'sa'. Reason: Password did not match that for the login provided. [CLIENT: 10.0.0.5]"},
+		{"2026-10-16T10:00:01", "spid51", "Error: 824, Severity: 24, State: 2."},
+		{"2026-10-16T10:00:02", "spid52", "Error: 50000, Severity: 16, State: 1."},
+		{"2026-10-16T10:00:02", "spid53", "Starting up database 'shop'."},
+	})
+
+	require.Len(t, entries, 4)
+	assert.Equal(t, int64(18456), *entries[0].ErrorNumber)
+	assert.Equal(t, int64(14), *entries[0].Severity)
+	assert.Equal(t, int64(8), *entries[0].State)
+	assert.Contains(t, entries[0].Message, "Login failed for user 'sa'")
+	assert.Equal(t, int64(824), *entries[1].ErrorNumber)
+	assert.Empty(t, entries[1].Message, "the message of a header is logged by the same process")
+	assert.Equal(t, int64(50000), *entries[2].ErrorNumber)
+	assert.Empty(t, entries[2].Message)
+	assert.Nil(t, entries[3].ErrorNumber)
+	assert.Equal(t, "Starting up database 'shop'.", entries[3].Message)
+}
+
+func Test_errorLogFilter(t *testing.T) {
+	entry := func(number, severity int64, message string) errorLogModel {
+		return errorLogModel{
+			ErrorNumber: &number,
+			Severity:    &severity,
+			header:      "Error: header",
+			Message:     message,
+		}
+	}
+
+	arguments := errorLogArguments()
+	arguments.ErrorLogIncludePattern = `^Recovery of database`
+	arguments.ErrorLogExcludePattern = `CLIENT: 10\.0\.0\.9`
+	filter, err := newErrorLogFilter(arguments)
+	require.NoError(t, err)
+
+	assert.Equal(t, "io_error", filter.category(entry(825, 10, "A read of the file succeeded after failing 1 time")))
+	assert.Equal(t, "login_failure", filter.category(entry(18456, 14, "Login failed for user 'sa'. [CLIENT: 10.0.0.5]")))
+	assert.Equal(t, "", filter.category(entry(18456, 14, "Login failed for user 'sa'. [CLIENT: 10.0.0.9]")))
+	assert.Equal(t, "high_severity", filter.category(entry(9002, 17, "The transaction log for database 'shop' is full")))
+	assert.Equal(t, "", filter.category(entry(50000, 16, "custom")))
+	assert.Equal(t, "stack_dump", filter.category(errorLogModel{Message: "***Stack Dump being sent to C:\\Log\\SQLDump0001.txt"}))
+	assert.Equal(t, "included", filter.category(errorLogModel{Message: "Recovery of database 'shop' (5) is 12% complete"}))
+	assert.Equal(t, "", filter.category(errorLogModel{Message: "Starting up database 'shop'."}))
+
+	_, err = newErrorLogFilter(args.ArgumentList{ErrorLogIncludePattern: "("})
+	assert.Error(t, err)
+}
+
+func Test_PopulateErrorLogMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	mock.ExpectQuery(`DECLARE @start DATETIME = DATEADD\(HOUR, -1, GETDATE\(\)\);`).
+		WillReturnRows(sqlmock.NewRows(errorLogColumns).
+			AddRow("2026-10-16T10:00:00", "spid51", "Error: 824, Severity: 24, State: 2.").
+			AddRow("2026-10-16T10:00:00", "spid51", "SQL Server detected a logical consistency-based I/O error: incorrect checksum.").
+			AddRow("2026-10-16T10:00:05.123", "spid9s", "Starting up database 'shop'."))
+	mock.ExpectQuery(`DECLARE @start DATETIME = '2026-10-16T10:00:05\.123';`).
+		WillReturnRows(sqlmock.NewRows(errorLogColumns).
+			AddRow("2026-10-16T10:00:05.123", "spid9s", "Starting up database 'shop'.").
+			AddRow("2026-10-16T10:00:05.123", "Logon", "Error: 18456, Severity: 14, State: 8.").
+			AddRow("2026-10-16T10:00:05.123", "Logon", "Login failed for user 'sa'. [CLIENT: 10.0.0.5]"))
+	mock.ExpectQuery(`DECLARE @start DATETIME = '2026-10-16T10:00:05\.123';`).
+		WillReturnRows(sqlmock.NewRows(errorLogColumns).
+			AddRow("2026-10-16T10:00:05.123", "spid9s", "Starting up database 'shop'.").
+			AddRow("2026-10-16T10:00:05.123", "Logon", "Error: 18456, Severity: 14, State: 8.").
+			AddRow("2026-10-16T10:00:05.123", "Logon", "Login failed for user 'sa'. [CLIENT: 10.0.0.5]"))
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), store, 3)
+	samples := samplesOfType(i, "MSSQLErrorLogEvent")
+	require.Len(t, samples, 1)
+	assert.Equal(t, "io_error", samples[0]["errorLog.category"])
+	assert.Equal(t, float64(824), samples[0]["errorLog.errorNumber"])
+	assert.Equal(t, float64(24), samples[0]["errorLog.severity"])
+	assert.Equal(t, "spid51", samples[0]["errorLog.processInfo"])
+	assert.Equal(t, "2026-10-16T10:00:00", samples[0]["errorLog.logDate"])
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), store, 3)
+	samples = samplesOfType(i, "MSSQLErrorLogEvent")
+	require.Len(t, samples, 2, "lines logged at the time of the last line read are not read again")
+	assert.Equal(t, "login_failure", samples[1]["errorLog.category"])
+	assert.Equal(t, "Login failed for user 'sa'. [CLIENT: 10.0.0.5]", samples[1]["errorLog.message"])
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), store, 3)
+	assert.Len(t, samplesOfType(i, "MSSQLErrorLogEvent"), 2, "lines already read are skipped")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_PopulateErrorLogMetrics_HeaderHeldBack(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	mock.ExpectQuery(`DECLARE @start DATETIME = DATEADD`).
+		WillReturnRows(sqlmock.NewRows(errorLogColumns).
+			AddRow("2026-10-16T10:00:00", "spid9s", "Starting up database 'shop'.").
+			AddRow("2026-10-16T10:00:01", "spid51", "Error: 823, Severity: 24, State: 2."))
+	mock.ExpectQuery(`DECLARE @start DATETIME = '2026-10-16T10:00:00';`).
+		WillReturnRows(sqlmock.NewRows(errorLogColumns).
+			AddRow("2026-10-16T10:00:00", "spid9s", "Starting up database 'shop'.").
+			AddRow("2026-10-16T10:00:01", "spid51", "Error: 823, Severity: 24, State: 2.").
+			AddRow("2026-10-16T10:00:01", "spid51", "The operating system returned error 21 to SQL Server during a read."))
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), store, 3)
+	assert.Empty(t, samplesOfType(i, "MSSQLErrorLogEvent"), "a header written last waits for its message")
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), store, 3)
+	samples := samplesOfType(i, "MSSQLErrorLogEvent")
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(823), samples[0]["errorLog.errorNumber"])
+	assert.Equal(t, float64(24), samples[0]["errorLog.severity"])
+	assert.Equal(t, "The operating system returned error 21 to SQL Server during a read.", samples[0]["errorLog.message"])
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_PopulateErrorLogMetrics_MaxEvents(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	rows := sqlmock.NewRows(errorLogColumns)
+	for range 5 {
+		rows.AddRow("2026-10-16T10:00:00", "spid51", "Error: 823, Severity: 24, State: 2.")
+	}
+	mock.ExpectQuery(`FROM @errorlog`).WillReturnRows(rows)
+
+	arguments := errorLogArguments()
+	arguments.ErrorLogMaxEvents = 3
+	PopulateErrorLogMetrics(context.Background(), e, conn, arguments, state.NewInMemoryStore(), 3)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, samplesOfType(i, "MSSQLErrorLogEvent"), 3)
+}
+
+func Test_PopulateErrorLogMetrics_AzureSQLDatabase(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	PopulateErrorLogMetrics(context.Background(), e, conn, errorLogArguments(), state.NewInMemoryStore(), database.AzureSQLDatabaseEngineEditionNumber)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, samplesOfType(i, "MSSQLErrorLogEvent"))
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// fileIOQuery reads the cumulative I/O counters of every file of the user databases
+const fileIOQuery = `SELECT
+		DB_NAME(vfs.database_id) AS db_name,
+		vfs.database_id,
+		vfs.file_id,
+		mf.name AS logical_file_name,
+		mf.type_desc AS file_type,
+		mf.physical_name,
+		vs.volume_mount_point AS volume,
+		vfs.num_of_reads,
+		vfs.num_of_bytes_read,
+		vfs.io_stall_read_ms,
+		vfs.num_of_writes,
+		vfs.num_of_bytes_written,
+		vfs.io_stall_write_ms
+		FROM sys.dm_io_virtual_file_stats(NULL, NULL) vfs
+		JOIN sys.master_files mf ON mf.database_id = vfs.database_id AND mf.file_id = vfs.file_id
+		CROSS APPLY sys.dm_os_volume_stats(vfs.database_id, vfs.file_id) vs
+		WHERE DB_NAME(vfs.database_id) NOT IN (` + database.ExcludedDatabases + `)`
+
+// fileIOQueryForAzureSQLManagedInstance leaves the volume out, the files of a managed instance
+// live in Azure storage rather than on volumes of the host
+const fileIOQueryForAzureSQLManagedInstance = `SELECT
+		DB_NAME(vfs.database_id) AS db_name,
+		vfs.database_id,
+		vfs.file_id,
+		mf.name AS logical_file_name,
+		mf.type_desc AS file_type,
+		mf.physical_name,
+		NULL AS volume,
+		vfs.num_of_reads,
+		vfs.num_of_bytes_read,
+		vfs.io_stall_read_ms,
+		vfs.num_of_writes,
+		vfs.num_of_bytes_written,
+		vfs.io_stall_write_ms
+		FROM sys.dm_io_virtual_file_stats(NULL, NULL) vfs
+		JOIN sys.master_files mf ON mf.database_id = vfs.database_id AND mf.file_id = vfs.file_id
+		WHERE DB_NAME(vfs.database_id) NOT IN (` + database.ExcludedDatabases + `)`
+
+// Azure SQL Database does not expose sys.master_files and the storage of its files is managed by the service
+var fileIOQuerySet = EngineSet[string]{
+	Default:                 fileIOQuery,
+	AzureSQLDatabase:        "",
+	AzureSQLManagedInstance: fileIOQueryForAzureSQLManagedInstance,
+}
+
+// fileIOModel is a row result of fileIOQuery
+type fileIOModel struct {
+	DBName            string  `db:"db_name"`
+	DatabaseID        int64   `db:"database_id"`
+	FileID            int64   `db:"file_id"`
+	LogicalFileName   string  `db:"logical_file_name"`
+	FileType          string  `db:"file_type"`
+	PhysicalName      string  `db:"physical_name"`
+	Volume            *string `db:"volume"`
+	NumOfReads        int64   `db:"num_of_reads"`
+	NumOfBytesRead    int64   `db:"num_of_bytes_read"`
+	IOStallReadMs     int64   `db:"io_stall_read_ms"`
+	NumOfWrites       int64   `db:"num_of_writes"`
+	NumOfBytesWritten int64   `db:"num_of_bytes_written"`
+	IOStallWriteMs    int64   `db:"io_stall_write_ms"`
+}
+
+func (m fileIOModel) counters() state.Counters {
+	return state.Counters{
+		"reads":        m.NumOfReads,
+		"bytesRead":    m.NumOfBytesRead,
+		"readStall":    m.IOStallReadMs,
+		"writes":       m.NumOfWrites,
+		"bytesWritten": m.NumOfBytesWritten,
+		"writeStall":   m.IOStallWriteMs,
+	}
+}
+
+// PopulateFileIOMetrics reports the reads, writes and average latency of each database file since the
+// previous run. SQL Server only exposes cumulative counters, so nothing is reported for a file the first
+// time it is seen or after its counters were reset.
+func PopulateFileIOMetrics(ctx context.Context, i *integration.Integration, instanceName string, connection *connection.SQLConnection, store *state.Store, engineEdition int) {
+	query := fileIOQuerySet.Select(engineEdition)
+	if query == "" {
+		log.Debug("Skipping file I/O metrics for unsupported engine edition %d", engineEdition)
+		return
+	}
+
+	models := make([]fileIOModel, 0)
+	if err := connection.QueryContext(ctx, &models, query); err != nil {
+		log.Error("Could not execute file I/O query: %s", err.Error())
+		return
+	}
+
+	for _, model := range models {
+		key := fmt.Sprintf("fileio-%s-%s-%d-%d", connection.Host, instanceName, model.DatabaseID, model.FileID)
+		delta, ok := store.Delta(key, model.counters())
+		if !ok {
+			log.Debug("No previous I/O counters for file %s of database %s", model.LogicalFileName, model.DBName)
+			continue
+		}
+
+		dbEntity, err := database.CreateDatabaseEntity(i, connection.Host, instanceName, model.DBName)
+		if err != nil {
+			log.Error("Could not create entity for database %s: %s", model.DBName, err.Error())
+			continue
+		}
+		populateFileIOSample(dbEntity, instanceName, connection.Host, model, delta)
+	}
+}
+
+func populateFileIOSample(dbEntity *integration.Entity, instanceName, host string, model fileIOModel, delta state.Counters) {
+	attributes := []attribute.Attribute{
+		{Key: "displayName", Value: dbEntity.Metadata.Name},
+		{Key: "entityName", Value: dbEntity.Metadata.Namespace + ":" + dbEntity.Metadata.Name},
+		{Key: "instance", Value: instanceName},
+		{Key: "host", Value: host},
+		{Key: "database", Value: model.DBName},
+		{Key: "logicalFileName", Value: model.LogicalFileName},
+		{Key: "fileType", Value: model.FileType},
+		{Key: "physicalPath", Value: model.PhysicalName},
+	}
+	if model.Volume != nil {
+		attributes = append(attributes, attribute.Attribute{Key: "volume", Value: *model.Volume})
+	}
+	metricSet := dbEntity.NewMetricSet("MssqlDatabaseFileSample", attributes...)
+
+	metrics := []struct {
+		metricName  string
+		metricValue float64
+	}{
+		{"io.file.reads", float64(delta["reads"])},
+		{"io.file.readsInBytes", float64(delta["bytesRead"])},
+		{"io.file.readLatencyInMilliseconds", averageLatency(delta["readStall"], delta["reads"])},
+		{"io.file.writes", float64(delta["writes"])},
+		{"io.file.writesInBytes", float64(delta["bytesWritten"])},
+		{"io.file.writeLatencyInMilliseconds", averageLatency(delta["writeStall"], delta["writes"])},
+	}
+
+	for _, m := range metrics {
+		if err := metricSet.SetMetric(m.metricName, m.metricValue, metric.GAUGE); err != nil {
+			log.Error("Could not set file I/O metric '%s': %s", m.metricName, err.Error())
+		}
+	}
+}
+
+// averageLatency returns the stall per operation, 0 when there were no operations
+func averageLatency(stallMs, operations int64) float64 {
+	if operations == 0 {
+		return 0
+	}
+	return float64(stallMs) / float64(operations)
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var fileIOColumns = []string{"db_name", "database_id", "file_id", "logical_file_name", "file_type", "physical_name", "volume",
+	"num_of_reads", "num_of_bytes_read", "io_stall_read_ms", "num_of_writes", "num_of_bytes_written", "io_stall_write_ms"}
+
+func samplesOfType(i *integration.Integration, eventType string) []map[string]interface{} {
+	samples := make([]map[string]interface{}, 0)
+	for _, e := range i.Entities {
+		for _, set := range e.Metrics {
+			if set.Metrics["event_type"] == eventType {
+				samples = append(samples, set.Metrics)
+			}
+		}
+	}
+	return samples
+}
+
+func Test_PopulateFileIOMetrics(t *testing.T) {
+	i, _ := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	mock.ExpectQuery(`FROM sys\.dm_io_virtual_file_stats\(NULL, NULL\) vfs.*CROSS APPLY sys\.dm_os_volume_stats`).WillReturnRows(
+		sqlmock.NewRows(fileIOColumns).
+			AddRow("sales", 5, 1, "sales", "ROWS", `D:\data\sales.mdf`, `D:\`, 100, 819200, 500, 50, 409600, 100).
+			AddRow("sales", 5, 2, "sales_log", "LOG", `L:\log\sales_log.ldf`, `L:\`, 10, 40960, 10, 1000, 4096000, 2000))
+	mock.ExpectQuery(`FROM sys\.dm_io_virtual_file_stats`).WillReturnRows(
+		sqlmock.NewRows(fileIOColumns).
+			AddRow("sales", 5, 1, "sales", "ROWS", `D:\data\sales.mdf`, `D:\`, 150, 1228800, 1500, 50, 409600, 100).
+			AddRow("sales", 5, 2, "sales_log", "LOG", `L:\log\sales_log.ldf`, `L:\`, 5, 20480, 5, 1000, 4096000, 2000))
+
+	PopulateFileIOMetrics(context.Background(), i, "instance", conn, store, 0)
+	assert.Empty(t, samplesOfType(i, "MssqlDatabaseFileSample"), "the first run only records the counters")
+
+	PopulateFileIOMetrics(context.Background(), i, "instance", conn, store, 0)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	samples := samplesOfType(i, "MssqlDatabaseFileSample")
+	require.Len(t, samples, 1, "the log file counters were reset")
+	sample := samples[0]
+	assert.Equal(t, "sales", sample["database"])
+	assert.Equal(t, "sales", sample["logicalFileName"])
+	assert.Equal(t, "ROWS", sample["fileType"])
+	assert.Equal(t, `D:\data\sales.mdf`, sample["physicalPath"])
+	assert.Equal(t, `D:\`, sample["volume"])
+	assert.Equal(t, "instance", sample["instance"])
+	assert.Equal(t, float64(50), sample["io.file.reads"])
+	assert.Equal(t, float64(409600), sample["io.file.readsInBytes"])
+	assert.Equal(t, float64(20), sample["io.file.readLatencyInMilliseconds"])
+	assert.Equal(t, float64(0), sample["io.file.writes"])
+	assert.Equal(t, float64(0), sample["io.file.writesInBytes"])
+	assert.Equal(t, float64(0), sample["io.file.writeLatencyInMilliseconds"])
+}
+
+func Test_PopulateFileIOMetrics_AzureSQLManagedInstance(t *testing.T) {
+	i, _ := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	for _, reads := range []int{10, 14} {
+		mock.ExpectQuery(`NULL AS volume.*FROM sys\.dm_io_virtual_file_stats`).WillReturnRows(
+			sqlmock.NewRows(fileIOColumns).
+				AddRow("sales", 5, 1, "data_0", "ROWS", "https://storage/data_0.mdf", nil, reads, 0, reads*2, 0, 0, 0))
+		PopulateFileIOMetrics(context.Background(), i, "instance", conn, store, database.AzureSQLManagedInstanceEngineEditionNumber)
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	samples := samplesOfType(i, "MssqlDatabaseFileSample")
+	require.Len(t, samples, 1)
+	assert.NotContains(t, samples[0], "volume")
+	assert.Equal(t, float64(4), samples[0]["io.file.reads"])
+	assert.Equal(t, float64(2), samples[0]["io.file.readLatencyInMilliseconds"])
+}
+
+func Test_PopulateFileIOMetrics_AzureSQLDatabase(t *testing.T) {
+	i, _ := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	PopulateFileIOMetrics(context.Background(), i, "instance", conn, state.NewInMemoryStore(), database.AzureSQLDatabaseEngineEditionNumber)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, samplesOfType(i, "MssqlDatabaseFileSample"))
+}
+
+func Test_PopulateFileIOMetrics_QueryError(t *testing.T) {
+	i, _ := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.dm_io_virtual_file_stats`).WillReturnError(errors.New("permission denied"))
+
+	PopulateFileIOMetrics(context.Background(), i, "instance", conn, state.NewInMemoryStore(), 0)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, samplesOfType(i, "MssqlDatabaseFileSample"))
+}
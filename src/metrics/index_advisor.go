@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// An index is reported as unused when it is at least this large and was updated at least
+// this many times without being read since SQL Server started
+const (
+	unusedIndexMinSizeInBytes = 64 * 1024 * 1024
+	unusedIndexMinUpdates     = 1000
+)
+
+// missingIndexQuery ranks the indexes suggested by the optimizer by the cost they would save.
+// The table is named like in unusedIndexQuery, the database being reported apart. Column lists
+// are truncated to stay within the attribute size limit.
+const missingIndexQuery = `SELECT TOP (%d)
+		DB_NAME(mid.database_id) AS db_name,
+		OBJECT_SCHEMA_NAME(mid.object_id, mid.database_id) + '.' + OBJECT_NAME(mid.object_id, mid.database_id) AS table_name,
+		LEFT(mid.equality_columns, 4000) AS equality_columns,
+		LEFT(mid.inequality_columns, 4000) AS inequality_columns,
+		LEFT(mid.included_columns, 4000) AS included_columns,
+		migs.user_seeks,
+		migs.user_scans,
+		migs.avg_total_user_cost,
+		migs.avg_user_impact,
+		migs.avg_total_user_cost * (migs.avg_user_impact / 100.0) * (migs.user_seeks + migs.user_scans) AS improvement_score
+		FROM sys.dm_db_missing_index_details mid
+		JOIN sys.dm_db_missing_index_groups mig ON mig.index_handle = mid.index_handle
+		JOIN sys.dm_db_missing_index_group_stats migs ON migs.group_handle = mig.index_group_handle
+		WHERE DB_NAME(mid.database_id) NOT IN (` + database.ExcludedDatabases + `)
+		ORDER BY improvement_score DESC`
+
+// unusedIndexQuery lists the nonclustered indexes of the current database that are maintained but
+// never read. Usage statistics are reset when SQL Server restarts, hence hours_since_restart.
+const unusedIndexQuery = `SELECT TOP (%d)
+		DB_NAME() AS db_name,
+		OBJECT_SCHEMA_NAME(i.object_id) + '.' + OBJECT_NAME(i.object_id) AS table_name,
+		i.name AS index_name,
+		ps.size_bytes,
+		us.user_updates,
+		(SELECT DATEDIFF(HOUR, sqlserver_start_time, GETDATE()) FROM sys.dm_os_sys_info) AS hours_since_restart
+		FROM sys.indexes i
+		JOIN (
+			SELECT object_id, index_id, SUM(used_page_count) * 8192 AS size_bytes
+			FROM sys.dm_db_partition_stats
+			GROUP BY object_id, index_id
+		) ps ON ps.object_id = i.object_id AND ps.index_id = i.index_id
+		JOIN sys.dm_db_index_usage_stats us ON us.database_id = DB_ID() AND us.object_id = i.object_id AND us.index_id = i.index_id
+		WHERE OBJECTPROPERTY(i.object_id, 'IsUserTable') = 1
+		AND i.type_desc = 'NONCLUSTERED'
+		AND i.is_primary_key = 0
+		AND i.is_unique_constraint = 0
+		AND us.user_seeks + us.user_scans + us.user_lookups = 0
+		AND us.user_updates >= %d
+		AND ps.size_bytes >= %d
+		ORDER BY us.user_updates DESC`
+
+// useDatabase prefixes a query so it runs in the database named %DATABASE%
+const useDatabase = `USE "` + databasePlaceholder + `"
+		`
+
+// missingIndexModel is a row result of missingIndexQuery
+type missingIndexModel struct {
+	database.DataModel
+	TableName         *string  `db:"table_name" metric_name:"index.table" source_type:"attribute"`
+	EqualityColumns   *string  `db:"equality_columns" metric_name:"index.equalityColumns" source_type:"attribute"`
+	InequalityColumns *string  `db:"inequality_columns" metric_name:"index.inequalityColumns" source_type:"attribute"`
+	IncludedColumns   *string  `db:"included_columns" metric_name:"index.includedColumns" source_type:"attribute"`
+	UserSeeks         *int64   `db:"user_seeks" metric_name:"index.userSeeks" source_type:"gauge"`
+	UserScans         *int64   `db:"user_scans" metric_name:"index.userScans" source_type:"gauge"`
+	AvgTotalUserCost  *float64 `db:"avg_total_user_cost" metric_name:"index.avgTotalUserCost" source_type:"gauge"`
+	AvgUserImpact     *float64 `db:"avg_user_impact" metric_name:"index.avgUserImpactPercent" source_type:"gauge"`
+	ImprovementScore  float64  `db:"improvement_score" metric_name:"index.improvementScore" source_type:"gauge"`
+}
+
+// unusedIndexModel is a row result of unusedIndexQuery
+type unusedIndexModel struct {
+	database.DataModel
+	TableName         *string `db:"table_name" metric_name:"index.table" source_type:"attribute"`
+	IndexName         *string `db:"index_name" metric_name:"index.name" source_type:"attribute"`
+	SizeBytes         *int64  `db:"size_bytes" metric_name:"index.sizeInBytes" source_type:"gauge"`
+	UserUpdates       int64   `db:"user_updates" metric_name:"index.userUpdates" source_type:"gauge"`
+	HoursSinceRestart *int64  `db:"hours_since_restart" metric_name:"index.hoursSinceRestart" source_type:"gauge"`
+}
+
+// indexAdvisorCollector returns the advice it could read, logging the queries that failed
+type indexAdvisorCollector func(ctx context.Context, manager *connection.Manager, dbNames []string, maxResults int) ([]missingIndexModel, []unusedIndexModel)
+
+// In Azure SQL Database the index views only describe the database of the connection
+var indexAdvisorCollectorSet = EngineSet[indexAdvisorCollector]{
+	Default:                 collectInstanceIndexAdvice,
+	AzureSQLDatabase:        collectAzureSQLDatabaseIndexAdvice,
+	AzureSQLManagedInstance: collectInstanceIndexAdvice,
+}
+
+// PopulateIndexAdvisorMetrics reports the most valuable missing indexes and the largest unused
+// indexes as events on their database entities, at most index_advisor_max_results of each per instance.
+// The views are expensive to read on large schemas, so they are read once every index_advisor_interval.
+// Queries that fail are logged and only tried again once the interval has passed, so that a
+// database that always fails does not make every run read the views of the others.
+func PopulateIndexAdvisorMetrics(ctx context.Context, i *integration.Integration, instanceName string, manager *connection.Manager, arguments args.ArgumentList, store *state.Store, engineEdition int) {
+	con, err := manager.Instance()
+	if err != nil {
+		log.Error("Could not collect index advisor metrics: %s", err.Error())
+		return
+	}
+
+	interval := time.Duration(arguments.IndexAdvisorInterval) * time.Second
+	runKey := fmt.Sprintf("index-advisor-%s-%s", con.Host, instanceName)
+	if !store.Due(runKey, interval) {
+		log.Debug("Skipping index advisor metrics, collected less than %s ago", interval)
+		return
+	}
+
+	databases, err := database.GetDatabases(con)
+	if err != nil {
+		log.Error("Could not collect index advisor metrics: %s", err.Error())
+		return
+	}
+	dbEntities, err := database.CreateDatabaseEntities(i, con.Host, instanceName, databases)
+	if err != nil {
+		log.Error("Could not collect index advisor metrics: %s", err.Error())
+		return
+	}
+	dbEntityLookup := make(map[string]*integration.Entity, len(dbEntities))
+	for _, dbEntity := range dbEntities {
+		dbEntityLookup[dbEntity.Metadata.Name] = dbEntity
+	}
+	dbNames := database.QueryableDBNames(databases)
+
+	maxResults := arguments.IndexAdvisorMaxResults
+	missing, unused := indexAdvisorCollectorSet.Select(engineEdition)(ctx, manager, dbNames, maxResults)
+	store.MarkRun(runKey)
+
+	sort.SliceStable(missing, func(a, b int) bool { return missing[a].ImprovementScore > missing[b].ImprovementScore })
+	sort.SliceStable(unused, func(a, b int) bool { return unused[a].UserUpdates > unused[b].UserUpdates })
+
+	for _, model := range missing[:min(len(missing), maxResults)] {
+		marshalDatabaseSample(dbEntityLookup, instanceName, con.Host, "MSSQLMissingIndexEvent", model)
+	}
+	for _, model := range unused[:min(len(unused), maxResults)] {
+		marshalDatabaseSample(dbEntityLookup, instanceName, con.Host, "MSSQLUnusedIndexEvent", model)
+	}
+}
+
+// collectInstanceIndexAdvice reads the missing indexes of the whole instance at once and switches
+// to each database to list its unused indexes
+func collectInstanceIndexAdvice(ctx context.Context, manager *connection.Manager, dbNames []string, maxResults int) ([]missingIndexModel, []unusedIndexModel) {
+	con, err := manager.Instance()
+	if err != nil {
+		log.Error("Could not collect index advisor metrics: %s", err.Error())
+		return nil, nil
+	}
+
+	missing := make([]missingIndexModel, 0)
+	if err := con.QueryContext(ctx, &missing, fmt.Sprintf(missingIndexQuery, maxResults)); err != nil {
+		log.Error("Could not execute missing index query: %s", err.Error())
+	}
+
+	unused := make([]unusedIndexModel, 0)
+	for _, dbName := range dbNames {
+		query := dbNameReplace(dbName)(useDatabase) + fmt.Sprintf(unusedIndexQuery, maxResults, unusedIndexMinUpdates, unusedIndexMinSizeInBytes)
+		models := make([]unusedIndexModel, 0)
+		if err := con.QueryContext(ctx, &models, query); err != nil {
+			log.Error("Could not execute unused index query for database %s: %s", dbName, err.Error())
+			continue
+		}
+		unused = append(unused, models...)
+	}
+
+	return missing, unused
+}
+
+// collectAzureSQLDatabaseIndexAdvice queries each database through its own connection
+func collectAzureSQLDatabaseIndexAdvice(ctx context.Context, manager *connection.Manager, dbNames []string, maxResults int) ([]missingIndexModel, []unusedIndexModel) {
+	missing := make([]missingIndexModel, 0)
+	unused := make([]unusedIndexModel, 0)
+
+	for _, dbName := range dbNames {
+		if ctx.Err() != nil {
+			log.Error("Could not collect index advisor metrics of the remaining databases: %s", ctx.Err())
+			break
+		}
+
+		con, err := manager.Get(dbName)
+		if err != nil {
+			log.Error("Could not collect index advisor metrics for database %s: %s", dbName, err.Error())
+			continue
+		}
+
+		missingModels := make([]missingIndexModel, 0)
+		if err := con.QueryContext(ctx, &missingModels, fmt.Sprintf(missingIndexQuery, maxResults)); err != nil {
+			log.Error("Could not execute missing index query for database %s: %s", dbName, err.Error())
+		}
+		missing = append(missing, missingModels...)
+
+		unusedModels := make([]unusedIndexModel, 0)
+		if err := con.QueryContext(ctx, &unusedModels, fmt.Sprintf(unusedIndexQuery, maxResults, unusedIndexMinUpdates, unusedIndexMinSizeInBytes)); err != nil {
+			log.Error("Could not execute unused index query for database %s: %s", dbName, err.Error())
+		}
+		unused = append(unused, unusedModels...)
+	}
+
+	return missing, unused
+}
+
+// marshalDatabaseSample adds a metric set of eventType populated from model to the entity of the database it belongs to
+func marshalDatabaseSample(dbEntities map[string]*integration.Entity, instanceName, host, eventType string, model database.DataModeler) {
+	dbEntity, ok := dbEntities[model.GetDBName()]
+	if !ok {
+		log.Debug("No entity for database %s, skipping %s", model.GetDBName(), eventType)
+		return
+	}
+
+	MarshalEntitySample(dbEntity, host, eventType, model,
+		attribute.Attribute{Key: "instance", Value: instanceName},
+		attribute.Attribute{Key: "database", Value: model.GetDBName()})
+}
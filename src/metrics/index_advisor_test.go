@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var (
+	missingIndexColumns = []string{"db_name", "table_name", "equality_columns", "inequality_columns", "included_columns",
+		"user_seeks", "user_scans", "avg_total_user_cost", "avg_user_impact", "improvement_score"}
+	unusedIndexColumns = []string{"db_name", "table_name", "index_name", "size_bytes", "user_updates", "hours_since_restart"}
+)
+
+// newMockManager returns a manager whose connections all share the mocked connection
+func newMockManager(t *testing.T) (*connection.Manager, sqlmock.Sqlmock) {
+	conn, mock := connection.CreateMockSQL(t)
+	original := connection.CreateDatabaseConnection
+	t.Cleanup(func() { connection.CreateDatabaseConnection = original })
+	connection.CreateDatabaseConnection = func(_ *args.ArgumentList, _ string) (*connection.SQLConnection, error) {
+		return conn, nil
+	}
+	return connection.NewManager(&args.ArgumentList{}), mock
+}
+
+func Test_PopulateIndexAdvisorMetrics(t *testing.T) {
+	i, _ := createTestEntity(t)
+	manager, mock := newMockManager(t)
+	store := state.NewInMemoryStore()
+	arguments := args.ArgumentList{IndexAdvisorInterval: 3600, IndexAdvisorMaxResults: 2}
+
+	mock.ExpectQuery(`select name as db_name, .* from sys\.databases`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name"}).AddRow("sales").AddRow("hr"))
+	mock.ExpectQuery(`SELECT TOP \(2\)\s+DB_NAME\(mid\.database_id\)`).WillReturnRows(
+		sqlmock.NewRows(missingIndexColumns).
+			AddRow("sales", "dbo.orders", "[customer_id]", nil, "[total]", 1200, 3, 45.2, 97.5, 53042.1).
+			AddRow("archive", "dbo.old", "[id]", nil, nil, 10, 0, 1.0, 50.0, 5.0))
+	mock.ExpectQuery(`USE "sales"\s+SELECT TOP \(2\).*us\.user_updates >= 1000\s+AND ps\.size_bytes >= 67108864`).WillReturnRows(
+		sqlmock.NewRows(unusedIndexColumns).
+			AddRow("sales", "dbo.orders", "ix_orders_status", 134217728, 25000, 720).
+			AddRow("sales", "dbo.orders", "ix_orders_note", 67108864, 1500, 720))
+	mock.ExpectQuery(`USE "hr"\s+SELECT TOP \(2\)`).WillReturnRows(
+		sqlmock.NewRows(unusedIndexColumns).
+			AddRow("hr", "dbo.people", "ix_people_email", 268435456, 90000, 720))
+
+	PopulateIndexAdvisorMetrics(context.Background(), i, "instance", manager, arguments, store, 0)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	missing := samplesOfType(i, "MSSQLMissingIndexEvent")
+	require.Len(t, missing, 1, "databases without an entity are not reported")
+	assert.Equal(t, "sales", missing[0]["database"])
+	assert.Equal(t, "dbo.orders", missing[0]["index.table"])
+	assert.Equal(t, "[customer_id]", missing[0]["index.equalityColumns"])
+	assert.NotContains(t, missing[0], "index.inequalityColumns")
+	assert.Equal(t, 53042.1, missing[0]["index.improvementScore"])
+
+	unused := samplesOfType(i, "MSSQLUnusedIndexEvent")
+	require.Len(t, unused, 2, "capped to the max results of the instance")
+	names := []interface{}{unused[0]["index.name"], unused[1]["index.name"]}
+	assert.ElementsMatch(t, []interface{}{"ix_people_email", "ix_orders_status"}, names)
+
+	// The next run within the interval does not query anything
+	PopulateIndexAdvisorMetrics(context.Background(), i, "instance", manager, arguments, store, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_PopulateIndexAdvisorMetrics_AzureSQLDatabase(t *testing.T) {
+	i, _ := createTestEntity(t)
+	manager, mock := newMockManager(t)
+	arguments := args.ArgumentList{IndexAdvisorInterval: 3600, IndexAdvisorMaxResults: 5}
+
+	mock.ExpectQuery(`select name as db_name, .* from sys\.databases`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name"}).AddRow("db-1"))
+	mock.ExpectQuery(`FROM sys\.dm_db_missing_index_details`).WillReturnRows(
+		sqlmock.NewRows(missingIndexColumns).AddRow("db-1", "dbo.t", "[a]", "[b]", nil, 10, 10, 2.0, 80.0, 32.0))
+	mock.ExpectQuery(`^SELECT TOP \(5\)\s+DB_NAME\(\) AS db_name`).WillReturnRows(sqlmock.NewRows(unusedIndexColumns))
+
+	PopulateIndexAdvisorMetrics(context.Background(), i, "instance", manager, arguments, state.NewInMemoryStore(), database.AzureSQLDatabaseEngineEditionNumber)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, samplesOfType(i, "MSSQLMissingIndexEvent"), 1)
+	assert.Empty(t, samplesOfType(i, "MSSQLUnusedIndexEvent"))
+}
+
+func Test_PopulateIndexAdvisorMetrics_FailedDatabaseWaitsForInterval(t *testing.T) {
+	i, _ := createTestEntity(t)
+	manager, mock := newMockManager(t)
+	store := state.NewInMemoryStore()
+	arguments := args.ArgumentList{IndexAdvisorInterval: 3600, IndexAdvisorMaxResults: 5}
+
+	mock.ExpectQuery(`select name as db_name, .* from sys\.databases`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name"}).AddRow("sales").AddRow("hr"))
+	mock.ExpectQuery(`FROM sys\.dm_db_missing_index_details`).WillReturnRows(
+		sqlmock.NewRows(missingIndexColumns).AddRow("sales", "dbo.t", "[a]", "[b]", nil, 10, 10, 2.0, 80.0, 32.0))
+	mock.ExpectQuery(`USE "sales"`).WillReturnRows(sqlmock.NewRows(unusedIndexColumns))
+	mock.ExpectQuery(`USE "hr"`).WillReturnError(errors.New("The server principal is not able to access the database"))
+
+	PopulateIndexAdvisorMetrics(context.Background(), i, "instance", manager, arguments, store, 0)
+	assert.Len(t, samplesOfType(i, "MSSQLMissingIndexEvent"), 1, "the databases that succeed are reported")
+
+	// The run is recorded despite the failed database, so the next one does not query again
+	PopulateIndexAdvisorMetrics(context.Background(), i, "instance", manager, arguments, store, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -172,16 +172,6 @@ var instanceBufferDefinitions = []*QueryDefinition{
 	},
 }
 
-var waitTimeQuery = `SELECT wait_type, wait_time_ms AS wait_time, waiting_tasks_count
-FROM sys.dm_os_wait_stats wait_stats
-WHERE wait_time_ms != 0`
-
-type waitTimeModel struct {
-	WaitType  *string `db:"wait_type"`
-	WaitTime  *int64  `db:"wait_time"`
-	WaitCount *int64  `db:"waiting_tasks_count"`
-}
-
 var diskMetricInBytesDefinition = []*QueryDefinition{
 	{
 		query: `SELECT Sum(total_bytes) AS total_disk_space FROM (
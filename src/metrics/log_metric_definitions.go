@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/newrelic/nri-mssql/src/database"
+)
+
+// logReuseModel is a row result of the log reuse queries. The reuse wait is an attribute so
+// alerts can match values such as LOG_BACKUP or ACTIVE_TRANSACTION.
+type logReuseModel struct {
+	database.DataModel
+	LogReuseWait   *string `db:"log_reuse_wait" metric_name:"log.reuseWait" source_type:"attribute"`
+	VLFCount       *int64  `db:"vlf_count" metric_name:"log.virtualLogFiles" source_type:"gauge"`
+	ActiveVLFCount *int64  `db:"active_vlf_count" metric_name:"log.activeVirtualLogFiles" source_type:"gauge"`
+}
+
+// logFlushModel is a row result of the log flush counter queries. The counters, Log Flush Wait
+// Time included, are cumulative and reported as rates.
+type logFlushModel struct {
+	database.DataModel
+	LogFlushes       *int64 `db:"log_flushes" metric_name:"log.flushesPerSecond" source_type:"rate"`
+	LogBytesFlushed  *int64 `db:"log_bytes_flushed" metric_name:"log.bytesFlushedPerSecond" source_type:"rate"`
+	LogFlushWaits    *int64 `db:"log_flush_waits" metric_name:"log.flushWaitsPerSecond" source_type:"rate"`
+	LogFlushWaitTime *int64 `db:"log_flush_wait_time" metric_name:"log.flushWaitTimeInMillisecondsPerSecond" source_type:"rate"`
+}
+
+// logSpaceModel is a row result of the log space queries
+type logSpaceModel struct {
+	database.DataModel
+	TotalLogSize        *int64   `db:"total_log_size" metric_name:"log.sizeInBytes" source_type:"gauge"`
+	UsedLogSpace        *int64   `db:"used_log_space" metric_name:"log.usedInBytes" source_type:"gauge"`
+	UsedLogSpacePercent *float64 `db:"used_log_space_percent" metric_name:"log.usedPercent" source_type:"gauge"`
+}
+
+// logFlushCounters selects the log flush counters of the Databases object, pivoted to one row per instance_name
+const logFlushCounters = `MAX(CASE WHEN spc.counter_name = 'Log Flushes/sec' THEN spc.cntr_value END) AS log_flushes,
+		MAX(CASE WHEN spc.counter_name = 'Log Bytes Flushed/sec' THEN spc.cntr_value END) AS log_bytes_flushed,
+		MAX(CASE WHEN spc.counter_name = 'Log Flush Waits/sec' THEN spc.cntr_value END) AS log_flush_waits,
+		MAX(CASE WHEN spc.counter_name = 'Log Flush Wait Time' THEN spc.cntr_value END) AS log_flush_wait_time
+		FROM sys.dm_os_performance_counters spc WITH (NOLOCK)`
+
+const logFlushCounterFilter = `spc.object_name LIKE '%:Databases%'
+		AND spc.counter_name IN ('Log Flushes/sec', 'Log Bytes Flushed/sec', 'Log Flush Waits/sec', 'Log Flush Wait Time')`
+
+// logReuseDefinition reports why the log of each online database cannot be truncated and its VLF count
+var logReuseDefinition = &QueryDefinition{
+	query: `SELECT
+		d.name AS db_name,
+		d.log_reuse_wait_desc AS log_reuse_wait,
+		li.vlf_count,
+		li.active_vlf_count
+		FROM sys.databases d
+		OUTER APPLY (
+			SELECT COUNT(*) AS vlf_count, SUM(CAST(vlf_active AS INT)) AS active_vlf_count
+			FROM sys.dm_db_log_info(d.database_id)
+		) li
+		WHERE d.state = 0
+		AND d.name NOT IN (` + database.ExcludedDatabases + `)`,
+	dataModels: &[]logReuseModel{},
+}
+
+// logDefinitions definitions for the transaction log queries that cover every database at once
+var logDefinitions = []*QueryDefinition{
+	logReuseDefinition,
+	{
+		query: `SELECT
+		RTRIM(spc.instance_name) AS db_name,
+		` + logFlushCounters + `
+		WHERE ` + logFlushCounterFilter + `
+		AND RTRIM(spc.instance_name) NOT IN ('_Total', 'mssqlsystemresource', ` + database.ExcludedDatabases + `)
+		GROUP BY RTRIM(spc.instance_name)`,
+		dataModels: &[]logFlushModel{},
+	},
+}
+
+// logDefinitionsForAzureSQLManagedInstance the counters of a managed instance are named after the physical database name
+var logDefinitionsForAzureSQLManagedInstance = []*QueryDefinition{
+	logReuseDefinition,
+	{
+		query: `SELECT
+		sd.name AS db_name,
+		` + logFlushCounters + `
+		INNER JOIN sys.databases sd ON sd.physical_database_name = spc.instance_name
+		WHERE ` + logFlushCounterFilter + `
+		AND sd.name NOT IN (` + database.ExcludedDatabases + `)
+		GROUP BY sd.name`,
+		dataModels: &[]logFlushModel{},
+	},
+}
+
+// logDefinitionsForAzureSQLDatabase run on the connection of each database
+var logDefinitionsForAzureSQLDatabase = []*QueryDefinition{
+	{
+		query: `SELECT
+			DB_NAME() AS db_name,
+			d.log_reuse_wait_desc AS log_reuse_wait,
+			(SELECT COUNT(*) FROM sys.dm_db_log_info(DB_ID())) AS vlf_count,
+			(SELECT SUM(CAST(vlf_active AS INT)) FROM sys.dm_db_log_info(DB_ID())) AS active_vlf_count
+			FROM sys.databases d
+			WHERE d.database_id = DB_ID()`,
+		dataModels: &[]logReuseModel{},
+	},
+	{
+		query: `SELECT
+			DB_NAME() AS db_name,
+			` + logFlushCounters + `
+			INNER JOIN sys.databases sd ON sd.physical_database_name = spc.instance_name
+			WHERE ` + logFlushCounterFilter + `
+			AND sd.database_id = DB_ID()`,
+		dataModels: &[]logFlushModel{},
+	},
+	{
+		query: `SELECT
+			DB_NAME() AS db_name,
+			total_log_size_in_bytes AS total_log_size,
+			used_log_space_in_bytes AS used_log_space,
+			used_log_space_in_percent AS used_log_space_percent
+			FROM sys.dm_db_log_space_usage`,
+		dataModels: &[]logSpaceModel{},
+	},
+}
+
+// specificLogDefinitions sys.dm_db_log_space_usage only describes the current database
+var specificLogDefinitions = []*QueryDefinition{
+	{
+		query: fmt.Sprintf(`USE "%s"
+		SELECT
+		DB_NAME() AS db_name,
+		total_log_size_in_bytes AS total_log_size,
+		used_log_space_in_bytes AS used_log_space,
+		used_log_space_in_percent AS used_log_space_percent
+		FROM sys.dm_db_log_space_usage`, databasePlaceholder),
+		dataModels: &[]logSpaceModel{},
+	},
+}
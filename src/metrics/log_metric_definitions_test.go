@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_processLogDefinitions(t *testing.T) {
+	conn, mock := connection.CreateMockSQL(t)
+	mock.ExpectQuery(`log_reuse_wait_desc AS log_reuse_wait.*sys\.dm_db_log_info\(d\.database_id\)`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name", "log_reuse_wait", "vlf_count", "active_vlf_count"}).
+			AddRow("sales", "LOG_BACKUP", 1200, 3))
+	mock.ExpectQuery(`'Log Flushes/sec'.*GROUP BY RTRIM\(spc\.instance_name\)`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name", "log_flushes", "log_bytes_flushed", "log_flush_waits", "log_flush_wait_time"}).
+			AddRow("sales", 5000, 2048000, 12, 40))
+	mock.ExpectQuery(`USE "sales".*FROM sys\.dm_db_log_space_usage`).WillReturnRows(
+		sqlmock.NewRows([]string{"db_name", "total_log_size", "used_log_space", "used_log_space_percent"}).
+			AddRow("sales", 1048576, 524288, 50.0))
+
+	modelChan := make(chan interface{}, 10)
+	processDBDefinitions(context.Background(), conn, GetQueryDefinitions(LogQueries, 0), modelChan)
+	processSpecificDBDefinitions(context.Background(), conn, GetQueryDefinitions(SpecificLogQueries, 0), []string{"sales"}, modelChan)
+	close(modelChan)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	models := make([]interface{}, 0)
+	for model := range modelChan {
+		models = append(models, model)
+	}
+	require.Len(t, models, 3)
+
+	reuse := models[0].(logReuseModel)
+	assert.Equal(t, "sales", reuse.GetDBName())
+	assert.Equal(t, "LOG_BACKUP", *reuse.LogReuseWait)
+	assert.Equal(t, int64(1200), *reuse.VLFCount)
+
+	flush := models[1].(logFlushModel)
+	assert.Equal(t, int64(5000), *flush.LogFlushes)
+	assert.Equal(t, int64(40), *flush.LogFlushWaitTime)
+
+	space := models[2].(logSpaceModel)
+	assert.Equal(t, 50.0, *space.UsedLogSpacePercent)
+}
+
+func Test_logDefinitions_AzureSQLDatabase(t *testing.T) {
+	conn, mock := connection.CreateMockSQL(t)
+	mock.ExpectQuery(`WHERE d\.database_id = DB_ID\(\)`).WillReturnRows(sqlmock.NewRows([]string{"db_name"}))
+	mock.ExpectQuery(`AND sd\.database_id = DB_ID\(\)`).WillReturnRows(sqlmock.NewRows([]string{"db_name"}))
+	mock.ExpectQuery(`FROM sys\.dm_db_log_space_usage`).WillReturnRows(sqlmock.NewRows([]string{"db_name"}))
+
+	processDBDefinitions(context.Background(), conn, GetQueryDefinitions(LogQueries, database.AzureSQLDatabaseEngineEditionNumber), make(chan interface{}, 1))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, GetQueryDefinitions(SpecificLogQueries, database.AzureSQLDatabaseEngineEditionNumber))
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+// memoryClerkQuery lists the 10 memory clerk types holding the most memory, summed across NUMA nodes
+const memoryClerkQuery = `SELECT TOP 10
+		type AS clerk_type,
+		SUM(pages_kb) * 1024 AS size
+		FROM sys.dm_os_memory_clerks
+		GROUP BY type
+		ORDER BY SUM(pages_kb) DESC`
+
+// planCacheQuery reports the size of the plan cache by object type. Plans used only once are a
+// common source of plan cache bloat, so their size is reported apart.
+const planCacheQuery = `SELECT
+		objtype AS object_type,
+		COUNT_BIG(*) AS plans,
+		SUM(CAST(size_in_bytes AS BIGINT)) AS size,
+		SUM(CASE WHEN usecounts = 1 THEN CAST(size_in_bytes AS BIGINT) ELSE 0 END) AS single_use_size
+		FROM sys.dm_exec_cached_plans
+		GROUP BY objtype`
+
+// memoryGrantQuery lists the queries waiting for a memory grant first, then the largest granted ones.
+// granted_memory_kb is NULL while a query waits.
+const memoryGrantQuery = `SELECT TOP 20
+		session_id,
+		CASE WHEN grant_time IS NULL THEN 'waiting' ELSE 'granted' END AS grant_status,
+		requested_memory_kb * 1024 AS requested_memory,
+		granted_memory_kb * 1024 AS granted_memory,
+		required_memory_kb * 1024 AS required_memory,
+		used_memory_kb * 1024 AS used_memory,
+		wait_time_ms AS wait_time,
+		dop,
+		query_cost
+		FROM sys.dm_exec_query_memory_grants
+		ORDER BY CASE WHEN grant_time IS NULL THEN 0 ELSE 1 END, requested_memory_kb DESC`
+
+// memoryGrantCounterDefinition reports the Memory Manager grant counters on the MssqlInstanceSample
+var memoryGrantCounterDefinition = &QueryDefinition{
+	query: `SELECT
+		MAX(CASE WHEN counter_name = 'Memory Grants Pending' THEN cntr_value END) AS memory_grants_pending,
+		MAX(CASE WHEN counter_name = 'Memory Grants Outstanding' THEN cntr_value END) AS memory_grants_outstanding
+		FROM sys.dm_os_performance_counters WITH (NOLOCK)
+		WHERE object_name LIKE '%:Memory Manager%'
+		AND counter_name IN ('Memory Grants Pending', 'Memory Grants Outstanding')`,
+	dataModels: &[]struct {
+		MemoryGrantsPending     *int64 `db:"memory_grants_pending" metric_name:"memory.grantsPending" source_type:"gauge"`
+		MemoryGrantsOutstanding *int64 `db:"memory_grants_outstanding" metric_name:"memory.grantsOutstanding" source_type:"gauge"`
+	}{},
+}
+
+// memoryClerkModel is a row result of memoryClerkQuery
+type memoryClerkModel struct {
+	ClerkType string `db:"clerk_type"`
+	Size      *int64 `db:"size" metric_name:"memory.clerk.sizeInBytes" source_type:"gauge"`
+}
+
+// planCacheModel is a row result of planCacheQuery
+type planCacheModel struct {
+	ObjectType    string `db:"object_type"`
+	Plans         *int64 `db:"plans" metric_name:"planCache.plans" source_type:"gauge"`
+	Size          *int64 `db:"size" metric_name:"planCache.sizeInBytes" source_type:"gauge"`
+	SingleUseSize *int64 `db:"single_use_size" metric_name:"planCache.singleUseSizeInBytes" source_type:"gauge"`
+}
+
+// memoryGrantModel is a row result of memoryGrantQuery
+type memoryGrantModel struct {
+	SessionID       int64    `db:"session_id"`
+	GrantStatus     *string  `db:"grant_status" metric_name:"memoryGrant.status" source_type:"attribute"`
+	RequestedMemory *int64   `db:"requested_memory" metric_name:"memoryGrant.requestedInBytes" source_type:"gauge"`
+	GrantedMemory   *int64   `db:"granted_memory" metric_name:"memoryGrant.grantedInBytes" source_type:"gauge"`
+	RequiredMemory  *int64   `db:"required_memory" metric_name:"memoryGrant.requiredInBytes" source_type:"gauge"`
+	UsedMemory      *int64   `db:"used_memory" metric_name:"memoryGrant.usedInBytes" source_type:"gauge"`
+	WaitTime        *int64   `db:"wait_time" metric_name:"memoryGrant.waitTimeInMilliseconds" source_type:"gauge"`
+	DOP             *int64   `db:"dop" metric_name:"memoryGrant.degreeOfParallelism" source_type:"gauge"`
+	QueryCost       *float64 `db:"query_cost" metric_name:"memoryGrant.queryCost" source_type:"gauge"`
+}
+
+// PopulateMemoryMetrics reports the largest memory clerks in MssqlMemoryClerkSample, the plan cache
+// by object type in MssqlPlanCacheSample and the queries waiting for or holding a memory grant in
+// MssqlMemoryGrantSample
+func PopulateMemoryMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) {
+	clerkModels := make([]memoryClerkModel, 0)
+	if err := connection.QueryContext(ctx, &clerkModels, memoryClerkQuery); err != nil {
+		log.Error("Could not execute memory clerk query: %s", err.Error())
+	}
+	for _, model := range clerkModels {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlMemoryClerkSample", model,
+			attribute.Attribute{Key: "clerkType", Value: model.ClerkType})
+	}
+
+	planCacheModels := make([]planCacheModel, 0)
+	if err := connection.QueryContext(ctx, &planCacheModels, planCacheQuery); err != nil {
+		log.Error("Could not execute plan cache query: %s", err.Error())
+	}
+	for _, model := range planCacheModels {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlPlanCacheSample", model,
+			attribute.Attribute{Key: "objectType", Value: model.ObjectType})
+	}
+
+	grantModels := make([]memoryGrantModel, 0)
+	if err := connection.QueryContext(ctx, &grantModels, memoryGrantQuery); err != nil {
+		log.Error("Could not execute memory grant query: %s", err.Error())
+		return
+	}
+	for _, model := range grantModels {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlMemoryGrantSample", model,
+			attribute.Attribute{Key: "sessionId", Value: strconv.FormatInt(model.SessionID, 10)})
+	}
+}
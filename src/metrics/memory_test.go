@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var memoryGrantColumns = []string{"session_id", "grant_status", "requested_memory", "granted_memory", "required_memory", "used_memory", "wait_time", "dop", "query_cost"}
+
+func Test_PopulateMemoryMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`SELECT TOP 10\s+type AS clerk_type.*FROM sys\.dm_os_memory_clerks`).WillReturnRows(
+		sqlmock.NewRows([]string{"clerk_type", "size"}).
+			AddRow("MEMORYCLERK_SQLBUFFERPOOL", 8589934592).
+			AddRow("CACHESTORE_SQLCP", 1073741824))
+	mock.ExpectQuery(`FROM sys\.dm_exec_cached_plans\s+GROUP BY objtype`).WillReturnRows(
+		sqlmock.NewRows([]string{"object_type", "plans", "size", "single_use_size"}).
+			AddRow("Adhoc", 25000, 1048576000, 943718400))
+	mock.ExpectQuery(`FROM sys\.dm_exec_query_memory_grants`).WillReturnRows(
+		sqlmock.NewRows(memoryGrantColumns).
+			AddRow(71, "waiting", 2147483648, nil, 5242880, nil, 12000, 8, 1450.5).
+			AddRow(64, "granted", 104857600, 104857600, 1048576, 52428800, 0, 4, 210.0))
+
+	PopulateMemoryMetrics(context.Background(), e, conn)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	clerks := samplesOfType(i, "MssqlMemoryClerkSample")
+	require.Len(t, clerks, 2)
+	assert.Equal(t, "MEMORYCLERK_SQLBUFFERPOOL", clerks[0]["clerkType"])
+	assert.Equal(t, float64(8589934592), clerks[0]["memory.clerk.sizeInBytes"])
+
+	planCache := samplesOfType(i, "MssqlPlanCacheSample")
+	require.Len(t, planCache, 1)
+	assert.Equal(t, "Adhoc", planCache[0]["objectType"])
+	assert.Equal(t, float64(943718400), planCache[0]["planCache.singleUseSizeInBytes"])
+
+	grants := samplesOfType(i, "MssqlMemoryGrantSample")
+	require.Len(t, grants, 2)
+	assert.Equal(t, "71", grants[0]["sessionId"])
+	assert.Equal(t, "waiting", grants[0]["memoryGrant.status"])
+	assert.Equal(t, float64(2147483648), grants[0]["memoryGrant.requestedInBytes"])
+	assert.NotContains(t, grants[0], "memoryGrant.grantedInBytes")
+	assert.Equal(t, float64(104857600), grants[1]["memoryGrant.grantedInBytes"])
+}
+
+func Test_PopulateMemoryMetrics_QueryError(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.dm_os_memory_clerks`).WillReturnError(errors.New("VIEW SERVER STATE permission was denied"))
+	mock.ExpectQuery(`FROM sys\.dm_exec_cached_plans`).WillReturnError(errors.New("VIEW SERVER STATE permission was denied"))
+	mock.ExpectQuery(`FROM sys\.dm_exec_query_memory_grants`).WillReturnRows(sqlmock.NewRows(memoryGrantColumns))
+
+	PopulateMemoryMetrics(context.Background(), e, conn)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, samplesOfType(i, "MssqlMemoryClerkSample"))
+	assert.Empty(t, e.Metrics)
+}
+
+func Test_PopulateInstanceMetrics_MemoryGrantCounters(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		_, e := createTestEntity(t)
+		conn, mock := connection.CreateMockSQL(t)
+		mock.MatchExpectationsInOrder(false)
+		mock.ExpectQuery(`AS memory_grants_pending`).WillReturnRows(
+			sqlmock.NewRows([]string{"memory_grants_pending", "memory_grants_outstanding"}).AddRow(3, 12))
+
+		PopulateInstanceMetrics(context.Background(), e, conn, args.ArgumentList{EnableMemoryPressureMetrics: enabled}, 3)
+
+		sample := e.Metrics[0].Metrics
+		if enabled {
+			assert.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, float64(3), sample["memory.grantsPending"])
+			assert.Equal(t, float64(12), sample["memory.grantsOutstanding"])
+		} else {
+			assert.NotContains(t, sample, "memory.grantsPending", "the counters follow ENABLE_MEMORY_PRESSURE_METRICS")
+		}
+	}
+}
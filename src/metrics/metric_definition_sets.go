@@ -34,6 +34,9 @@ const (
 	BufferQueries
 	SpecificQueries
 	MemoryQueries
+	BackupQueries
+	LogQueries
+	SpecificLogQueries
 )
 
 var queryDefinitionSets = map[QueryDefinitionType]EngineSet[[]*QueryDefinition]{
@@ -57,6 +60,21 @@ var queryDefinitionSets = map[QueryDefinitionType]EngineSet[[]*QueryDefinition]{
 		AzureSQLDatabase:        []*QueryDefinition{},
 		AzureSQLManagedInstance: instanceMemoryDefinitionsForAzureSQLManagedInstance,
 	},
+	BackupQueries: {
+		Default:                 backupDefinitions,
+		AzureSQLDatabase:        backupDefinitionsForAzureSQLDatabase,
+		AzureSQLManagedInstance: backupDefinitions,
+	},
+	LogQueries: {
+		Default:                 logDefinitions,
+		AzureSQLDatabase:        logDefinitionsForAzureSQLDatabase,
+		AzureSQLManagedInstance: logDefinitionsForAzureSQLManagedInstance,
+	},
+	SpecificLogQueries: {
+		Default:                 specificLogDefinitions,
+		AzureSQLDatabase:        []*QueryDefinition{},
+		AzureSQLManagedInstance: specificLogDefinitions,
+	},
 }
 
 func GetQueryDefinitions(defType QueryDefinitionType, engineEdition int) []*QueryDefinition {
@@ -2,6 +2,7 @@
 package metrics
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -44,13 +45,16 @@ var (
 const (
 	// Maximum number of metrics retrieved from a single query execution
 	resultsBufferSizePerWorker = 5
+	// sqlTimeLayout formats and parses the times converted to text with style 126, which leaves out
+	// the milliseconds when they are zero
+	sqlTimeLayout = "2006-01-02T15:04:05.999"
 )
 
 // PopulateInstanceMetrics creates instance-level metrics
 // The below function has too many if's which is needed , so ignoring the golint error by adding below linter directive.
 //
 //nolint:gocyclo
-func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList, engineEdition int) {
+func PopulateInstanceMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList, engineEdition int) {
 	metricSet := instanceEntity.NewMetricSet("MssqlInstanceSample",
 		attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
 		attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
@@ -59,6 +63,9 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 
 	collectionList := instanceDefinitions
 	collectionList = append(collectionList, GetQueryDefinitions(MemoryQueries, engineEdition)...)
+	if arguments.EnableMemoryPressureMetrics {
+		collectionList = append(collectionList, memoryGrantCounterDefinition)
+	}
 	if arguments.EnableBufferMetrics {
 		collectionList = append(collectionList, instanceBufferDefinitions...)
 	}
@@ -72,7 +79,7 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 			log.Debug("Skipping query '%s' for unsupported engine edition %d", queryDef.GetQuery(), engineEdition)
 			continue
 		}
-		if err := connection.Query(models, queryDef.GetQuery()); err != nil {
+		if err := connection.QueryContext(ctx, models, queryDef.GetQuery()); err != nil {
 			log.Error("Could not execute instance query: %s", err.Error())
 			continue
 		}
@@ -92,11 +99,9 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 		}
 	}
 
-	populateWaitTimeMetrics(instanceEntity, connection)
-
 	if len(arguments.CustomMetricsQuery) > 0 {
 		log.Debug("Arguments custom metrics query: %s", arguments.CustomMetricsQuery)
-		populateCustomMetrics(instanceEntity, connection, customQuery{Query: arguments.CustomMetricsQuery})
+		populateCustomMetrics(ctx, instanceEntity, connection, customQuery{Query: arguments.CustomMetricsQuery})
 	} else if len(arguments.CustomMetricsConfig) > 0 {
 		queries, err := parseCustomQueries(arguments)
 		if err != nil {
@@ -108,7 +113,7 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 			wg.Add(1)
 			go func(query customQuery) {
 				defer wg.Done()
-				populateCustomMetrics(instanceEntity, connection, query)
+				populateCustomMetrics(ctx, instanceEntity, connection, query)
 			}(query)
 		}
 		wg.Wait()
@@ -131,46 +136,8 @@ func parseCustomQueries(arguments args.ArgumentList) ([]customQuery, error) {
 	return c.Queries, nil
 }
 
-func populateWaitTimeMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection) {
-	models := make([]waitTimeModel, 0)
-	if err := connection.Query(&models, waitTimeQuery); err != nil {
-		log.Error("Could not execute query: %s", err.Error())
-		return
-	}
-
-	for _, model := range models {
-		metricSet := instanceEntity.NewMetricSet("MssqlWaitSample",
-			attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
-			attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
-			attribute.Attribute{Key: "waitType", Value: *model.WaitType},
-			attribute.Attribute{Key: "host", Value: connection.Host},
-			attribute.Attribute{Key: "instance", Value: instanceEntity.Metadata.Name},
-		)
-
-		metrics := []struct {
-			metricName  string
-			metricValue int64
-			metricType  metric.SourceType
-		}{
-			{
-				"system.waitTimeCount", *model.WaitCount, metric.GAUGE,
-			},
-			{
-				"system.waitTimeInMillisecondsPerSecond", *model.WaitTime, metric.GAUGE,
-			},
-		}
-
-		for _, metric := range metrics {
-			err := metricSet.SetMetric(metric.metricName, metric.metricValue, metric.metricType)
-			if err != nil {
-				log.Error("Could not set wait time metric '%s' for wait type '%s': %s", metric.metricName, model.WaitType, err.Error())
-			}
-		}
-	}
-}
-
 // Execute one or more custom queries
-func populateCustomMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, query customQuery) {
+func populateCustomMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, query customQuery) {
 	var prefix string
 	if len(query.Database) > 0 {
 		prefix = "USE " + query.Database + "; "
@@ -178,7 +145,7 @@ func populateCustomMetrics(instanceEntity *integration.Entity, connection *conne
 
 	log.Debug("Running custom query: %+v", query)
 
-	rows, err := connection.Queryx(prefix + query.Query)
+	rows, err := connection.QueryxContext(ctx, prefix+query.Query)
 	if err != nil {
 		log.Error("Could not execute custom query: %s", err)
 		return
@@ -331,7 +298,7 @@ func metricFromTargetColumns(metricValue, metricName, metricType string, query c
 	return &customQueryMetricValue{value: metricValue, sourceType: sourceType}, nil
 }
 
-type databaseMetricsProcessor func(*integration.Integration, string, *connection.SQLConnection, args.ArgumentList, database.DBMetricSetLookup, int, chan<- interface{})
+type databaseMetricsProcessor func(context.Context, *integration.Integration, string, *connection.SQLConnection, *connection.Manager, args.ArgumentList, []string, int, chan<- interface{})
 
 // Bucket for processor functions
 var processorFunctionSet = EngineSet[databaseMetricsProcessor]{
@@ -340,10 +307,20 @@ var processorFunctionSet = EngineSet[databaseMetricsProcessor]{
 	AzureSQLManagedInstance: processDefaultDBMetrics,
 }
 
-// PopulateDatabaseMetrics collects per-database metrics
-func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, connection *connection.SQLConnection, arguments args.ArgumentList, engineEdition int) error {
+// PopulateDatabaseMetrics collects per-database metrics using the pooled connections of manager
+func PopulateDatabaseMetrics(ctx context.Context, i *integration.Integration, instanceName string, manager *connection.Manager, arguments args.ArgumentList, engineEdition int) error {
+	connection, err := manager.Instance()
+	if err != nil {
+		return err
+	}
+
+	databases, err := database.GetDatabases(connection)
+	if err != nil {
+		return err
+	}
+
 	// create database entities
-	dbEntities, err := database.CreateDatabaseEntities(i, connection, instanceName)
+	dbEntities, err := database.CreateDatabaseEntities(i, connection.Host, instanceName, databases)
 	if err != nil {
 		return err
 	}
@@ -360,8 +337,11 @@ func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, co
 	wg.Add(1)
 	go dbMetricPopulator(dbSetLookup, modelChan, &wg)
 
+	// the state is reported for every database, the other queries only run in the ones that can be queried
+	sendModelsToPopulator(modelChan, databases)
+
 	processor := processorFunctionSet.Select(engineEdition)
-	processor(i, instanceName, connection, arguments, dbSetLookup, engineEdition, modelChan)
+	processor(ctx, i, instanceName, connection, manager, arguments, database.QueryableDBNames(databases), engineEdition, modelChan)
 
 	close(modelChan)
 	wg.Wait()
@@ -370,77 +350,100 @@ func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, co
 }
 
 // processDefaultDBMetrics handles metric collection for a standard SQL Server instance.
-func processDefaultDBMetrics(i *integration.Integration, instanceName string, connection *connection.SQLConnection, arguments args.ArgumentList, dbSetLookup database.DBMetricSetLookup, engineEdition int, modelChan chan<- interface{}) {
+func processDefaultDBMetrics(ctx context.Context, i *integration.Integration, instanceName string, connection *connection.SQLConnection, _ *connection.Manager, arguments args.ArgumentList, dbNames []string, engineEdition int, modelChan chan<- interface{}) {
 	// run queries that are not specific to a database
-	processDBDefinitions(connection, GetQueryDefinitions(StandardQueries, engineEdition), modelChan)
+	processDBDefinitions(ctx, connection, GetQueryDefinitions(StandardQueries, engineEdition), modelChan)
 
 	// run queries that are not specific to a database
 	if arguments.EnableBufferMetrics {
-		processDBBufferDefinitions(connection, modelChan)
+		processDBBufferDefinitions(ctx, connection, modelChan)
 	}
 
 	// run queries that are specific to a database
 	if arguments.EnableDatabaseReserveMetrics {
-		processSpecificDBDefinitions(connection, dbSetLookup.GetDBNames(), modelChan)
+		processSpecificDBDefinitions(ctx, connection, specificDatabaseDefinitions, dbNames, modelChan)
+	}
+
+	if arguments.EnableBackupMetrics {
+		processBackupDefinitions(ctx, connection, arguments, engineEdition, modelChan)
+	}
+
+	if arguments.EnableLogMetrics {
+		processDBDefinitions(ctx, connection, GetQueryDefinitions(LogQueries, engineEdition), modelChan)
+		processSpecificDBDefinitions(ctx, connection, GetQueryDefinitions(SpecificLogQueries, engineEdition), dbNames, modelChan)
 	}
 }
 
 // processAzureSQLDatabaseMetrics handles metric collection for Azure SQL Database concurrently.
 // It dispatches the work of processing each database to a worker goroutine.
-func processAzureSQLDatabaseMetrics(i *integration.Integration, instanceName string, _ *connection.SQLConnection, arguments args.ArgumentList, dbSetLookup database.DBMetricSetLookup, engineEdition int, modelChan chan<- interface{}) {
-	databaseNames := dbSetLookup.GetDBNames()
-
+func processAzureSQLDatabaseMetrics(ctx context.Context, i *integration.Integration, instanceName string, _ *connection.SQLConnection, manager *connection.Manager, arguments args.ArgumentList, dbNames []string, engineEdition int, modelChan chan<- interface{}) {
 	maxWorkers := arguments.GetMaxConcurrentWorkers()
 	dbChan := make(chan struct{}, maxWorkers)
 	var waitGroup sync.WaitGroup
 
-	for _, dbName := range databaseNames {
+	for idx, dbName := range dbNames {
+		if ctx.Err() != nil {
+			log.Warn("Time budget exhausted, skipping metrics for %d remaining databases", len(dbNames)-idx)
+			break
+		}
 		waitGroup.Add(1)
 		dbChan <- struct{}{}
-		go processSingleAzureDB(&waitGroup, dbChan, dbName, arguments, engineEdition, modelChan)
+		go processSingleAzureDB(ctx, &waitGroup, dbChan, manager, dbName, arguments, engineEdition, modelChan)
 	}
 	waitGroup.Wait()
 }
 
-func processSingleAzureDB(wg *sync.WaitGroup, dbChan chan struct{}, dbName string, arguments args.ArgumentList, engineEdition int, modelChan chan<- interface{}) {
+func processSingleAzureDB(ctx context.Context, wg *sync.WaitGroup, dbChan chan struct{}, manager *connection.Manager, dbName string, arguments args.ArgumentList, engineEdition int, modelChan chan<- interface{}) {
 	defer wg.Done()
 	defer func() { <-dbChan }()
 
-	con, err := connection.CreateDatabaseConnection(&arguments, dbName)
+	// The connection is owned by the manager and stays open for the other collectors
+	con, err := manager.Get(dbName)
 	if err != nil {
+		if errors.Is(err, connection.ErrDatabaseUnavailable) {
+			log.Warn("Database %s is currently unavailable, skipping populating db metrics: %s", dbName, err.Error())
+			return
+		}
 		log.Error("Error creating connection to SQL Server: %s", err.Error())
 		log.Warn("Skipping populating db metrics for database : %s", dbName)
 		return
 	}
-	defer con.Close()
 
-	processDBDefinitions(con, GetQueryDefinitions(StandardQueries, engineEdition), modelChan)
+	processDBDefinitions(ctx, con, GetQueryDefinitions(StandardQueries, engineEdition), modelChan)
 
-	processMemoryDBDefinitions(con, dbName, modelChan)
+	processMemoryDBDefinitions(ctx, con, dbName, modelChan)
 
 	if arguments.EnableDiskMetricsInBytes {
-		processDBDefinitions(con, databaseDiskDefinitionsForAzureSQLDatabase, modelChan)
+		processDBDefinitions(ctx, con, databaseDiskDefinitionsForAzureSQLDatabase, modelChan)
 	}
 
 	if arguments.EnableBufferMetrics {
-		processDBDefinitions(con, GetQueryDefinitions(BufferQueries, engineEdition), modelChan)
+		processDBDefinitions(ctx, con, GetQueryDefinitions(BufferQueries, engineEdition), modelChan)
 	}
 
 	if arguments.EnableDatabaseReserveMetrics {
-		processDBDefinitions(con, GetQueryDefinitions(SpecificQueries, engineEdition), modelChan)
+		processDBDefinitions(ctx, con, GetQueryDefinitions(SpecificQueries, engineEdition), modelChan)
+	}
+
+	if arguments.EnableBackupMetrics {
+		processBackupDefinitions(ctx, con, arguments, engineEdition, modelChan)
+	}
+
+	if arguments.EnableLogMetrics {
+		processDBDefinitions(ctx, con, GetQueryDefinitions(LogQueries, engineEdition), modelChan)
 	}
 }
 
-func processMemoryDBDefinitions(con *connection.SQLConnection, dbName string, modelChan chan<- interface{}) {
+func processMemoryDBDefinitions(ctx context.Context, con *connection.SQLConnection, dbName string, modelChan chan<- interface{}) {
 	var memUtilResult []*MemoryUtilizationModel
-	if err := con.Query(&memUtilResult, memoryUtilizationQuery); err != nil {
+	if err := con.QueryContext(ctx, &memUtilResult, memoryUtilizationQuery); err != nil {
 		log.Error("Encountered the following error: %s. Running query '%s'", err.Error(), memoryUtilizationQuery)
 	} else {
 		sendModelsToPopulator(modelChan, memUtilResult)
 	}
 
 	var totalMemResult []*TotalPhysicalMemoryModel
-	if err := con.Query(&totalMemResult, totalPhysicalMemoryQuery); err != nil {
+	if err := con.QueryContext(ctx, &totalMemResult, totalPhysicalMemoryQuery); err != nil {
 		log.Error("Encountered the following error: %s. Running query '%s'", err.Error(), totalPhysicalMemoryQuery)
 	} else {
 		sendModelsToPopulator(modelChan, totalMemResult)
@@ -463,29 +466,36 @@ func processMemoryDBDefinitions(con *connection.SQLConnection, dbName string, mo
 	}
 }
 
-func processDBDefinitions(con *connection.SQLConnection, definitions []*QueryDefinition, modelChan chan<- interface{}) {
+func processDBDefinitions(ctx context.Context, con *connection.SQLConnection, definitions []*QueryDefinition, modelChan chan<- interface{}) {
 	for _, queryDef := range definitions {
-		makeDBQuery(con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
+		makeDBQuery(ctx, con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
 	}
 }
 
-func processDBBufferDefinitions(con *connection.SQLConnection, modelChan chan<- interface{}) {
+func processDBBufferDefinitions(ctx context.Context, con *connection.SQLConnection, modelChan chan<- interface{}) {
 	for _, queryDef := range databaseBufferDefinitions {
-		makeDBQuery(con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
+		makeDBQuery(ctx, con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
 	}
 }
 
-func processSpecificDBDefinitions(con *connection.SQLConnection, dbNames []string, modelChan chan<- interface{}) {
-	for _, queryDef := range specificDatabaseDefinitions {
+func processSpecificDBDefinitions(ctx context.Context, con *connection.SQLConnection, definitions []*QueryDefinition, dbNames []string, modelChan chan<- interface{}) {
+	for _, queryDef := range definitions {
 		for _, dbName := range dbNames {
 			query := queryDef.GetQuery(dbNameReplace(dbName))
-			makeDBQuery(con, query, queryDef.GetDataModels(), modelChan)
+			makeDBQuery(ctx, con, query, queryDef.GetDataModels(), modelChan)
 		}
 	}
 }
 
-func makeDBQuery(con *connection.SQLConnection, query string, models interface{}, modelChan chan<- interface{}) {
-	if err := con.Query(models, query); err != nil {
+func processBackupDefinitions(ctx context.Context, con *connection.SQLConnection, arguments args.ArgumentList, engineEdition int, modelChan chan<- interface{}) {
+	for _, queryDef := range GetQueryDefinitions(BackupQueries, engineEdition) {
+		query := queryDef.GetQuery(logBackupWindowReplace(arguments.LogBackupWindowMinutes))
+		makeDBQuery(ctx, con, query, queryDef.GetDataModels(), modelChan)
+	}
+}
+
+func makeDBQuery(ctx context.Context, con *connection.SQLConnection, query string, models interface{}, modelChan chan<- interface{}) {
+	if err := con.QueryContext(ctx, models, query); err != nil {
 		log.Error("Encountered the following error: %s. Running query '%s'", err.Error(), query)
 		return
 	}
@@ -525,6 +535,21 @@ func dbMetricPopulator(dbSetLookup database.DBMetricSetLookup, modelChan <-chan
 	}
 }
 
+// MarshalEntitySample adds a metric set of eventType to entity populated from model. The set is
+// identified by the entity and the host it was collected from, followed by attributes.
+func MarshalEntitySample(entity *integration.Entity, host, eventType string, model interface{}, attributes ...attribute.Attribute) {
+	attributes = append([]attribute.Attribute{
+		{Key: "displayName", Value: entity.Metadata.Name},
+		{Key: "entityName", Value: entity.Metadata.Namespace + ":" + entity.Metadata.Name},
+		{Key: "host", Value: host},
+	}, attributes...)
+
+	metricSet := entity.NewMetricSet(eventType, attributes...)
+	if err := metricSet.MarshalMetrics(model); err != nil {
+		log.Error("Could not parse %s metrics: %s", eventType, err.Error())
+	}
+}
+
 func DetectMetricType(value string) metric.SourceType {
 	if _, err := strconv.ParseFloat(value, 64); err != nil {
 		return metric.ATTRIBUTE
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"flag"
@@ -209,9 +210,17 @@ func runPopulateDatabaseMetricsTest(
 		connection.CreateDatabaseConnection = originalNewDatabaseConnection
 	}()
 
-	connection.CreateDatabaseConnection = tc.newDatabaseConnection
+	connection.CreateDatabaseConnection = func(args *args.ArgumentList, dbName string) (*connection.SQLConnection, error) {
+		if dbName == "" {
+			return conn, nil
+		}
+		return tc.newDatabaseConnection(args, dbName)
+	}
+
+	manager := connection.NewManager(&tc.args)
+	defer manager.Close()
 
-	assert.NoError(t, PopulateDatabaseMetrics(i, "MSSQL", conn, tc.args, tc.engineEdition))
+	assert.NoError(t, PopulateDatabaseMetrics(context.Background(), i, "MSSQL", manager, tc.args, tc.engineEdition))
 
 	actual, _ := i.MarshalJSON()
 	assert.NoError(t, updateGoldenFile(actual, tc.expectedFile))
@@ -220,7 +229,7 @@ func runPopulateDatabaseMetricsTest(
 
 func setupMockForDatabaseMetrics(mock sqlmock.Sqlmock, logGrowthResp mockResponseType, ioStallsResp mockResponseType, args args.ArgumentList, engineEdition int) {
 	databaseRows := sqlmock.NewRows([]string{"db_name"}).AddRow("db-1").AddRow("db-2")
-	mock.ExpectQuery(`select name as db_name from sys\.databases`).WillReturnRows(databaseRows)
+	mock.ExpectQuery(`select name as db_name, .* from sys\.databases`).WillReturnRows(databaseRows)
 
 	var logGrowthRegex string
 	var ioStallsRegex string
@@ -335,7 +344,7 @@ func setupMockExpectedMetricsForAzureSQLDatabase(mock sqlmock.Sqlmock) {
 		AddRow("db-1").
 		AddRow("db-2")
 
-	mock.ExpectQuery(`select name as db_name from sys\.databases`).
+	mock.ExpectQuery(`select name as db_name, .* from sys\.databases`).
 		WillReturnRows(databaseRows)
 
 	mock.ExpectClose()
@@ -637,7 +646,7 @@ func Test_populateInstanceMetrics(t *testing.T) {
 			defer conn.Close()
 
 			tt.perfCounterSetup(mock)
-			PopulateInstanceMetrics(e, conn, tt.args, tt.engineEditionValue)
+			PopulateInstanceMetrics(context.Background(), e, conn, tt.args, tt.engineEditionValue)
 
 			actual, _ := i.MarshalJSON()
 			assert.NoError(t, updateGoldenFile(actual, tt.expectedFile))
@@ -665,7 +674,7 @@ func Test_populateInstanceMetrics_NoReturn(t *testing.T) {
 
 	engineEdition := 3
 
-	PopulateInstanceMetrics(e, conn, args, engineEdition)
+	PopulateInstanceMetrics(context.Background(), e, conn, args, engineEdition)
 
 	actual, _ := i.MarshalJSON()
 	expectedFile := filepath.Join("..", "testdata", "empty.json.golden")
@@ -674,31 +683,6 @@ func Test_populateInstanceMetrics_NoReturn(t *testing.T) {
 	checkAgainstFile(t, actual, expectedFile)
 }
 
-func Test_populateWaitTimeMetrics(t *testing.T) {
-	i, e := createTestEntity(t)
-
-	conn, mock := connection.CreateMockSQL(t)
-	defer conn.Close()
-
-	waitTimeRows := sqlmock.NewRows([]string{"wait_type", "wait_time", "waiting_tasks_count"}).
-		AddRow("LCK_M_S", 638, 1).
-		AddRow("CHKPT", 1142, 1).
-		AddRow("LAZYWRITER_SLEEP", 1118786296, 1126388).
-		AddRow("PREEMPTIVE_OS_DEVICEOPS", 119, 90)
-
-	// only match the performance counter query
-	mock.ExpectQuery(`SELECT wait_type, wait_time_ms AS wait_time, waiting_tasks_count\s*FROM sys.dm_os_wait_stats wait_stats\s*WHERE wait_time_ms != 0`).WillReturnRows(waitTimeRows)
-	mock.ExpectClose()
-
-	populateWaitTimeMetrics(e, conn)
-
-	actual, _ := i.MarshalJSON()
-	expectedFile := filepath.Join("..", "testdata", "waitTime.json.golden")
-	assert.NoError(t, updateGoldenFile(actual, expectedFile))
-
-	checkAgainstFile(t, actual, expectedFile)
-}
-
 func Test_populateCustomQuery(t *testing.T) { //nolint: funlen
 	cases := []struct {
 		Name             string
@@ -848,7 +832,7 @@ func Test_populateCustomQuery(t *testing.T) { //nolint: funlen
 			conn, mock := connection.CreateMockSQL(t)
 			defer conn.Close()
 			tc.setupMock(mock, tc.cq)
-			populateCustomMetrics(e, conn, tc.cq)
+			populateCustomMetrics(context.Background(), e, conn, tc.cq)
 			actual, _ := i.MarshalJSON()
 			expectedFile := filepath.Join("..", "testdata", tc.expectedFileName)
 			checkAgainstFile(t, actual, expectedFile)
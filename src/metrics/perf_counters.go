@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"gopkg.in/yaml.v2"
+)
+
+// Values of cntr_type in sys.dm_os_performance_counters
+const (
+	perfCounterRawCount      = 65536      // PERF_COUNTER_RAWCOUNT, the current value
+	perfCounterLargeRawCount = 65792      // PERF_COUNTER_LARGE_RAWCOUNT, the current value
+	perfCounterCounter       = 272696320  // PERF_COUNTER_COUNTER, a cumulative count reported per second
+	perfCounterBulkCount     = 272696576  // PERF_COUNTER_BULK_COUNT, a cumulative count reported per second
+	perfLargeRawFraction     = 537003264  // PERF_LARGE_RAW_FRACTION, a ratio of the current value to its base
+	perfAverageBulk          = 1073874176 // PERF_AVERAGE_BULK, the growth of the value divided by the growth of its base
+	perfLargeRawBase         = 1073939712 // PERF_LARGE_RAW_BASE, the base of a fraction or average counter
+)
+
+// perfCounterQuery reads every counter of the objects named in the WHERE clause, so that the base
+// of each selected counter is read along with it
+const perfCounterQuery = `SELECT
+		RTRIM(object_name) AS object_name,
+		RTRIM(counter_name) AS counter_name,
+		RTRIM(instance_name) AS instance_name,
+		cntr_value,
+		cntr_type
+		FROM sys.dm_os_performance_counters WITH (NOLOCK)
+		WHERE %s`
+
+// perfCounterSelector selects counters by the object they belong to, without the
+// "SQLServer:" or "MSSQL$<instance>:" prefix, their name and, optionally, their instance.
+// An empty or "*" instance_name selects every instance of the counter.
+type perfCounterSelector struct {
+	Object   string `yaml:"object_name"`
+	Counter  string `yaml:"counter_name"`
+	Instance string `yaml:"instance_name"`
+}
+
+// perfCounterModel is a row result of perfCounterQuery
+type perfCounterModel struct {
+	ObjectName   string `db:"object_name"`
+	CounterName  string `db:"counter_name"`
+	InstanceName string `db:"instance_name"`
+	Value        int64  `db:"cntr_value"`
+	Type         int64  `db:"cntr_type"`
+}
+
+// object returns the name of the object without the prefix naming the SQL Server instance
+func (m perfCounterModel) object() string {
+	if idx := strings.LastIndex(m.ObjectName, ":"); idx >= 0 {
+		return m.ObjectName[idx+1:]
+	}
+	return m.ObjectName
+}
+
+func (s perfCounterSelector) matches(m perfCounterModel) bool {
+	return strings.EqualFold(s.Object, m.object()) &&
+		strings.EqualFold(s.Counter, m.CounterName) &&
+		(s.Instance == "" || s.Instance == "*" || strings.EqualFold(s.Instance, m.InstanceName))
+}
+
+// PopulatePerfCounterMetrics reports the counters selected in perf_counters_config and perf_counters
+// in a MssqlPerfCounterSample per counter and instance. Values are computed according to the counter
+// type: per second counters as rates, fractions as a percentage of their base and averages from
+// the growth of the counter and its base since the previous run.
+func PopulatePerfCounterMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, configFile, counters string, store *state.Store) {
+	selectors := parsePerfCounterList(counters)
+	if configFile != "" {
+		fileSelectors, err := parsePerfCounterSelectors(configFile)
+		if err != nil {
+			log.Error("Failed to parse performance counters: %s", err)
+			return
+		}
+		selectors = append(selectors, fileSelectors...)
+	}
+	if len(selectors) == 0 {
+		return
+	}
+
+	models := make([]perfCounterModel, 0)
+	if err := connection.QueryContext(ctx, &models, perfCounterQueryFor(selectors)); err != nil {
+		log.Error("Could not execute performance counter query: %s", err.Error())
+		return
+	}
+
+	bases := make(map[string]int64)
+	for _, model := range models {
+		if model.Type == perfLargeRawBase {
+			bases[perfCounterBaseKey(model.object(), model.InstanceName, model.CounterName)] = model.Value
+		}
+	}
+
+	for _, model := range models {
+		if !selected(selectors, model) {
+			continue
+		}
+		populatePerfCounter(instanceEntity, connection.Host, model, bases, store)
+	}
+}
+
+func parsePerfCounterSelectors(configFile string) ([]perfCounterSelector, error) {
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read perf_counters_config: %s", err)
+	}
+	var c struct{ Counters []perfCounterSelector }
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse perf_counters_config: %s", err)
+	}
+
+	selectors := make([]perfCounterSelector, 0, len(c.Counters))
+	for _, selector := range c.Counters {
+		if selector.Object == "" || selector.Counter == "" {
+			log.Warn("Ignoring performance counter without object_name or counter_name: %+v", selector)
+			continue
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// parsePerfCounterList parses the comma separated counters of the perf_counters argument, each
+// written object_name:counter_name or object_name:counter_name:instance_name
+func parsePerfCounterList(counters string) []perfCounterSelector {
+	selectors := make([]perfCounterSelector, 0)
+	for _, entry := range strings.Split(counters, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		fields := strings.SplitN(entry, ":", 3)
+		selector := perfCounterSelector{Object: strings.TrimSpace(fields[0])}
+		if len(fields) > 1 {
+			selector.Counter = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			selector.Instance = strings.TrimSpace(fields[2])
+		}
+		if selector.Object == "" || selector.Counter == "" {
+			log.Warn("Ignoring performance counter %q, expected object_name:counter_name[:instance_name]", entry)
+			continue
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors
+}
+
+// perfCounterQueryFor restricts perfCounterQuery to the objects of selectors
+func perfCounterQueryFor(selectors []perfCounterSelector) string {
+	seen := make(map[string]bool)
+	conditions := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		object := strings.ToLower(selector.Object)
+		if seen[object] {
+			continue
+		}
+		seen[object] = true
+		conditions = append(conditions, fmt.Sprintf("RTRIM(object_name) LIKE '%%:%s'", escapeLike(selector.Object)))
+	}
+	return fmt.Sprintf(perfCounterQuery, strings.Join(conditions, " OR "))
+}
+
+// escapeLike quotes value for use in a LIKE pattern within a string literal
+func escapeLike(value string) string {
+	return strings.NewReplacer("'", "''", "[", "[[]", "%", "[%]", "_", "[_]").Replace(value)
+}
+
+func selected(selectors []perfCounterSelector, model perfCounterModel) bool {
+	for _, selector := range selectors {
+		if selector.matches(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// perfCounterBaseKey identifies the base of a counter. Base counters are named after the counter
+// they belong to with a " base" suffix, and without the " (ms)" unit of the counter if it has one.
+func perfCounterBaseKey(object, instanceName, counterName string) string {
+	name := strings.ToLower(counterName)
+	for _, suffix := range []string{" base", " bs"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	name = strings.TrimSuffix(name, " (ms)")
+	return strings.ToLower(object) + "|" + strings.ToLower(instanceName) + "|" + name
+}
+
+func populatePerfCounter(instanceEntity *integration.Entity, host string, model perfCounterModel, bases map[string]int64, store *state.Store) {
+	var value float64
+	sourceType := metric.GAUGE
+
+	switch model.Type {
+	case perfCounterCounter, perfCounterBulkCount:
+		value = float64(model.Value)
+		sourceType = metric.RATE
+	case perfLargeRawFraction:
+		base, ok := bases[perfCounterBaseKey(model.object(), model.InstanceName, model.CounterName)]
+		if !ok || base == 0 {
+			log.Debug("No base for performance counter %s %s, skipping", model.ObjectName, model.CounterName)
+			return
+		}
+		value = float64(model.Value) * 100 / float64(base)
+	case perfAverageBulk:
+		base, ok := bases[perfCounterBaseKey(model.object(), model.InstanceName, model.CounterName)]
+		if !ok {
+			log.Debug("No base for performance counter %s %s, skipping", model.ObjectName, model.CounterName)
+			return
+		}
+		key := fmt.Sprintf("perfcounter-%s-%s-%s-%s-%s", host, instanceEntity.Metadata.Name, model.ObjectName, model.CounterName, model.InstanceName)
+		delta, ok := store.Delta(key, state.Counters{"value": model.Value, "base": base})
+		if !ok {
+			return
+		}
+		if delta["base"] > 0 {
+			value = float64(delta["value"]) / float64(delta["base"])
+		}
+	case perfCounterRawCount, perfCounterLargeRawCount, perfLargeRawBase:
+		value = float64(model.Value)
+	default:
+		log.Debug("Reporting performance counter %s %s of unknown type %d as its current value", model.ObjectName, model.CounterName, model.Type)
+		value = float64(model.Value)
+	}
+
+	metricSet := instanceEntity.NewMetricSet("MssqlPerfCounterSample",
+		attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "host", Value: host},
+		attribute.Attribute{Key: "object", Value: model.object()},
+		attribute.Attribute{Key: "counter", Value: model.CounterName},
+		attribute.Attribute{Key: "counterInstance", Value: model.InstanceName},
+		attribute.Attribute{Key: "counterType", Value: strconv.FormatInt(model.Type, 10)},
+	)
+	if err := metricSet.SetMetric("perfCounter.value", value, sourceType); err != nil {
+		log.Error("Could not set performance counter %s %s: %s", model.ObjectName, model.CounterName, err.Error())
+	}
+}
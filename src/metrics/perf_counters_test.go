@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const perfCountersConfig = `counters:
+  - object_name: Buffer Manager
+    counter_name: Page life expectancy
+  - object_name: Buffer Manager
+    counter_name: Buffer cache hit ratio
+  - object_name: Databases
+    counter_name: Transactions/sec
+  - object_name: Locks
+    counter_name: Average Wait Time (ms)
+    instance_name: _Total
+  - counter_name: Missing object
+`
+
+var perfCounterColumns = []string{"object_name", "counter_name", "instance_name", "cntr_value", "cntr_type"}
+
+func writePerfCountersConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "perf-counters.yml")
+	require.NoError(t, os.WriteFile(path, []byte(perfCountersConfig), 0o600))
+	return path
+}
+
+func perfCounterRows(lockWaits, lockWaitsBase int64) *sqlmock.Rows {
+	return sqlmock.NewRows(perfCounterColumns).
+		AddRow("SQLServer:Buffer Manager", "Page life expectancy", "", 3600, perfCounterLargeRawCount).
+		AddRow("SQLServer:Buffer Manager", "Buffer cache hit ratio", "", 990, perfLargeRawFraction).
+		AddRow("SQLServer:Buffer Manager", "Buffer cache hit ratio base", "", 1000, perfLargeRawBase).
+		AddRow("SQLServer:Buffer Manager", "Page reads/sec", "", 52000, perfCounterBulkCount).
+		AddRow("SQLServer:Databases", "Transactions/sec", "sales", 120000, perfCounterBulkCount).
+		AddRow("SQLServer:Databases", "Transactions/sec", "hr", 4000, perfCounterBulkCount).
+		AddRow("SQLServer:Locks", "Average Wait Time (ms)", "_Total", lockWaits, perfAverageBulk).
+		AddRow("SQLServer:Locks", "Average Wait Time Base", "_Total", lockWaitsBase, perfLargeRawBase).
+		AddRow("SQLServer:Locks", "Average Wait Time (ms)", "Object", 500, perfAverageBulk)
+}
+
+func perfCounterSample(t *testing.T, samples []map[string]interface{}, counter, counterInstance string) map[string]interface{} {
+	for _, sample := range samples {
+		if sample["counter"] == counter && sample["counterInstance"] == counterInstance {
+			return sample
+		}
+	}
+	t.Fatalf("no sample for %s %s", counter, counterInstance)
+	return nil
+}
+
+func Test_PopulatePerfCounterMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	configFile := writePerfCountersConfig(t)
+	store := state.NewInMemoryStore()
+
+	query := `FROM sys\.dm_os_performance_counters WITH \(NOLOCK\)\s+WHERE RTRIM\(object_name\) LIKE '%:Buffer Manager' OR RTRIM\(object_name\) LIKE '%:Databases' OR RTRIM\(object_name\) LIKE '%:Locks'`
+	mock.ExpectQuery(query).WillReturnRows(perfCounterRows(1000, 100))
+	mock.ExpectQuery(query).WillReturnRows(perfCounterRows(1600, 130))
+
+	PopulatePerfCounterMetrics(context.Background(), e, conn, configFile, "", store)
+	samples := samplesOfType(i, "MssqlPerfCounterSample")
+	require.Len(t, samples, 4, "the average counter is only reported from the second run")
+
+	PopulatePerfCounterMetrics(context.Background(), e, conn, configFile, "", store)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	samples = samplesOfType(i, "MssqlPerfCounterSample")
+	require.Len(t, samples, 9)
+	assert.Equal(t, float64(3600), perfCounterSample(t, samples, "Page life expectancy", "")["perfCounter.value"])
+	assert.Equal(t, float64(99), perfCounterSample(t, samples, "Buffer cache hit ratio", "")["perfCounter.value"])
+	assert.Equal(t, "Buffer Manager", perfCounterSample(t, samples, "Buffer cache hit ratio", "")["object"])
+	assert.Contains(t, perfCounterSample(t, samples, "Transactions/sec", "hr"), "perfCounter.value")
+	assert.Equal(t, float64(20), perfCounterSample(t, samples, "Average Wait Time (ms)", "_Total")["perfCounter.value"])
+}
+
+func Test_PopulatePerfCounterMetrics_List(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`WHERE RTRIM\(object_name\) LIKE '%:Buffer Manager' OR RTRIM\(object_name\) LIKE '%:Databases'\s`).
+		WillReturnRows(perfCounterRows(1000, 100))
+
+	PopulatePerfCounterMetrics(context.Background(), e, conn, "", "Buffer Manager:Page life expectancy, Databases:Transactions/sec:hr", state.NewInMemoryStore())
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	samples := samplesOfType(i, "MssqlPerfCounterSample")
+	require.Len(t, samples, 2)
+	assert.Equal(t, float64(3600), perfCounterSample(t, samples, "Page life expectancy", "")["perfCounter.value"])
+	assert.Contains(t, perfCounterSample(t, samples, "Transactions/sec", "hr"), "perfCounter.value")
+}
+
+func Test_parsePerfCounterList(t *testing.T) {
+	selectors := parsePerfCounterList("Buffer Manager:Page life expectancy,,Databases:Transactions/sec:db:with:colons,Locks")
+	assert.Equal(t, []perfCounterSelector{
+		{Object: "Buffer Manager", Counter: "Page life expectancy"},
+		{Object: "Databases", Counter: "Transactions/sec", Instance: "db:with:colons"},
+	}, selectors, "entries without a counter are ignored")
+}
+
+func Test_perfCounterBaseKey(t *testing.T) {
+	testCases := []struct {
+		counter string
+		base    string
+	}{
+		{"Buffer cache hit ratio", "Buffer cache hit ratio base"},
+		{"Average Latch Wait Time (ms)", "Average Latch Wait Time Base"},
+		{"Avg Disk Read IO (ms)", "Avg Disk Read IO (ms) Base"},
+		{"Avg. Length of Batched Writes", "Avg. Length of Batched Writes BS"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.counter, func(t *testing.T) {
+			assert.Equal(t, perfCounterBaseKey("Object", "", tc.counter), perfCounterBaseKey("Object", "", tc.base))
+		})
+	}
+}
+
+func Test_escapeLike(t *testing.T) {
+	assert.Equal(t, "Workload Group Stats''[%][_][[]", escapeLike("Workload Group Stats'%_["))
+}
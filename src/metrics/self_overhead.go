@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+// selfOverheadQuery sums the work done by the sessions this process opened, which carry the session marker
+const selfOverheadQuery = `SELECT
+		COUNT(*) AS sessions,
+		ISNULL(SUM(CAST(cpu_time AS BIGINT)), 0) AS cpu_time_ms,
+		ISNULL(SUM(logical_reads), 0) AS logical_reads
+		FROM sys.dm_exec_sessions
+		WHERE host_process_id = %d
+		AND CONVERT(VARBINARY(9), context_info) = ` + connection.SessionMarkerHex
+
+type selfOverheadModel struct {
+	Sessions     int64 `db:"sessions"`
+	CPUTimeMs    int64 `db:"cpu_time_ms"`
+	LogicalReads int64 `db:"logical_reads"`
+}
+
+// PopulateSelfOverheadMetrics reports how much the integration's own queries cost the server. CPU time and
+// reads are taken from its sessions, elapsed time is measured by the integration while waiting for results.
+func PopulateSelfOverheadMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, stats *connection.QueryStats) {
+	models := make([]selfOverheadModel, 0)
+	if err := connection.QueryContext(ctx, &models, fmt.Sprintf(selfOverheadQuery, os.Getpid())); err != nil {
+		log.Error("Could not execute self overhead query: %s", err.Error())
+		return
+	}
+	if len(models) != 1 {
+		log.Debug("Expected 1 row from self overhead query, got %d", len(models))
+		return
+	}
+
+	metricSet := instanceEntity.NewMetricSet("MssqlSelfOverheadSample",
+		attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "host", Value: connection.Host},
+	)
+
+	metrics := []struct {
+		metricName  string
+		metricValue int64
+	}{
+		{"selfOverhead.sessions", models[0].Sessions},
+		{"selfOverhead.queries", stats.Queries()},
+		{"selfOverhead.cpuTimeInMilliseconds", models[0].CPUTimeMs},
+		{"selfOverhead.elapsedTimeInMilliseconds", stats.Elapsed().Milliseconds()},
+		{"selfOverhead.logicalReads", models[0].LogicalReads},
+	}
+
+	for _, m := range metrics {
+		if err := metricSet.SetMetric(m.metricName, m.metricValue, metric.GAUGE); err != nil {
+			log.Error("Could not set self overhead metric '%s': %s", m.metricName, err.Error())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_PopulateSelfOverheadMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	original := connection.CreateDatabaseConnection
+	defer func() { connection.CreateDatabaseConnection = original }()
+	connection.CreateDatabaseConnection = func(_ *args.ArgumentList, _ string) (*connection.SQLConnection, error) {
+		return conn, nil
+	}
+	manager := connection.NewManager(&args.ArgumentList{})
+	con, err := manager.Instance()
+	require.NoError(t, err)
+
+	rows := sqlmock.NewRows([]string{"sessions", "cpu_time_ms", "logical_reads"}).AddRow(3, 120, 4500)
+	mock.ExpectQuery(`FROM sys\.dm_exec_sessions\s+WHERE host_process_id = \d+\s+AND CONVERT\(VARBINARY\(9\), context_info\) = 0x6E72692D6D7373716C`).
+		WillReturnRows(rows)
+
+	PopulateSelfOverheadMetrics(context.Background(), e, con, manager.Stats())
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, e.Metrics, 1)
+	sample := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlSelfOverheadSample", sample["event_type"])
+	assert.Equal(t, float64(3), sample["selfOverhead.sessions"])
+	assert.Equal(t, float64(1), sample["selfOverhead.queries"])
+	assert.Equal(t, float64(120), sample["selfOverhead.cpuTimeInMilliseconds"])
+	assert.Equal(t, float64(4500), sample["selfOverhead.logicalReads"])
+	assert.Contains(t, sample, "selfOverhead.elapsedTimeInMilliseconds")
+}
+
+func Test_PopulateSelfOverheadMetrics_QueryError(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.dm_exec_sessions`).WillReturnError(errors.New("permission denied"))
+
+	PopulateSelfOverheadMetrics(context.Background(), e, conn, &connection.QueryStats{})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, e.Metrics)
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+// tempdbSpaceQuery reports how the space of the tempdb data files is used. Allocation contention
+// is measured on the tasks waiting for a latch on a PFS, GAM or SGAM page of tempdb.
+const tempdbSpaceQuery = `SELECT
+		SUM(su.user_object_reserved_page_count) * 8192 AS user_objects,
+		SUM(su.internal_object_reserved_page_count) * 8192 AS internal_objects,
+		SUM(su.version_store_reserved_page_count) * 8192 AS version_store,
+		SUM(su.mixed_extent_page_count) * 8192 AS mixed_extents,
+		SUM(su.unallocated_extent_page_count) * 8192 AS free_space,
+		COUNT(*) AS data_files,
+		contention.waiting_tasks AS allocation_waiting_tasks,
+		contention.wait_time AS allocation_wait_time
+		FROM tempdb.sys.dm_db_file_space_usage su
+		CROSS JOIN (
+			SELECT COUNT(*) AS waiting_tasks, ISNULL(SUM(w.wait_duration_ms), 0) AS wait_time
+			FROM (
+				SELECT wait_duration_ms, TRY_CAST(PARSENAME(REPLACE(resource_description, ':', '.'), 1) AS BIGINT) AS page_id
+				FROM sys.dm_os_waiting_tasks
+				WHERE wait_type LIKE 'PAGELATCH[_]%' AND resource_description LIKE '2:%'
+			) w
+			WHERE w.page_id = 1 OR w.page_id % 8088 = 0 OR (w.page_id - 2) % 511232 = 0 OR (w.page_id - 3) % 511232 = 0
+		) contention
+		GROUP BY contention.waiting_tasks, contention.wait_time`
+
+// tempdbSessionQuery lists the 10 sessions holding the most tempdb space, including the space
+// allocated by their running tasks which is only added to the session when the task ends
+const tempdbSessionQuery = `SELECT TOP 10
+		ss.session_id,
+		es.login_name,
+		es.host_name,
+		es.program_name,
+		(ss.user_objects_alloc_page_count - ss.user_objects_dealloc_page_count + ISNULL(ts.user_alloc, 0) - ISNULL(ts.user_dealloc, 0)) * 8192 AS user_objects,
+		(ss.internal_objects_alloc_page_count - ss.internal_objects_dealloc_page_count + ISNULL(ts.internal_alloc, 0) - ISNULL(ts.internal_dealloc, 0)) * 8192 AS internal_objects
+		FROM sys.dm_db_session_space_usage ss
+		JOIN sys.dm_exec_sessions es ON es.session_id = ss.session_id
+		LEFT JOIN (
+			SELECT session_id,
+				SUM(user_objects_alloc_page_count) AS user_alloc,
+				SUM(user_objects_dealloc_page_count) AS user_dealloc,
+				SUM(internal_objects_alloc_page_count) AS internal_alloc,
+				SUM(internal_objects_dealloc_page_count) AS internal_dealloc
+			FROM sys.dm_db_task_space_usage
+			GROUP BY session_id
+		) ts ON ts.session_id = ss.session_id
+		WHERE ss.database_id = 2
+		AND (ss.user_objects_alloc_page_count - ss.user_objects_dealloc_page_count + ISNULL(ts.user_alloc, 0) - ISNULL(ts.user_dealloc, 0)
+			+ ss.internal_objects_alloc_page_count - ss.internal_objects_dealloc_page_count + ISNULL(ts.internal_alloc, 0) - ISNULL(ts.internal_dealloc, 0)) > 0
+		ORDER BY (ss.user_objects_alloc_page_count - ss.user_objects_dealloc_page_count + ISNULL(ts.user_alloc, 0) - ISNULL(ts.user_dealloc, 0)
+			+ ss.internal_objects_alloc_page_count - ss.internal_objects_dealloc_page_count + ISNULL(ts.internal_alloc, 0) - ISNULL(ts.internal_dealloc, 0)) DESC`
+
+// tempdbSpaceModel is a row result of tempdbSpaceQuery
+type tempdbSpaceModel struct {
+	UserObjects            *int64 `db:"user_objects" metric_name:"tempdb.userObjectsInBytes" source_type:"gauge"`
+	InternalObjects        *int64 `db:"internal_objects" metric_name:"tempdb.internalObjectsInBytes" source_type:"gauge"`
+	VersionStore           *int64 `db:"version_store" metric_name:"tempdb.versionStoreInBytes" source_type:"gauge"`
+	MixedExtents           *int64 `db:"mixed_extents" metric_name:"tempdb.mixedExtentsInBytes" source_type:"gauge"`
+	FreeSpace              *int64 `db:"free_space" metric_name:"tempdb.freeSpaceInBytes" source_type:"gauge"`
+	DataFiles              *int64 `db:"data_files" metric_name:"tempdb.dataFiles" source_type:"gauge"`
+	AllocationWaitingTasks *int64 `db:"allocation_waiting_tasks" metric_name:"tempdb.allocationContention.waitingTasks" source_type:"gauge"`
+	AllocationWaitTime     *int64 `db:"allocation_wait_time" metric_name:"tempdb.allocationContention.waitTimeInMilliseconds" source_type:"gauge"`
+}
+
+// tempdbSessionModel is a row result of tempdbSessionQuery
+type tempdbSessionModel struct {
+	SessionID       int64   `db:"session_id"`
+	LoginName       *string `db:"login_name" metric_name:"loginName" source_type:"attribute"`
+	HostName        *string `db:"host_name" metric_name:"hostName" source_type:"attribute"`
+	ProgramName     *string `db:"program_name" metric_name:"programName" source_type:"attribute"`
+	UserObjects     *int64  `db:"user_objects" metric_name:"tempdb.session.userObjectsInBytes" source_type:"gauge"`
+	InternalObjects *int64  `db:"internal_objects" metric_name:"tempdb.session.internalObjectsInBytes" source_type:"gauge"`
+}
+
+type tempdbCollector func(context.Context, *integration.Entity, *connection.SQLConnection)
+
+// Azure SQL Database does not allow reading the tempdb of the logical server from a user database
+var tempdbCollectorSet = EngineSet[tempdbCollector]{
+	Default:                 collectTempdb,
+	AzureSQLDatabase:        skipTempdb,
+	AzureSQLManagedInstance: collectTempdb,
+}
+
+// PopulateTempdbMetrics reports the space used in tempdb and its allocation contention in a
+// MssqlTempdbSample, and the sessions using the most tempdb space in MssqlTempdbSessionSample
+func PopulateTempdbMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, engineEdition int) {
+	tempdbCollectorSet.Select(engineEdition)(ctx, instanceEntity, connection)
+}
+
+func skipTempdb(context.Context, *integration.Entity, *connection.SQLConnection) {
+	log.Debug("Skipping tempdb metrics, not supported by Azure SQL Database")
+}
+
+func collectTempdb(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection) {
+	spaceModels := make([]tempdbSpaceModel, 0)
+	if err := connection.QueryContext(ctx, &spaceModels, tempdbSpaceQuery); err != nil {
+		log.Error("Could not execute tempdb space query: %s", err.Error())
+	} else if len(spaceModels) == 1 {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlTempdbSample", spaceModels[0])
+	}
+
+	sessionModels := make([]tempdbSessionModel, 0)
+	if err := connection.QueryContext(ctx, &sessionModels, tempdbSessionQuery); err != nil {
+		log.Error("Could not execute tempdb session query: %s", err.Error())
+		return
+	}
+	for _, model := range sessionModels {
+		MarshalEntitySample(instanceEntity, connection.Host, "MssqlTempdbSessionSample", model,
+			attribute.Attribute{Key: "sessionId", Value: strconv.FormatInt(model.SessionID, 10)})
+	}
+}
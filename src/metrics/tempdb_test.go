@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_PopulateTempdbMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM tempdb\.sys\.dm_db_file_space_usage su`).WillReturnRows(
+		sqlmock.NewRows([]string{"user_objects", "internal_objects", "version_store", "mixed_extents", "free_space", "data_files", "allocation_waiting_tasks", "allocation_wait_time"}).
+			AddRow(1048576, 2097152, 524288, 65536, 8388608, 4, 12, 340))
+	mock.ExpectQuery(`SELECT TOP 10\s+ss\.session_id.*FROM sys\.dm_db_session_space_usage ss`).WillReturnRows(
+		sqlmock.NewRows([]string{"session_id", "login_name", "host_name", "program_name", "user_objects", "internal_objects"}).
+			AddRow(57, "etl", "app01", "SSIS", 786432, 1048576).
+			AddRow(61, "report", nil, nil, 0, 65536))
+
+	PopulateTempdbMetrics(context.Background(), e, conn, 3)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, e.Metrics, 3)
+
+	space := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlTempdbSample", space["event_type"])
+	assert.Equal(t, float64(524288), space["tempdb.versionStoreInBytes"])
+	assert.Equal(t, float64(4), space["tempdb.dataFiles"])
+	assert.Equal(t, float64(12), space["tempdb.allocationContention.waitingTasks"])
+	assert.Equal(t, float64(340), space["tempdb.allocationContention.waitTimeInMilliseconds"])
+
+	session := e.Metrics[1].Metrics
+	assert.Equal(t, "MssqlTempdbSessionSample", session["event_type"])
+	assert.Equal(t, "57", session["sessionId"])
+	assert.Equal(t, "etl", session["loginName"])
+	assert.Equal(t, float64(1048576), session["tempdb.session.internalObjectsInBytes"])
+	assert.NotContains(t, e.Metrics[2].Metrics, "hostName")
+}
+
+func Test_PopulateTempdbMetrics_SpaceQueryError(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM tempdb\.sys\.dm_db_file_space_usage`).WillReturnError(errors.New("VIEW SERVER STATE permission was denied"))
+	mock.ExpectQuery(`FROM sys\.dm_db_session_space_usage`).WillReturnRows(
+		sqlmock.NewRows([]string{"session_id", "login_name", "host_name", "program_name", "user_objects", "internal_objects"}))
+
+	PopulateTempdbMetrics(context.Background(), e, conn, database.AzureSQLManagedInstanceEngineEditionNumber)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, e.Metrics)
+}
+
+func Test_PopulateTempdbMetrics_AzureSQLDatabase(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+
+	PopulateTempdbMetrics(context.Background(), e, conn, database.AzureSQLDatabaseEngineEditionNumber)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, e.Metrics)
+}
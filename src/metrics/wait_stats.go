@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// waitTimeQuery reads the cumulative waits of every wait type that waited since SQL Server started
+// or the statistics were cleared
+const waitTimeQuery = `SELECT wait_type, wait_time_ms AS wait_time, signal_wait_time_ms AS signal_wait_time, waiting_tasks_count
+FROM sys.dm_os_wait_stats wait_stats
+WHERE wait_time_ms != 0`
+
+// defaultIgnoredWaitTypes are idle and background waits that grow whether or not SQL Server is
+// busy, so they do not point to a bottleneck. A trailing * matches any wait type with that prefix.
+var defaultIgnoredWaitTypes = []string{
+	"BROKER_EVENTHANDLER", "BROKER_RECEIVE_WAITFOR", "BROKER_TASK_STOP", "BROKER_TO_FLUSH", "BROKER_TRANSMITTER",
+	"CHECKPOINT_QUEUE", "CHKPT", "CLR_AUTO_EVENT", "CLR_MANUAL_EVENT", "CLR_SEMAPHORE",
+	"DIRTY_PAGE_POLL", "DISPATCHER_QUEUE_SEMAPHORE", "FT_IFTS_SCHEDULER_IDLE_WAIT", "FT_IFTSHC_MUTEX",
+	"HADR_CLUSAPI_CALL", "HADR_FILESTREAM_IOMGR_IOCOMPLETION", "HADR_LOGCAPTURE_WAIT", "HADR_NOTIFICATION_DEQUEUE",
+	"HADR_TIMER_TASK", "HADR_WORK_QUEUE", "KSOURCE_WAKEUP", "LAZYWRITER_SLEEP", "LOGMGR_QUEUE",
+	"MEMORY_ALLOCATION_EXT", "ONDEMAND_TASK_QUEUE", "PARALLEL_REDO_*", "PREEMPTIVE_XE_GETTARGETSTATE",
+	"PWAIT_ALL_COMPONENTS_INITIALIZED", "PWAIT_DIRECTLOGCONSUMER_GETNEXT", "QDS_ASYNC_QUEUE",
+	"QDS_CLEANUP_STALE_QUERIES_TASK_MAIN_LOOP_SLEEP", "QDS_PERSIST_TASK_MAIN_LOOP_SLEEP", "QDS_SHUTDOWN_QUEUE",
+	"REDO_THREAD_PENDING_WORK", "REQUEST_FOR_DEADLOCK_SEARCH", "RESOURCE_QUEUE", "SERVER_IDLE_CHECK",
+	"SLEEP_*", "SNI_HTTP_ACCEPT", "SOS_WORK_DISPATCHER", "SP_SERVER_DIAGNOSTICS_SLEEP",
+	"SQLTRACE_BUFFER_FLUSH", "SQLTRACE_INCREMENTAL_FLUSH_SLEEP", "SQLTRACE_WAIT_ENTRIES", "TRACEWRITE",
+	"UCS_SESSION_REGISTRATION", "WAIT_FOR_RESULTS", "WAIT_XTP_CKPT_CLOSE", "WAIT_XTP_HOST_WAIT",
+	"WAIT_XTP_OFFLINE_CKPT_NEW_LOG", "WAIT_XTP_RECOVERY", "WAITFOR", "WAITFOR_TASKSHUTDOWN",
+	"XE_DISPATCHER_JOIN", "XE_DISPATCHER_WAIT", "XE_LIVE_TARGET_TVF", "XE_TIMER_EVENT",
+}
+
+// waitCategoryExact and waitCategoryPrefixes group wait types the way Query Store does in
+// sys.query_store_wait_stats. Exact names are checked before prefixes, and prefixes in order.
+var waitCategoryExact = map[string]string{
+	"SOS_SCHEDULER_YIELD":              "CPU",
+	"THREADPOOL":                       "Worker Thread",
+	"RESOURCE_SEMAPHORE_QUERY_COMPILE": "Compilation",
+	"RESOURCE_SEMAPHORE":               "Memory",
+	"CMEMTHREAD":                       "Memory",
+	"CMEMPARTITIONED":                  "Memory",
+	"EE_PMOLOCK":                       "Memory",
+	"MEMORY_ALLOCATION_EXT":            "Memory",
+	"RESERVED_MEMORY_ALLOCATION_EXT":   "Memory",
+	"MEMORY_GRANT_UPDATE":              "Memory",
+	"LOGBUFFER":                        "Tran Log IO",
+	"LOGMGR":                           "Tran Log IO",
+	"LOGMGR_FLUSH":                     "Tran Log IO",
+	"LOGMGR_PMM_LOG":                   "Tran Log IO",
+	"LOGMGR_RESERVE_APPEND":            "Tran Log IO",
+	"CHKPT":                            "Tran Log IO",
+	"WRITELOG":                         "Tran Log IO",
+	"ASYNC_NETWORK_IO":                 "Network IO",
+	"NET_WAITFOR_PACKET":               "Network IO",
+	"PROXY_NETWORK_IO":                 "Network IO",
+	"EXTERNAL_SCRIPT_NETWORK_IOF":      "Network IO",
+	"CXPACKET":                         "Parallelism",
+	"CXCONSUMER":                       "Parallelism",
+	"EXCHANGE":                         "Parallelism",
+	"WAITFOR":                          "User Wait",
+	"WAIT_FOR_RESULTS":                 "User Wait",
+	"BROKER_RECEIVE_WAITFOR":           "User Wait",
+	"TRACEWRITE":                       "Tracing",
+	"QUERY_TRACEOUT":                   "Tracing",
+	"TRACE_EVTNOTIF":                   "Tracing",
+	"ASYNC_IO_COMPLETION":              "Other Disk IO",
+	"IO_COMPLETION":                    "Other Disk IO",
+	"BACKUPIO":                         "Other Disk IO",
+	"WRITE_COMPLETION":                 "Other Disk IO",
+	"IO_QUEUE_LIMIT":                   "Other Disk IO",
+	"IO_RETRY":                         "Other Disk IO",
+	"REPLICA_WRITES":                   "Replication",
+	"FCB_REPLICA_WRITE":                "Replication",
+	"FCB_REPLICA_READ":                 "Replication",
+	"PWAIT_HADRSIM":                    "Replication",
+	"LOG_RATE_GOVERNOR":                "Log Rate Governor",
+	"POOL_LOG_RATE_GOVERNOR":           "Log Rate Governor",
+	"HADR_THROTTLE_LOG_RATE_GOVERNOR":  "Log Rate Governor",
+	"INSTANCE_LOG_RATE_GOVERNOR":       "Log Rate Governor",
+	"LAZYWRITER_SLEEP":                 "Idle",
+	"SQLTRACE_BUFFER_FLUSH":            "Idle",
+	"SQLTRACE_INCREMENTAL_FLUSH_SLEEP": "Idle",
+	"SQLTRACE_WAIT_ENTRIES":            "Idle",
+	"FT_IFTS_SCHEDULER_IDLE_WAIT":      "Idle",
+	"XE_DISPATCHER_WAIT":               "Idle",
+	"REQUEST_FOR_DEADLOCK_SEARCH":      "Idle",
+	"LOGMGR_QUEUE":                     "Idle",
+	"ONDEMAND_TASK_QUEUE":              "Idle",
+	"CHECKPOINT_QUEUE":                 "Idle",
+	"XE_TIMER_EVENT":                   "Idle",
+}
+
+var waitCategoryPrefixes = []struct {
+	prefix   string
+	category string
+}{
+	{"LCK_M_", "Lock"},
+	{"LATCH_", "Latch"},
+	{"PAGELATCH_", "Buffer Latch"},
+	{"PAGEIOLATCH_", "Buffer IO"},
+	{"SQLCLR", "SQL CLR"},
+	{"CLR", "SQL CLR"},
+	{"DBMIRROR", "Mirroring"},
+	{"XACT", "Transaction"},
+	{"DTC", "Transaction"},
+	{"TRAN_MARKLATCH_", "Transaction"},
+	{"MSQL_XACT_", "Transaction"},
+	{"TRANSACTION_MUTEX", "Transaction"},
+	{"SLEEP_", "Idle"},
+	{"PREEMPTIVE_", "Preemptive"},
+	{"BROKER_", "Service Broker"},
+	{"CXSYNC_", "Parallelism"},
+	{"HT", "Parallelism"},
+	{"BMP", "Parallelism"},
+	{"BP", "Parallelism"},
+	{"SQLTRACE_", "Tracing"},
+	{"FT_", "Full Text Search"},
+	{"MSSEARCH", "Full Text Search"},
+	{"FULLTEXT", "Full Text Search"},
+	{"SE_REPL_", "Replication"},
+	{"REPL_", "Replication"},
+	{"HADR_", "Replication"},
+	{"PWAIT_HADR_", "Replication"},
+}
+
+// waitTimeModel is a row result of waitTimeQuery
+type waitTimeModel struct {
+	WaitType       string `db:"wait_type"`
+	WaitTime       int64  `db:"wait_time"`
+	SignalWaitTime int64  `db:"signal_wait_time"`
+	WaitCount      int64  `db:"waiting_tasks_count"`
+}
+
+// waitDeltaModel holds the growth of the waits of a wait type since the previous run
+type waitDeltaModel struct {
+	WaitTime       int64 `metric_name:"system.waitTimeInMilliseconds" source_type:"gauge"`
+	SignalWaitTime int64 `metric_name:"system.signalWaitTimeInMilliseconds" source_type:"gauge"`
+	ResourceWait   int64 `metric_name:"system.resourceWaitTimeInMilliseconds" source_type:"gauge"`
+	WaitingTasks   int64 `metric_name:"system.waitingTasksCount" source_type:"gauge"`
+	// Deprecated: the cumulative values reported by earlier versions, kept until dashboards and
+	// alerts move to the per interval metrics above
+	TotalWaitTime     int64 `metric_name:"system.waitTimeInMillisecondsPerSecond" source_type:"gauge"`
+	TotalWaitingTasks int64 `metric_name:"system.waitTimeCount" source_type:"gauge"`
+}
+
+// waitCategory returns the category of waitType, or "Unknown" when it does not belong to any
+func waitCategory(waitType string) string {
+	if category, ok := waitCategoryExact[waitType]; ok {
+		return category
+	}
+	for _, p := range waitCategoryPrefixes {
+		if strings.HasPrefix(waitType, p.prefix) {
+			return p.category
+		}
+	}
+	return "Unknown"
+}
+
+// waitTypeMatcher tells whether a wait type is in a list of wait types and wait type prefixes
+type waitTypeMatcher struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+func newWaitTypeMatcher(waitTypes []string) waitTypeMatcher {
+	m := waitTypeMatcher{exact: make(map[string]bool, len(waitTypes))}
+	for _, waitType := range waitTypes {
+		waitType = strings.ToUpper(strings.TrimSpace(waitType))
+		if waitType == "" {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(waitType, "*"); ok {
+			m.prefixes = append(m.prefixes, prefix)
+			continue
+		}
+		m.exact[waitType] = true
+	}
+	return m
+}
+
+func (m waitTypeMatcher) matches(waitType string) bool {
+	if m.exact[waitType] {
+		return true
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(waitType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredWaitTypes returns the wait types listed in ignored_wait_types, or the default list when it is empty
+func ignoredWaitTypes(ignored string) waitTypeMatcher {
+	if strings.TrimSpace(ignored) == "" {
+		return newWaitTypeMatcher(defaultIgnoredWaitTypes)
+	}
+	return newWaitTypeMatcher(strings.Split(ignored, ","))
+}
+
+// PopulateWaitMetrics reports in a MssqlWaitSample per wait type how long tasks waited, how much of
+// it was spent waiting for a CPU once signaled, and how many waits there were since the previous
+// run, along with the deprecated cumulative values. Wait types that did not wait during the interval
+// and the wait types ignored are left out.
+func PopulateWaitMetrics(ctx context.Context, instanceEntity *integration.Entity, connection *connection.SQLConnection, ignored string, store *state.Store) {
+	models := make([]waitTimeModel, 0)
+	if err := connection.QueryContext(ctx, &models, waitTimeQuery); err != nil {
+		log.Error("Could not execute query: %s", err.Error())
+		return
+	}
+
+	ignoredTypes := ignoredWaitTypes(ignored)
+	for _, model := range models {
+		if ignoredTypes.matches(model.WaitType) {
+			continue
+		}
+
+		key := fmt.Sprintf("waits-%s-%s-%s", connection.Host, instanceEntity.Metadata.Name, model.WaitType)
+		delta, ok := store.Delta(key, state.Counters{
+			"waitTime":       model.WaitTime,
+			"signalWaitTime": model.SignalWaitTime,
+			"waitingTasks":   model.WaitCount,
+		})
+		if !ok || (delta["waitTime"] == 0 && delta["waitingTasks"] == 0) {
+			continue
+		}
+
+		metricSet := instanceEntity.NewMetricSet("MssqlWaitSample",
+			attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
+			attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+			attribute.Attribute{Key: "waitType", Value: model.WaitType},
+			attribute.Attribute{Key: "waitCategory", Value: waitCategory(model.WaitType)},
+			attribute.Attribute{Key: "host", Value: connection.Host},
+			attribute.Attribute{Key: "instance", Value: instanceEntity.Metadata.Name},
+		)
+		if err := metricSet.MarshalMetrics(waitDeltaModel{
+			WaitTime:       delta["waitTime"],
+			SignalWaitTime: delta["signalWaitTime"],
+			ResourceWait:   delta["waitTime"] - delta["signalWaitTime"],
+			WaitingTasks:   delta["waitingTasks"],
+
+			TotalWaitTime:     model.WaitTime,
+			TotalWaitingTasks: model.WaitCount,
+		}); err != nil {
+			log.Error("Could not set wait metrics for wait type '%s': %s", model.WaitType, err.Error())
+		}
+	}
+}
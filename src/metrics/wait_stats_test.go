@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var waitTimeColumns = []string{"wait_type", "wait_time", "signal_wait_time", "waiting_tasks_count"}
+
+func Test_PopulateWaitMetrics(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	store := state.NewInMemoryStore()
+
+	query := `SELECT wait_type, wait_time_ms AS wait_time, signal_wait_time_ms AS signal_wait_time, waiting_tasks_count\s*FROM sys.dm_os_wait_stats wait_stats\s*WHERE wait_time_ms != 0`
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(waitTimeColumns).
+		AddRow("LCK_M_S", 638, 10, 1).
+		AddRow("PAGEIOLATCH_SH", 5000, 200, 400).
+		AddRow("LAZYWRITER_SLEEP", 1118786296, 0, 1126388).
+		AddRow("WRITELOG", 119, 5, 90))
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(waitTimeColumns).
+		AddRow("LCK_M_S", 1638, 30, 3).
+		AddRow("PAGEIOLATCH_SH", 5000, 200, 400).
+		AddRow("LAZYWRITER_SLEEP", 1118796296, 0, 1126398).
+		AddRow("WRITELOG", 100, 4, 80).
+		AddRow("SOS_SCHEDULER_YIELD", 20, 20, 15))
+
+	PopulateWaitMetrics(context.Background(), e, conn, "", store)
+	assert.Empty(t, e.Metrics, "nothing is reported before the second run")
+
+	PopulateWaitMetrics(context.Background(), e, conn, "", store)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	actual, _ := i.MarshalJSON()
+	expectedFile := filepath.Join("..", "testdata", "waitTime.json.golden")
+	assert.NoError(t, updateGoldenFile(actual, expectedFile))
+
+	checkAgainstFile(t, actual, expectedFile)
+}
+
+func Test_waitCategory(t *testing.T) {
+	testCases := map[string]string{
+		"SOS_SCHEDULER_YIELD":              "CPU",
+		"LCK_M_IX":                         "Lock",
+		"LATCH_EX":                         "Latch",
+		"PAGELATCH_UP":                     "Buffer Latch",
+		"PAGEIOLATCH_SH":                   "Buffer IO",
+		"ASYNC_NETWORK_IO":                 "Network IO",
+		"RESOURCE_SEMAPHORE":               "Memory",
+		"RESOURCE_SEMAPHORE_QUERY_COMPILE": "Compilation",
+		"CXPACKET":                         "Parallelism",
+		"CXSYNC_PORT":                      "Parallelism",
+		"WRITELOG":                         "Tran Log IO",
+		"PREEMPTIVE_OS_DEVICEOPS":          "Preemptive",
+		"HADR_SYNC_COMMIT":                 "Replication",
+		"HADR_THROTTLE_LOG_RATE_GOVERNOR":  "Log Rate Governor",
+		"SLEEP_TASK":                       "Idle",
+		"VDI_CLIENT_OTHER":                 "Unknown",
+	}
+
+	for waitType, category := range testCases {
+		assert.Equal(t, category, waitCategory(waitType), waitType)
+	}
+}
+
+func Test_ignoredWaitTypes(t *testing.T) {
+	defaults := ignoredWaitTypes("")
+	assert.True(t, defaults.matches("SLEEP_TASK"))
+	assert.True(t, defaults.matches("LAZYWRITER_SLEEP"))
+	assert.False(t, defaults.matches("LCK_M_S"))
+
+	configured := ignoredWaitTypes(" cxpacket, LCK_M_* ")
+	assert.True(t, configured.matches("CXPACKET"))
+	assert.True(t, configured.matches("LCK_M_X"))
+	assert.False(t, configured.matches("SLEEP_TASK"))
+}
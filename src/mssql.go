@@ -2,21 +2,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 
 	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/availabilitygroup"
+	"github.com/newrelic/nri-mssql/src/common"
 	"github.com/newrelic/nri-mssql/src/connection"
 	"github.com/newrelic/nri-mssql/src/database"
 	"github.com/newrelic/nri-mssql/src/instance"
 	"github.com/newrelic/nri-mssql/src/inventory"
 	"github.com/newrelic/nri-mssql/src/metrics"
 	"github.com/newrelic/nri-mssql/src/queryanalysis"
+	"github.com/newrelic/nri-mssql/src/replication"
+	"github.com/newrelic/nri-mssql/src/state"
 )
 
 const (
@@ -59,18 +67,76 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create a new connection
-	con, err := connection.NewConnection(&args)
-	if err != nil {
-		log.Error("Error creating connection to SQL Server: %s", err.Error())
+	store := openStateStore(i, args)
+	defer saveStateStore(store)
+
+	if args.InstancesFile != "" {
+		if err := collectTargets(i, args, store); err != nil {
+			log.Error("Error collecting instances from instances file: %s", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Create the connection manager shared by every collector
+	manager := connection.NewManager(&args)
+	defer manager.Close()
+
+	budget := newBudget(args)
+	if err := collectInstance(i, args, manager, budget, store, nil); err != nil {
+		logCollectionError(args.Hostname, err)
 		os.Exit(1)
 	}
 
+	if err = i.Publish(); err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if args.EnableQueryMonitoring {
+		populateQueryMonitoring(i, args, manager, budget)
+	}
+
+	// Reported last so the cost of query monitoring is included
+	if args.EnableSelfOverheadMetrics {
+		populateSelfOverhead(i, manager)
+		if err = i.Publish(); err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
+
+// newBudget splits the collection timeout across the phases enabled in arguments
+func newBudget(arguments args.ArgumentList) *common.Budget {
+	var phases []common.Phase
+	if arguments.HasInventory() {
+		phases = append(phases, common.PhaseInventory)
+	}
+	if arguments.HasMetrics() {
+		phases = append(phases, common.PhaseDatabaseMetrics, common.PhaseInstanceMetrics)
+	}
+	if arguments.EnableQueryMonitoring {
+		phases = append(phases, common.PhaseQueryMonitoring)
+	}
+	return common.NewBudget(time.Duration(arguments.CollectionTimeout)*time.Second, phases...)
+}
+
+// collectInstance connects to a single SQL Server, creates its instance entity and populates
+// its inventory and metrics. Queries still running when a phase exhausts its share of the
+// budget are cancelled and whatever was gathered is kept.
+func collectInstance(i *integration.Integration, arguments args.ArgumentList, manager *connection.Manager, budget *common.Budget, store *state.Store, labels map[string]string) error {
+	con, err := manager.Instance()
+	if err != nil {
+		return err
+	}
+
 	// Create the entity for the instance
 	instanceEntity, err := instance.CreateInstanceEntity(i, con)
 	if err != nil {
-		log.Error("Unable to create entity for instance: %s", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("unable to create entity for instance: %w", err)
+	}
+	for key, value := range labels {
+		instanceEntity.AddAttributes(attribute.Attribute{Key: "label." + key, Value: value})
 	}
 
 	// Get EngineEdition
@@ -81,28 +147,115 @@ func main() {
 	}
 
 	// Inventory collection
-	if args.HasInventory() {
-		inventory.PopulateInventory(instanceEntity, con, engineEdition)
+	if arguments.HasInventory() {
+		ctx, cancel := budget.Start(context.Background(), common.PhaseInventory)
+		inventory.PopulateInventory(ctx, instanceEntity, con, engineEdition)
+		cancel()
 	}
 
 	// Metric collection
-	if args.HasMetrics() {
-		if err := metrics.PopulateDatabaseMetrics(i, instanceEntity.Metadata.Name, con, args, engineEdition); err != nil {
+	if arguments.HasMetrics() {
+		ctx, cancel := budget.Start(context.Background(), common.PhaseDatabaseMetrics)
+		if err := metrics.PopulateDatabaseMetrics(ctx, i, instanceEntity.Metadata.Name, manager, arguments, engineEdition); err != nil {
 			log.Error("Error collecting metrics for databases: %s", err.Error())
 		}
+		if arguments.EnableFileIOMetrics {
+			metrics.PopulateFileIOMetrics(ctx, i, instanceEntity.Metadata.Name, con, store, engineEdition)
+		}
+		if arguments.EnableIndexAdvisorMetrics {
+			metrics.PopulateIndexAdvisorMetrics(ctx, i, instanceEntity.Metadata.Name, manager, arguments, store, engineEdition)
+		}
+		cancel()
 
-		metrics.PopulateInstanceMetrics(instanceEntity, con, args, engineEdition)
+		ctx, cancel = budget.Start(context.Background(), common.PhaseInstanceMetrics)
+		metrics.PopulateInstanceMetrics(ctx, instanceEntity, con, arguments, engineEdition)
+		metrics.PopulateWaitMetrics(ctx, instanceEntity, con, arguments.IgnoredWaitTypes, store)
+		if arguments.EnableAgentJobMetrics {
+			metrics.PopulateAgentJobMetrics(ctx, instanceEntity, con, engineEdition)
+		}
+		if arguments.EnableCPUMetrics {
+			metrics.PopulateCPUMetrics(ctx, instanceEntity, con, store, engineEdition)
+		}
+		if arguments.EnableDeadlockMetrics {
+			metrics.PopulateDeadlockMetrics(ctx, instanceEntity, con, arguments.DeadlockTarget, store, engineEdition)
+		}
+		if arguments.EnableErrorLogMetrics {
+			metrics.PopulateErrorLogMetrics(ctx, instanceEntity, con, arguments, store, engineEdition)
+		}
+		if arguments.EnableMemoryPressureMetrics {
+			metrics.PopulateMemoryMetrics(ctx, instanceEntity, con)
+		}
+		if arguments.EnableTempdbMetrics {
+			metrics.PopulateTempdbMetrics(ctx, instanceEntity, con, engineEdition)
+		}
+		if len(arguments.PerfCountersConfig) > 0 || len(arguments.PerfCounters) > 0 {
+			metrics.PopulatePerfCounterMetrics(ctx, instanceEntity, con, arguments.PerfCountersConfig, arguments.PerfCounters, store)
+		}
+		if arguments.EnableAvailabilityGroupMetrics {
+			availabilitygroup.PopulateAvailabilityGroupMetrics(ctx, i, con, engineEdition)
+		}
+		if arguments.EnableReplicationMetrics {
+			replication.PopulateReplicationMetrics(ctx, i, con, engineEdition)
+		}
+		cancel()
 	}
 
-	// Close connection when done
-	defer con.Close()
+	return nil
+}
 
-	if err = i.Publish(); err != nil {
-		log.Error(err.Error())
+// openStateStore opens the store that keeps counters between runs. When it cannot be opened the
+// values only last for this run, so per-interval metrics are not reported.
+func openStateStore(i *integration.Integration, arguments args.ArgumentList) *state.Store {
+	store, err := state.Open(i.CreateUniqueID(), arguments.TempDir, arguments.CacheTTL)
+	if err != nil {
+		log.Warn("Per-interval metrics will not be reported: %s", err.Error())
+		return state.NewInMemoryStore()
+	}
+	return store
+}
+
+// saveStateStore persists the counters sampled during this run for the next one
+func saveStateStore(store *state.Store) {
+	if err := store.Save(); err != nil {
+		log.Error("Unable to save state store: %s", err.Error())
+	}
+}
+
+// populateQueryMonitoring runs query analysis within the query monitoring share of budget
+func populateQueryMonitoring(i *integration.Integration, arguments args.ArgumentList, manager *connection.Manager, budget *common.Budget) {
+	ctx, cancel := budget.Start(context.Background(), common.PhaseQueryMonitoring)
+	defer cancel()
+
+	queryanalysis.PopulateQueryPerformanceMetrics(ctx, i, arguments, manager)
+}
+
+// populateSelfOverhead reports the cost of the queries run through the connections of manager
+func populateSelfOverhead(i *integration.Integration, manager *connection.Manager) {
+	con, err := manager.Instance()
+	if err != nil {
+		log.Error("Unable to report self overhead: %s", err.Error())
 		return
 	}
 
-	if args.EnableQueryMonitoring {
-		queryanalysis.PopulateQueryPerformanceMetrics(i, args)
+	// entities were cleared by the previous publish
+	instanceEntity, err := instance.CreateInstanceEntity(i, con)
+	if err != nil {
+		log.Error("Unable to report self overhead, could not create entity for instance: %s", err.Error())
+		return
+	}
+
+	metrics.PopulateSelfOverheadMetrics(context.Background(), instanceEntity, con, manager.Stats())
+}
+
+// logCollectionError logs why an instance could not be collected, pointing at the configuration
+// to check for failures that retrying will not fix
+func logCollectionError(host string, err error) {
+	switch {
+	case errors.Is(err, connection.ErrAuthFailure):
+		log.Error("Authentication to SQL Server %s failed, check the configured credentials: %s", host, err.Error())
+	case errors.Is(err, connection.ErrTLSFailure):
+		log.Error("TLS negotiation with SQL Server %s failed, check the SSL and certificate settings: %s", host, err.Error())
+	default:
+		log.Error("Error collecting SQL Server %s: %s", host, err.Error())
 	}
 }
@@ -1,6 +1,9 @@
 package config
 
-import "github.com/newrelic/nri-mssql/src/queryanalysis/models"
+import (
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/queryanalysis/models"
+)
 
 // Documentation: https:https://newrelic.atlassian.net/wiki/x/SYFq6g
 // The above link contains all the queries, data models, and query details for QueryAnalysis.
@@ -53,6 +56,7 @@ WITH AggregatedStats AS (
         AND pa.attribute = 'dbid'
         AND qt.text IS NOT NULL
         AND LTRIM(RTRIM(qt.text)) <> ''
+        AND qt.text NOT LIKE '%%` + connection.StatementMarker + `%%'  -- Skip the integration's own queries
         AND EXISTS (
             SELECT 1
             FROM sys.databases d WITH (NOLOCK)
@@ -166,6 +170,7 @@ OPTION (RECOMPILE, MAXDOP 1)`,
 					WHERE 
 					  qsqt.query_sql_text NOT LIKE ''%%sys.%%''
 					  AND qsqt.query_sql_text NOT LIKE ''%%INFORMATION_SCHEMA%%''
+					  AND qsqt.query_sql_text NOT LIKE ''%%` + connection.StatementMarker + `%%'' -- Skip the integration's own queries
 					GROUP BY 
 					  qsqt.query_sql_text 
 				  ),
@@ -201,6 +206,7 @@ OPTION (RECOMPILE, MAXDOP 1)`,
 					  qsqt.query_sql_text NOT LIKE ''%%WITH%%''
 					  AND qsqt.query_sql_text NOT LIKE ''%%sys.%%''
 					  AND qsqt.query_sql_text NOT LIKE ''%%INFORMATION_SCHEMA%%''
+					  AND qsqt.query_sql_text NOT LIKE ''%%` + connection.StatementMarker + `%%'' -- Skip the integration's own queries
 				  )
 				  SELECT
 					query_id,
@@ -249,6 +255,7 @@ OPTION (RECOMPILE, MAXDOP 1)`,
 					LEFT JOIN sys.dm_exec_sessions AS sess ON sess.session_id = req.session_id
 					WHERE
 						req.blocking_session_id != 0
+						AND ISNULL(CONVERT(VARBINARY(9), sess.context_info), 0x) <> ` + connection.SessionMarkerHex + ` -- Skip the integration's own sessions
 				)
 				SELECT TOP (@Limit)
 					blocking_info.blocking_spid,
@@ -303,6 +310,7 @@ var Queries = []models.QueryDetailsDto{
 					LEFT JOIN sys.dm_exec_sessions AS sess ON sess.session_id = req.session_id
 					WHERE
 						req.blocking_session_id != 0
+						AND ISNULL(CONVERT(VARBINARY(9), sess.context_info), 0x) <> ` + connection.SessionMarkerHex + ` -- Skip the integration's own sessions
 				)
 				SELECT TOP (@Limit)
 					blocking_info.blocking_spid,
@@ -351,6 +359,7 @@ var Queries = []models.QueryDetailsDto{
 					AND r.wait_time > 0        -- Ignore if wait time is 0
 					AND r.database_id > 4      -- Ignore system databases (master, model, msdb, tempdb)
 					AND r.wait_type IS NOT NULL -- Only show sessions currently waiting
+					AND ISNULL(CONVERT(VARBINARY(9), r.context_info), 0x) <> ` + connection.SessionMarkerHex + ` -- Skip the integration's own sessions
 				`,
 		Type: "waitAnalysis",
 	},
@@ -401,6 +410,7 @@ WITH AggregatedStats AS (
         AND pa.attribute = 'dbid'
         AND qt.text IS NOT NULL
         AND LTRIM(RTRIM(qt.text)) <> ''
+        AND qt.text NOT LIKE '%%` + connection.StatementMarker + `%%'  -- Skip the integration's own queries
         AND EXISTS (
             SELECT 1
             FROM sys.databases d WITH (NOLOCK)
@@ -1,6 +1,8 @@
 package queryanalysis
 
 import (
+	"context"
+
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-mssql/src/args"
@@ -12,16 +14,15 @@ import (
 )
 
 // queryPerformanceMain runs all types of analyzes
-func PopulateQueryPerformanceMetrics(integration *integration.Integration, arguments args.ArgumentList) {
-	// Create a new connection
+func PopulateQueryPerformanceMetrics(ctx context.Context, integration *integration.Integration, arguments args.ArgumentList, manager *connection.Manager) {
+	// Reuse the pooled instance connection
 	log.Debug("Starting query analysis...")
 
-	sqlConnection, err := connection.NewConnection(&arguments)
+	sqlConnection, err := manager.Instance()
 	if err != nil {
 		log.Error("Error creating connection to SQL Server: %s", err.Error())
 		return
 	}
-	defer sqlConnection.Close()
 
 	// Validate preconditions
 	isPreconditionPassed := validation.ValidatePreConditions(sqlConnection, arguments.QueryMonitoringDisableHistoricalInformation)
@@ -43,12 +44,16 @@ func PopulateQueryPerformanceMetrics(integration *integration.Integration, argum
 		return
 	}
 
-	for _, queryDetailsDto := range queryDetails {
+	for idx, queryDetailsDto := range queryDetails {
+		if ctx.Err() != nil {
+			log.Warn("Time budget exhausted, skipping %d remaining query analyses", len(queryDetails)-idx)
+			break
+		}
 		var queryResults []interface{}
 		if arguments.QueryMonitoringDisableHistoricalInformation {
-			queryResults, err = utils.ExecuteQueryWithoutHistoricalInformation(arguments, queryDetailsDto, integration, sqlConnection)
+			queryResults, err = utils.ExecuteQueryWithoutHistoricalInformation(ctx, arguments, queryDetailsDto, integration, sqlConnection)
 		} else {
-			queryResults, err = utils.ExecuteQuery(arguments, queryDetailsDto, integration, sqlConnection)
+			queryResults, err = utils.ExecuteQuery(ctx, arguments, queryDetailsDto, integration, sqlConnection)
 		}
 		if err != nil {
 			log.Error("Failed to execute query: %s", err)
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -115,9 +116,9 @@ func LoadQueries(queries []models.QueryDetailsDto, arguments args.ArgumentList)
 	return loadedQueries, nil
 }
 
-func ExecuteQuery(arguments args.ArgumentList, queryDetailsDto models.QueryDetailsDto, integration *integration.Integration, sqlConnection *connection.SQLConnection) ([]interface{}, error) {
+func ExecuteQuery(ctx context.Context, arguments args.ArgumentList, queryDetailsDto models.QueryDetailsDto, integration *integration.Integration, sqlConnection *connection.SQLConnection) ([]interface{}, error) {
 	log.Debug("Executing query: %s", queryDetailsDto.Query)
-	rows, err := sqlConnection.Connection.Queryx(queryDetailsDto.Query)
+	rows, err := sqlConnection.QueryxContext(ctx, queryDetailsDto.Query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -128,14 +129,14 @@ func ExecuteQuery(arguments args.ArgumentList, queryDetailsDto models.QueryDetai
 
 	// Process collected query IDs for execution plan
 	if len(queryIDs) > 0 {
-		ProcessExecutionPlans(arguments, integration, sqlConnection, queryIDs)
+		ProcessExecutionPlans(ctx, arguments, integration, sqlConnection, queryIDs)
 	}
 	return result, err
 }
 
-func ExecuteQueryWithoutHistoricalInformation(arguments args.ArgumentList, queryDetailsDto models.QueryDetailsDto, integration *integration.Integration, sqlConnection *connection.SQLConnection) ([]interface{}, error) {
+func ExecuteQueryWithoutHistoricalInformation(ctx context.Context, arguments args.ArgumentList, queryDetailsDto models.QueryDetailsDto, integration *integration.Integration, sqlConnection *connection.SQLConnection) ([]interface{}, error) {
 	log.Debug("Executing query: %s", queryDetailsDto.Query)
-	rows, err := sqlConnection.Connection.Queryx(queryDetailsDto.Query)
+	rows, err := sqlConnection.QueryxContext(ctx, queryDetailsDto.Query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -146,7 +147,7 @@ func ExecuteQueryWithoutHistoricalInformation(arguments args.ArgumentList, query
 
 	// Process collected query IDs for execution plan
 	if len(queryIDs) > 0 {
-		ProcessExecutionPlans(arguments, integration, sqlConnection, queryIDs)
+		ProcessExecutionPlans(ctx, arguments, integration, sqlConnection, queryIDs)
 	}
 	return result, err
 }
@@ -359,7 +360,7 @@ func BindQueryResultsWithoutHistoricalInformation(arguments args.ArgumentList,
 }
 
 // ProcessExecutionPlans processes execution plans for all collected queryIDs
-func ProcessExecutionPlans(arguments args.ArgumentList, integration *integration.Integration, sqlConnection *connection.SQLConnection, queryIDs []models.HexString) {
+func ProcessExecutionPlans(ctx context.Context, arguments args.ArgumentList, integration *integration.Integration, sqlConnection *connection.SQLConnection, queryIDs []models.HexString) {
 	if len(queryIDs) == 0 {
 		return
 	}
@@ -371,16 +372,16 @@ func ProcessExecutionPlans(arguments args.ArgumentList, integration *integration
 	// Join the converted string slice into a comma-separated list
 	queryIDString := strings.Join(stringIDs, ",")
 
-	GenerateAndIngestExecutionPlan(arguments, integration, sqlConnection, queryIDString)
+	GenerateAndIngestExecutionPlan(ctx, arguments, integration, sqlConnection, queryIDString)
 }
 
-func GenerateAndIngestExecutionPlan(arguments args.ArgumentList, integration *integration.Integration, sqlConnection *connection.SQLConnection, queryIDString string) {
+func GenerateAndIngestExecutionPlan(ctx context.Context, arguments args.ArgumentList, integration *integration.Integration, sqlConnection *connection.SQLConnection, queryIDString string) {
 	executionPlanQuery := fmt.Sprintf(config.ExecutionPlanQueryTemplate, min(config.IndividualQueryCountMax, arguments.QueryMonitoringCountThreshold),
 		arguments.QueryMonitoringResponseTimeThreshold, queryIDString, arguments.QueryMonitoringFetchInterval*2, config.TextTruncateLimit)
 
 	var model models.ExecutionPlanResult
 
-	rows, err := sqlConnection.Connection.Queryx(executionPlanQuery)
+	rows, err := sqlConnection.QueryxContext(ctx, executionPlanQuery)
 	if err != nil {
 		log.Error("Failed to execute execution plan query: %s", err)
 		return
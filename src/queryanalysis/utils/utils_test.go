@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
@@ -60,7 +61,7 @@ func TestGenerateAndIngestExecutionPlan_Success(t *testing.T) {
 	queryIDString := "0102"
 
 	// Call your actual function
-	GenerateAndIngestExecutionPlan(argList, integrationObj, sqlConn, queryIDString)
+	GenerateAndIngestExecutionPlan(context.Background(), argList, integrationObj, sqlConn, queryIDString)
 
 	// Verifying all expectations met ensures your mock was correct.
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -80,7 +81,7 @@ func TestGenerateAndIngestExecutionPlan_QueryError(t *testing.T) {
 	queryIDString := "0102"
 
 	// Call the function
-	GenerateAndIngestExecutionPlan(argList, integrationObj, sqlConn, queryIDString)
+	GenerateAndIngestExecutionPlan(context.Background(), argList, integrationObj, sqlConn, queryIDString)
 
 	// Ensure all expectations are met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -127,7 +128,7 @@ func TestProcessExecutionPlans_Success(t *testing.T) {
 	queryIDs := []models.HexString{"0x0102"}
 
 	// Call the target function
-	ProcessExecutionPlans(argList, integrationObj, sqlConn, queryIDs)
+	ProcessExecutionPlans(context.Background(), argList, integrationObj, sqlConn, queryIDs)
 
 	// Ensure all expectations are met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -151,7 +152,7 @@ func TestProcessExecutionPlans_NoQueryIDs(t *testing.T) {
 	queryIDs := []models.HexString{} // Empty query IDs
 
 	// Call the function, which should ideally do nothing
-	ProcessExecutionPlans(argList, integrationObj, sqlConn, queryIDs)
+	ProcessExecutionPlans(context.Background(), argList, integrationObj, sqlConn, queryIDs)
 
 	// Verify that no SQL expectations were set (and consequently met)
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -186,7 +187,7 @@ func TestExecuteQuery_SlowQueriesSuccess(t *testing.T) {
 	}
 	argList := args.ArgumentList{}
 
-	results, err := ExecuteQuery(argList, queryDetails, integrationObj, sqlConn)
+	results, err := ExecuteQuery(context.Background(), argList, queryDetails, integrationObj, sqlConn)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -242,7 +243,7 @@ func TestExecuteQuery_WaitTimeAnalysis(t *testing.T) {
 	}
 	argList := args.ArgumentList{}
 
-	results, err := ExecuteQueryWithoutHistoricalInformation(argList, queryDetails, integrationObj, sqlConn)
+	results, err := ExecuteQueryWithoutHistoricalInformation(context.Background(), argList, queryDetails, integrationObj, sqlConn)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -312,7 +313,7 @@ func TestExecuteQuery_BlockingSessionsSuccess(t *testing.T) {
 	}
 	argList := args.ArgumentList{}
 
-	results, err := ExecuteQuery(argList, queryDetails, integrationObj, sqlConn)
+	results, err := ExecuteQuery(context.Background(), argList, queryDetails, integrationObj, sqlConn)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -356,6 +357,28 @@ func checkStringField(t *testing.T, name string, field *string, expected string)
 	}
 }
 
+func TestLoadQueries_ExcludeOwnSessions(t *testing.T) {
+	arguments := args.ArgumentList{QueryMonitoringFetchInterval: 15, QueryMonitoringCountThreshold: 20}
+	ownPlans := "NOT LIKE '%" + connection.StatementMarker + "%'"
+	ownSessions := "<> " + connection.SessionMarkerHex
+
+	withHistory, err := LoadQueries(config.QueriesWithHistoricalInformation, arguments)
+	assert.NoError(t, err)
+	withoutHistory, err := LoadQueriesWithoutHistoricalInformation(config.Queries, arguments)
+	assert.NoError(t, err)
+
+	for _, query := range append(withHistory, withoutHistory...) {
+		switch query.Type {
+		case "slowQueries":
+			assert.Contains(t, query.Query, ownPlans)
+		case "blockingSessions":
+			assert.Contains(t, query.Query, ownSessions)
+		}
+	}
+	assert.Contains(t, withoutHistory[1].Query, ownSessions, "current wait analysis")
+	assert.Contains(t, withHistory[1].Query, "NOT LIKE ''%"+connection.StatementMarker+"%''", "historical wait analysis")
+}
+
 func TestLoadQueries_SlowQueries(t *testing.T) {
 	configQueries := config.Queries
 	var arguments args.ArgumentList
@@ -0,0 +1,270 @@
+// Package replication collects the state of the transactional publications distributed by the instance
+package replication
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/metrics"
+)
+
+// publicationNamespace is the entity namespace of the publications
+const publicationNamespace = "ms-replication-publication"
+
+// distributionDatabaseQuery lists the distribution databases of the instance. There is usually one,
+// named distribution, but a distributor can have several with any name.
+const distributionDatabaseQuery = `SELECT name FROM sys.databases WHERE is_distributor = 1 AND state = 0`
+
+// subscriptionQuery asks the replication monitor of a distribution database for the status,
+// warnings and latency of every transactional subscription. Its result set has many more columns,
+// which vary between versions.
+const subscriptionQuery = `EXEC %s.sys.sp_replmonitorhelpsubscription @publisher = NULL, @publication_type = 0`
+
+// distributionAgentQuery reads the last action logged by each distribution agent and the commands
+// in the distribution database not yet delivered to its subscriber. Virtual subscriptions, with a
+// negative subscriber_id, are left out.
+const distributionAgentQuery = `SELECT
+		da.name AS agent_name,
+		h.delivery_latency,
+		h.current_delivery_rate,
+		DATEDIFF(SECOND, h.[time], GETDATE()) AS seconds_since_last_action,
+		ISNULL(st.undistributed_commands, 0) AS undistributed_commands
+		FROM %[1]s.dbo.MSdistribution_agents da
+		OUTER APPLY (
+			SELECT TOP 1 delivery_latency, current_delivery_rate, [time]
+			FROM %[1]s.dbo.MSdistribution_history WITH (NOLOCK)
+			WHERE agent_id = da.id
+			ORDER BY [timestamp] DESC
+		) h
+		OUTER APPLY (
+			SELECT SUM(UndelivCmdsInDistDB) AS undistributed_commands
+			FROM %[1]s.dbo.MSdistribution_status WITH (NOLOCK)
+			WHERE agent_id = da.id
+		) st
+		WHERE da.subscriber_id >= 0`
+
+// agentStatuses are the values of the status column of sp_replmonitorhelpsubscription
+var agentStatuses = map[int64]string{
+	1: "Started",
+	2: "Succeeded",
+	3: "In progress",
+	4: "Idle",
+	5: "Retrying",
+	6: "Failed",
+}
+
+const agentStatusFailed = 6
+
+// SubscriptionRow is a row result of the subscriptionQuery. warning is a bitmask of the thresholds
+// exceeded, such as 2 for latency or 4 for an agent that is not running.
+type SubscriptionRow struct {
+	Publisher       string  `db:"publisher"`
+	PublisherDB     string  `db:"publisher_db"`
+	Publication     string  `db:"publication"`
+	Subscriber      string  `db:"subscriber"`
+	SubscriberDB    string  `db:"subscriber_db"`
+	AgentName       *string `db:"distribution_agentname"`
+	Status          *int64  `db:"status" metric_name:"subscription.agentStatusCode" source_type:"gauge"`
+	Warning         *int64  `db:"warning" metric_name:"subscription.warning" source_type:"gauge"`
+	Latency         *int64  `db:"latency" metric_name:"subscription.latencyInSeconds" source_type:"gauge"`
+	AgentNotRunning *int64  `db:"agentnotrunning" metric_name:"subscription.agentNotRunningInHours" source_type:"gauge"`
+	AgentStatus     *string `metric_name:"subscription.agentStatus" source_type:"attribute"`
+}
+
+// DistributionAgentRow is a row result of the distributionAgentQuery
+type DistributionAgentRow struct {
+	AgentName              string   `db:"agent_name"`
+	DeliveryLatency        *int64   `db:"delivery_latency" metric_name:"subscription.deliveryLatencyInMilliseconds" source_type:"gauge"`
+	DeliveryRate           *float64 `db:"current_delivery_rate" metric_name:"subscription.deliveryRateCommandsPerSecond" source_type:"gauge"`
+	SecondsSinceLastAction *int64   `db:"seconds_since_last_action" metric_name:"subscription.secondsSinceLastAgentAction" source_type:"gauge"`
+	UndistributedCommands  *int64   `db:"undistributed_commands" metric_name:"subscription.undistributedCommands" source_type:"gauge"`
+}
+
+// subscriptionSample is a MssqlReplicationSubscriptionSample, the agent is nil when it has no row
+type subscriptionSample struct {
+	*SubscriptionRow
+	*DistributionAgentRow
+}
+
+// publicationSample is a MssqlReplicationPublicationSample, summarizing the subscriptions of a publication
+type publicationSample struct {
+	Subscriptions         int64 `metric_name:"publication.subscriptions" source_type:"gauge"`
+	FailedSubscriptions   int64 `metric_name:"publication.failedSubscriptions" source_type:"gauge"`
+	WarningSubscriptions  int64 `metric_name:"publication.subscriptionsWithWarnings" source_type:"gauge"`
+	MaxLatency            int64 `metric_name:"publication.maxLatencyInSeconds" source_type:"gauge"`
+	UndistributedCommands int64 `metric_name:"publication.undistributedCommands" source_type:"gauge"`
+}
+
+type replicationCollector func(context.Context, *integration.Integration, *connection.SQLConnection) error
+
+// Azure SQL Database cannot be a distributor
+var collectorSet = metrics.EngineSet[replicationCollector]{
+	Default:                 collectReplication,
+	AzureSQLDatabase:        skipReplication,
+	AzureSQLManagedInstance: collectReplication,
+}
+
+// PopulateReplicationMetrics creates an entity for each transactional publication distributed by
+// the instance and reports the status, latency and undistributed commands of its subscriptions.
+// Publishers with a remote distributor are reported by the distributor.
+func PopulateReplicationMetrics(ctx context.Context, i *integration.Integration, con *connection.SQLConnection, engineEdition int) {
+	if err := collectorSet.Select(engineEdition)(ctx, i, con); err != nil {
+		log.Error("Could not collect replication metrics: %s", err.Error())
+	}
+}
+
+func skipReplication(context.Context, *integration.Integration, *connection.SQLConnection) error {
+	log.Debug("Skipping replication metrics, not supported by Azure SQL Database")
+	return nil
+}
+
+func collectReplication(ctx context.Context, i *integration.Integration, con *connection.SQLConnection) error {
+	distributionDatabases := make([]string, 0)
+	if err := con.QueryContext(ctx, &distributionDatabases, distributionDatabaseQuery); err != nil {
+		return err
+	}
+	for _, distributionDB := range distributionDatabases {
+		if err := collectDistributionDatabase(ctx, i, con, distributionDB); err != nil {
+			log.Error("Could not collect replication metrics of distribution database '%s': %s", distributionDB, err.Error())
+		}
+	}
+	return nil
+}
+
+func collectDistributionDatabase(ctx context.Context, i *integration.Integration, con *connection.SQLConnection, distributionDB string) error {
+	quotedDB := quoteIdentifier(distributionDB)
+
+	subscriptions, err := querySubscriptions(ctx, con, fmt.Sprintf(subscriptionQuery, quotedDB))
+	if err != nil {
+		return err
+	}
+
+	agents := make([]*DistributionAgentRow, 0)
+	if err := con.QueryContext(ctx, &agents, fmt.Sprintf(distributionAgentQuery, quotedDB)); err != nil {
+		return err
+	}
+	agentsByName := make(map[string]*DistributionAgentRow, len(agents))
+	for _, agent := range agents {
+		agentsByName[agent.AgentName] = agent
+	}
+
+	// the first subscription of each publication names it in its MssqlReplicationPublicationSample
+	publications := make(map[*integration.Entity]*publicationSample)
+	firstSubscriptions := make(map[*integration.Entity]*SubscriptionRow)
+	for _, subscription := range subscriptions {
+		entity, err := publicationEntity(i, con.Host, subscription)
+		if err != nil {
+			return err
+		}
+
+		sample := subscriptionSample{SubscriptionRow: subscription}
+		if subscription.AgentName != nil {
+			sample.DistributionAgentRow = agentsByName[*subscription.AgentName]
+		}
+		if subscription.Status != nil {
+			if status, ok := agentStatuses[*subscription.Status]; ok {
+				sample.AgentStatus = &status
+			}
+		}
+		attributes := append(publicationAttributes(distributionDB, subscription),
+			attribute.Attribute{Key: "subscriber", Value: subscription.Subscriber},
+			attribute.Attribute{Key: "subscriberDatabase", Value: subscription.SubscriberDB})
+		metrics.MarshalEntitySample(entity, con.Host, "MssqlReplicationSubscriptionSample", sample, attributes...)
+
+		publication, ok := publications[entity]
+		if !ok {
+			publication = &publicationSample{}
+			publications[entity] = publication
+			firstSubscriptions[entity] = subscription
+		}
+		publication.add(sample)
+	}
+
+	for entity, publication := range publications {
+		metrics.MarshalEntitySample(entity, con.Host, "MssqlReplicationPublicationSample", publication,
+			publicationAttributes(distributionDB, firstSubscriptions[entity])...)
+	}
+	return nil
+}
+
+// subscriptionColumns maps the columns of sp_replmonitorhelpsubscription to the fields of SubscriptionRow
+var subscriptionColumns = reflectx.NewMapperFunc("db", strings.ToLower)
+
+// querySubscriptions scans the columns of sp_replmonitorhelpsubscription held by SubscriptionRow and
+// discards the others
+func querySubscriptions(ctx context.Context, con *connection.SQLConnection, query string) ([]*SubscriptionRow, error) {
+	rows, err := con.QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make([]*SubscriptionRow, 0)
+	for rows.Next() {
+		subscription := &SubscriptionRow{}
+		fields := subscriptionColumns.FieldMap(reflect.ValueOf(subscription))
+		destinations := make([]interface{}, len(columns))
+		for idx, column := range columns {
+			if field, ok := fields[strings.ToLower(column)]; ok {
+				destinations[idx] = field.Addr().Interface()
+			} else {
+				destinations[idx] = new(interface{})
+			}
+		}
+		if err := rows.Scan(destinations...); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (p *publicationSample) add(sample subscriptionSample) {
+	p.Subscriptions++
+	if sample.Status != nil && *sample.Status == agentStatusFailed {
+		p.FailedSubscriptions++
+	}
+	if sample.Warning != nil && *sample.Warning != 0 {
+		p.WarningSubscriptions++
+	}
+	if sample.Latency != nil {
+		p.MaxLatency = max(p.MaxLatency, *sample.Latency)
+	}
+	if sample.DistributionAgentRow != nil && sample.UndistributedCommands != nil {
+		p.UndistributedCommands += *sample.UndistributedCommands
+	}
+}
+
+// publicationEntity returns the entity of the publication of subscription, identified by its
+// publisher and database because publication names are only unique within a database
+func publicationEntity(i *integration.Integration, host string, subscription *SubscriptionRow) (*integration.Entity, error) {
+	return i.EntityReportedVia(host, subscription.Publication, publicationNamespace,
+		integration.NewIDAttribute("publisher", subscription.Publisher),
+		integration.NewIDAttribute("publisherDatabase", subscription.PublisherDB))
+}
+
+// quoteIdentifier quotes name for use as a database name in a query
+func quoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// publicationAttributes names the distribution database and the publication of subscription
+func publicationAttributes(distributionDB string, subscription *SubscriptionRow) []attribute.Attribute {
+	return []attribute.Attribute{
+		{Key: "distributionDatabase", Value: distributionDB},
+		{Key: "publisher", Value: subscription.Publisher},
+		{Key: "publisherDatabase", Value: subscription.PublisherDB},
+		{Key: "publication", Value: subscription.Publication},
+	}
+}
@@ -0,0 +1,117 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func findEntity(t *testing.T, i *integration.Integration, namespace, name string) *integration.Entity {
+	t.Helper()
+	for _, e := range i.Entities {
+		if e.Metadata.Namespace == namespace && e.Metadata.Name == name {
+			return e
+		}
+	}
+	t.Fatalf("entity %s:%s not found", namespace, name)
+	return nil
+}
+
+func TestPopulateReplicationMetrics(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`SELECT name FROM sys\.databases WHERE is_distributor = 1`).WillReturnRows(
+		sqlmock.NewRows([]string{"name"}).AddRow("distribution"))
+	mock.ExpectQuery(`EXEC \[distribution\]\.sys\.sp_replmonitorhelpsubscription @publisher = NULL, @publication_type = 0`).WillReturnRows(
+		sqlmock.NewRows([]string{"status", "warning", "subscriber", "subscriber_db", "publisher_db", "publication", "publication_type", "latency", "agentnotrunning", "distribution_agentname", "monitorranking", "publisher"}).
+			AddRow(3, 0, "REPORT01", "sales_reporting", "sales", "pub-orders", 0, 4, 0, "SQL01-sales-pub-orders-REPORT01-1", 30, "SQL01").
+			AddRow(6, 2, "REPORT02", "sales_reporting", "sales", "pub-orders", 0, 900, 0, "SQL01-sales-pub-orders-REPORT02-2", 60, "SQL01").
+			AddRow(4, 0, "REPORT01", "hr_reporting", "hr", "pub-staff", 0, nil, 0, "SQL01-hr-pub-staff-REPORT01-3", 10, "SQL01"))
+	mock.ExpectQuery(`FROM \[distribution\]\.dbo\.MSdistribution_agents da`).WillReturnRows(
+		sqlmock.NewRows([]string{"agent_name", "delivery_latency", "current_delivery_rate", "seconds_since_last_action", "undistributed_commands"}).
+			AddRow("SQL01-sales-pub-orders-REPORT01-1", 3500, 120.5, 10, 40).
+			AddRow("SQL01-sales-pub-orders-REPORT02-2", nil, nil, 1800, 250000))
+
+	PopulateReplicationMetrics(context.Background(), i, conn, 0)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	orders := findEntity(t, i, publicationNamespace, "pub-orders")
+	require.Len(t, orders.Metrics, 3)
+
+	report01 := orders.Metrics[0].Metrics
+	assert.Equal(t, "MssqlReplicationSubscriptionSample", report01["event_type"])
+	assert.Equal(t, "SQL01", report01["publisher"])
+	assert.Equal(t, "sales", report01["publisherDatabase"])
+	assert.Equal(t, "distribution", report01["distributionDatabase"])
+	assert.Equal(t, "REPORT01", report01["subscriber"])
+	assert.Equal(t, "In progress", report01["subscription.agentStatus"])
+	assert.Equal(t, float64(4), report01["subscription.latencyInSeconds"])
+	assert.Equal(t, float64(3500), report01["subscription.deliveryLatencyInMilliseconds"])
+	assert.Equal(t, float64(40), report01["subscription.undistributedCommands"])
+	assert.NotContains(t, report01, "subscription.monitorranking")
+
+	report02 := orders.Metrics[1].Metrics
+	assert.Equal(t, "Failed", report02["subscription.agentStatus"])
+	assert.Equal(t, float64(2), report02["subscription.warning"])
+	assert.NotContains(t, report02, "subscription.deliveryLatencyInMilliseconds")
+
+	publication := orders.Metrics[2].Metrics
+	assert.Equal(t, "MssqlReplicationPublicationSample", publication["event_type"])
+	assert.Equal(t, "pub-orders", publication["publication"])
+	assert.Equal(t, float64(2), publication["publication.subscriptions"])
+	assert.Equal(t, float64(1), publication["publication.failedSubscriptions"])
+	assert.Equal(t, float64(1), publication["publication.subscriptionsWithWarnings"])
+	assert.Equal(t, float64(900), publication["publication.maxLatencyInSeconds"])
+	assert.Equal(t, float64(250040), publication["publication.undistributedCommands"])
+
+	staff := findEntity(t, i, publicationNamespace, "pub-staff")
+	require.Len(t, staff.Metrics, 2)
+	assert.NotContains(t, staff.Metrics[0].Metrics, "subscription.undistributedCommands", "the agent has no row")
+	assert.Equal(t, float64(0), staff.Metrics[1].Metrics["publication.undistributedCommands"])
+}
+
+func TestPopulateReplicationMetrics_NoDistributor(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.databases WHERE is_distributor = 1`).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	PopulateReplicationMetrics(context.Background(), i, conn, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, i.Entities)
+}
+
+func TestPopulateReplicationMetrics_QueryError(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	mock.ExpectQuery(`FROM sys\.databases WHERE is_distributor = 1`).WillReturnRows(
+		sqlmock.NewRows([]string{"name"}).AddRow("dist]db"))
+	mock.ExpectQuery(`EXEC \[dist\]\]db\]\.sys\.sp_replmonitorhelpsubscription`).
+		WillReturnError(errors.New("The EXECUTE permission was denied"))
+
+	PopulateReplicationMetrics(context.Background(), i, conn, 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, i.Entities)
+}
+
+func TestPopulateReplicationMetrics_AzureSQLDatabase(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+	conn, mock := connection.CreateMockSQL(t)
+
+	PopulateReplicationMetrics(context.Background(), i, conn, database.AzureSQLDatabaseEngineEditionNumber)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, i.Entities)
+}
@@ -0,0 +1,158 @@
+// Package secrets resolves credential references such as file:, env: and exec: into their values
+// so that passwords and client secrets do not have to be stored in the integration config file
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix = "file:"
+	envPrefix  = "env:"
+	execPrefix = "exec:"
+
+	// redactedValue replaces any secret value found in an error message
+	redactedValue = "*****"
+)
+
+// ExecTimeout bounds how long an exec: helper may run before it is killed
+var ExecTimeout = 10 * time.Second
+
+var (
+	ErrEmptySecret      = errors.New("secret reference resolved to an empty value")
+	ErrEnvNotSet        = errors.New("environment variable is not set")
+	ErrInvalidReference = errors.New("invalid secret reference")
+)
+
+// IsReference reports whether value uses one of the supported reference prefixes
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, filePrefix) || strings.HasPrefix(value, envPrefix) || strings.HasPrefix(value, execPrefix)
+}
+
+// Resolve returns the secret referenced by value:
+//   - file:/path reads the file and trims surrounding whitespace
+//   - env:NAME reads the NAME environment variable
+//   - exec:/path/to/helper [args] runs the helper and uses its trimmed stdout
+//
+// Any other value is returned unchanged. Returned errors never contain the secret itself.
+func Resolve(value string) (string, error) {
+	var (
+		secret string
+		err    error
+	)
+
+	switch {
+	case strings.HasPrefix(value, filePrefix):
+		secret, err = resolveFile(strings.TrimPrefix(value, filePrefix))
+	case strings.HasPrefix(value, envPrefix):
+		secret, err = resolveEnv(strings.TrimPrefix(value, envPrefix))
+	case strings.HasPrefix(value, execPrefix):
+		secret, err = resolveExec(strings.TrimPrefix(value, execPrefix))
+	default:
+		return value, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%w: %s", ErrEmptySecret, referenceKind(value))
+	}
+	return secret, nil
+}
+
+func resolveFile(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("%w: file path is empty", ErrInvalidReference)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		// os errors only carry the path and the cause, never the content
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func resolveEnv(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: environment variable name is empty", ErrInvalidReference)
+	}
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrEnvNotSet, name)
+	}
+	return strings.TrimSpace(secret), nil
+}
+
+func resolveExec(command string) (string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: exec command is empty", ErrInvalidReference)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExecTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...) //nolint:gosec // the helper is configured by the operator
+	cmd.Stdout = &stdout
+	// stderr is discarded on purpose: helpers may echo credentials while failing
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("secret helper %s timed out after %s", fields[0], ExecTimeout)
+		}
+		return "", fmt.Errorf("secret helper %s failed: %w", fields[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func referenceKind(value string) string {
+	return value[:strings.Index(value, ":")+1]
+}
+
+// Redact returns an error whose message has every non-empty secret, including its URL
+// encoded forms, replaced. It returns nil when err is nil.
+func Redact(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	redacted := message
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		for _, form := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+			redacted = strings.ReplaceAll(redacted, form, redactedValue)
+		}
+	}
+
+	if redacted == message {
+		return err
+	}
+	return &redactedError{message: redacted, err: err}
+}
+
+// redactedError keeps the original error available to errors.Is/As while only exposing
+// the redacted message
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (r *redactedError) Error() string {
+	return r.message
+}
+
+func (r *redactedError) Unwrap() error {
+	return r.err
+}
@@ -0,0 +1,148 @@
+// Package state keeps values between runs of the integration so that cumulative counters
+// reported by SQL Server can be turned into per-interval values
+package state
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+)
+
+const (
+	// storeName keeps the file apart from the one the SDK uses for its own delta and rate metrics
+	storeName = "com.newrelic.mssql.counters"
+	// retention is how long values are kept in the file, which bounds the longest interval Due can wait
+	retention = 24 * time.Hour
+)
+
+// Counters is a set of cumulative counters sampled together, indexed by name
+type Counters map[string]int64
+
+// Store remembers the counters sampled on the previous run and when periodic tasks last ran.
+// It is safe for concurrent use.
+type Store struct {
+	storer     persist.Storer
+	counterTTL time.Duration
+}
+
+// NewStore returns a Store backed by storer. Counters older than counterTTL are ignored, 0 keeps them forever.
+func NewStore(storer persist.Storer, counterTTL time.Duration) *Store {
+	return &Store{storer: storer, counterTTL: counterTTL}
+}
+
+// NewInMemoryStore returns a Store that forgets its values when the process exits
+func NewInMemoryStore() *Store {
+	return NewStore(persist.NewInMemoryStore(), 0)
+}
+
+// Open returns a Store persisted in tempDir, identified by id so that every configured
+// integration instance keeps its own values. Counters older than counterTTL are ignored.
+func Open(id, tempDir string, counterTTL time.Duration) (*Store, error) {
+	fileTTL := max(counterTTL, retention)
+	storePath, err := persist.NewStorePath(storeName, id, tempDir, globalLogger{}, fileTTL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate state store: %w", err)
+	}
+	storePath.CleanOldFiles()
+
+	storer, err := persist.NewFileStore(storePath.GetFilePath(), globalLogger{}, fileTTL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open state store: %w", err)
+	}
+	return NewStore(storer, counterTTL), nil
+}
+
+// Delta stores current under key and returns how much each counter grew since the previous
+// run. ok is false on the first run, when the previous values are too old, and when any counter
+// went down, which happens when SQL Server restarts or the object the counters belong to is recreated.
+func (s *Store) Delta(key string, current Counters) (delta Counters, ok bool) {
+	var previous Counters
+	storedAt, err := s.storer.Get(key, &previous)
+	s.storer.Set(key, current)
+
+	if err != nil {
+		if !errors.Is(err, persist.ErrNotFound) {
+			log.Debug("Unable to read previous value of %s: %s", key, err.Error())
+		}
+		return nil, false
+	}
+	if s.counterTTL > 0 && time.Since(time.Unix(storedAt, 0)) > s.counterTTL {
+		return nil, false
+	}
+
+	delta = make(Counters, len(current))
+	for name, value := range current {
+		before, found := previous[name]
+		if !found || value < before {
+			return nil, false
+		}
+		delta[name] = value - before
+	}
+	return delta, true
+}
+
+// Due returns true when the task identified by key last ran at least interval ago, or never
+// ran. Intervals longer than a day are not remembered.
+func (s *Store) Due(key string, interval time.Duration) bool {
+	var lastRun int64
+	_, err := s.storer.Get(key, &lastRun)
+	return err != nil || time.Since(time.Unix(lastRun, 0)) >= interval
+}
+
+// MarkRun records that the task identified by key ran now. Tasks call it once they succeed so
+// that a failed run is retried on the next one instead of a whole interval later.
+func (s *Store) MarkRun(key string) {
+	s.storer.Set(key, time.Now().Unix())
+}
+
+// HighWaterMark returns the highest position, such as a record id or a timestamp, that a previous
+// run recorded under key. ok is false when none was recorded.
+func (s *Store) HighWaterMark(key string) (mark int64, ok bool) {
+	if _, err := s.storer.Get(key, &mark); err != nil {
+		return 0, false
+	}
+	return mark, true
+}
+
+// SetHighWaterMark records mark under key for the next run
+func (s *Store) SetHighWaterMark(key string, mark int64) {
+	s.storer.Set(key, mark)
+}
+
+// filePosition is where a previous run stopped reading a file
+type filePosition struct {
+	File   string
+	Offset int64
+}
+
+// FilePosition returns the file and the offset in it where a previous run recorded under key
+// stopped reading. ok is false when none was recorded.
+func (s *Store) FilePosition(key string) (file string, offset int64, ok bool) {
+	var position filePosition
+	if _, err := s.storer.Get(key, &position); err != nil {
+		return "", 0, false
+	}
+	return position.File, position.Offset, true
+}
+
+// SetFilePosition records under key the file and offset where the next run resumes reading
+func (s *Store) SetFilePosition(key, file string, offset int64) {
+	s.storer.Set(key, filePosition{File: file, Offset: offset})
+}
+
+// Save persists the values stored during this run
+func (s *Store) Save() error {
+	return s.storer.Save()
+}
+
+// globalLogger sends the messages of the persist package to the integration log, so they
+// follow its verbosity
+type globalLogger struct{}
+
+func (globalLogger) Debugf(format string, args ...interface{}) { log.Debug(format, args...) }
+func (globalLogger) Infof(format string, args ...interface{})  { log.Info(format, args...) }
+func (globalLogger) Warnf(format string, args ...interface{})  { log.Warn(format, args...) }
+func (globalLogger) Errorf(format string, args ...interface{}) { log.Error(format, args...) }
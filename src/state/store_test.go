@@ -0,0 +1,121 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Delta(t *testing.T) {
+	store := NewInMemoryStore()
+
+	_, ok := store.Delta("file", Counters{"reads": 10, "writes": 4})
+	assert.False(t, ok, "first sample has no previous value")
+
+	delta, ok := store.Delta("file", Counters{"reads": 25, "writes": 4})
+	require.True(t, ok)
+	assert.Equal(t, Counters{"reads": 15, "writes": 0}, delta)
+
+	_, ok = store.Delta("file", Counters{"reads": 3, "writes": 5})
+	assert.False(t, ok, "a counter going down is a reset")
+
+	delta, ok = store.Delta("file", Counters{"reads": 7, "writes": 9})
+	require.True(t, ok)
+	assert.Equal(t, Counters{"reads": 4, "writes": 4}, delta)
+
+	_, ok = store.Delta("file", Counters{"reads": 8, "writes": 9, "bytes": 1})
+	assert.False(t, ok, "a new counter has no previous value")
+
+	_, ok = store.Delta("other", Counters{"reads": 8})
+	assert.False(t, ok, "keys are independent")
+}
+
+func TestStore_Delta_Expired(t *testing.T) {
+	store := NewStore(persist.NewInMemoryStore(), time.Minute)
+
+	persist.SetNow(func() time.Time { return time.Now().Add(-2 * time.Minute) })
+	store.Delta("file", Counters{"reads": 10})
+	persist.SetNow(time.Now)
+
+	_, ok := store.Delta("file", Counters{"reads": 12})
+	assert.False(t, ok, "values older than the counter TTL are ignored")
+
+	delta, ok := store.Delta("file", Counters{"reads": 15})
+	require.True(t, ok)
+	assert.Equal(t, Counters{"reads": 3}, delta)
+}
+
+func TestStore_HighWaterMark(t *testing.T) {
+	store := NewInMemoryStore()
+
+	_, ok := store.HighWaterMark("ring")
+	assert.False(t, ok, "nothing recorded yet")
+
+	store.SetHighWaterMark("ring", 42)
+	mark, ok := store.HighWaterMark("ring")
+	require.True(t, ok)
+	assert.Equal(t, int64(42), mark)
+
+	_, ok = store.HighWaterMark("other")
+	assert.False(t, ok, "keys are independent")
+}
+
+func TestStore_FilePosition(t *testing.T) {
+	store := NewInMemoryStore()
+
+	_, _, ok := store.FilePosition("xel")
+	assert.False(t, ok, "nothing recorded yet")
+
+	store.SetFilePosition("xel", `C:\Log\system_health_0_1.xel`, 4096)
+	file, offset, ok := store.FilePosition("xel")
+	require.True(t, ok)
+	assert.Equal(t, `C:\Log\system_health_0_1.xel`, file)
+	assert.Equal(t, int64(4096), offset)
+}
+
+func TestStore_Due(t *testing.T) {
+	storer := persist.NewInMemoryStore()
+	store := NewStore(storer, time.Minute)
+
+	assert.True(t, store.Due("advisor", time.Hour), "never ran")
+	assert.True(t, store.Due("advisor", time.Hour), "not marked, so it is still due")
+	store.MarkRun("advisor")
+	assert.False(t, store.Due("advisor", time.Hour), "ran just now")
+	assert.True(t, store.Due("other", time.Hour), "keys are independent")
+
+	storer.Set("advisor", time.Now().Add(-61*time.Minute).Unix())
+	assert.True(t, store.Due("advisor", time.Hour))
+}
+
+func TestStore_PersistedBetweenRuns(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := Open("id", dir, time.Minute)
+	require.NoError(t, err)
+	first.Delta("file", Counters{"reads": 10})
+	first.SetFilePosition("xel", "system_health_0_1.xel", 4096)
+	require.NoError(t, first.Save())
+
+	files, err := filepath.Glob(filepath.Join(dir, storeName+"-id.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	second, err := Open("id", dir, time.Minute)
+	require.NoError(t, err)
+	delta, ok := second.Delta("file", Counters{"reads": 12})
+	require.True(t, ok)
+	assert.Equal(t, Counters{"reads": 2}, delta)
+	file, offset, ok := second.FilePosition("xel")
+	require.True(t, ok)
+	assert.Equal(t, "system_health_0_1.xel", file)
+	assert.Equal(t, int64(4096), offset)
+
+	other, err := Open("other-id", dir, time.Minute)
+	require.NoError(t, err)
+	_, ok = other.Delta("file", Counters{"reads": 12})
+	assert.False(t, ok)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/common"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// collectedTarget is an instance from the instances file together with its own connections
+type collectedTarget struct {
+	args      args.ArgumentList
+	manager   *connection.Manager
+	budget    *common.Budget
+	collected bool
+}
+
+// collectTargets collects every instance listed in the instances file using a bounded pool
+// of workers. An instance that fails is logged and does not prevent the others from reporting.
+func collectTargets(i *integration.Integration, arguments args.ArgumentList, store *state.Store) error {
+	targets, err := args.LoadTargets(arguments.InstancesFile)
+	if err != nil {
+		return err
+	}
+
+	collected := make([]*collectedTarget, 0, len(targets))
+	defer func() {
+		for _, target := range collected {
+			target.manager.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, arguments.GetMaxConcurrentInstances())
+
+	for _, t := range targets {
+		targetArgs := arguments.ForTarget(t)
+		if err := targetArgs.Validate(); err != nil {
+			log.Error("Skipping instance %s, configuration error: %s", t.Hostname, err)
+			continue
+		}
+
+		target := &collectedTarget{args: targetArgs, budget: newBudget(targetArgs)}
+		target.manager = connection.NewManager(&target.args)
+		collected = append(collected, target)
+
+		wg.Add(1)
+		go func(labels map[string]string) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			if err := collectInstance(i, target.args, target.manager, target.budget, store, labels); err != nil {
+				logCollectionError(target.args.Hostname, err)
+				return
+			}
+			target.collected = true
+		}(t.Labels)
+	}
+
+	wg.Wait()
+
+	if err := i.Publish(); err != nil {
+		return err
+	}
+
+	// Query analysis publishes as it goes, so instances are analysed one at a time to keep
+	// their payloads apart
+	if arguments.EnableQueryMonitoring {
+		for _, target := range collected {
+			if target.collected {
+				populateQueryMonitoring(i, target.args, target.manager, target.budget)
+			}
+		}
+	}
+
+	if arguments.EnableSelfOverheadMetrics {
+		for _, target := range collected {
+			if target.collected {
+				populateSelfOverhead(i, target.manager)
+			}
+		}
+		return i.Publish()
+	}
+
+	return nil
+}